@@ -0,0 +1,41 @@
+package checkser
+
+// enableAppendOnly enables append-only mode for the checksums, if not yet enabled.
+func (scan *Scan) enableAppendOnly(cs *Checksums) {
+	if cs.AppendOnly {
+		return
+	}
+	cs.AppendOnly = true
+	cs.appendOnlyEnabled = true
+	scan.Stats.AppendOnlyEnabled.Add(1)
+}
+
+// violatesAppendOnly returns whether the change is not allowed in append-only mode.
+func violatesAppendOnly(change Change) bool {
+	switch change {
+	case Removed, Changed, TimestampChanged:
+		return true
+	default:
+		return false
+	}
+}
+
+// keepRecorded keeps the recorded state of entries that failed, so that
+// checksum entries are never dropped in append-only mode.
+func keepRecorded(cs *Checksums) {
+	for _, file := range cs.Files {
+		if file.Change == Failed && file.Algorithm != "" {
+			file.Change = NoChange
+		}
+	}
+	for _, dir := range cs.Directories {
+		if dir.Change == Failed && dir.Algorithm != "" {
+			dir.Change = NoChange
+		}
+	}
+	for _, special := range cs.Specials {
+		if special.Change == Failed && special.Type != "" {
+			special.Change = NoChange
+		}
+	}
+}
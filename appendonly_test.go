@@ -0,0 +1,70 @@
+package checkser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendOnlyRefusesChanges(t *testing.T) {
+	t.Parallel()
+
+	// Create tree in append-only mode.
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "keep me\n")
+	scan := updateTree(t, dir, ScanConfig{AppendOnly: true})
+	if scan.Stats.AppendOnlyEnabled.Load() == 0 {
+		t.Fatal("append-only mode not enabled")
+	}
+
+	// Change, remove and add files. Append-only mode is recorded in the
+	// checksum file, so it must be enforced without the config.
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello, new world\n")
+	if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "c.txt"), "new\n")
+	scan = updateTree(t, dir, ScanConfig{})
+	if violations := scan.Stats.PolicyViolations.Load(); violations != 2 {
+		t.Fatalf("%d violations, expected 2", violations)
+	}
+	for _, file := range scan.rootSum.Files {
+		switch file.Name {
+		case "a.txt", "b.txt":
+			if file.Action != ActionFail {
+				t.Errorf("%s: action is %q, expected %q", file.Name, file.Action, ActionFail)
+			}
+		case "c.txt":
+			if file.Action == ActionFail {
+				t.Errorf("%s: added file refused", file.Name)
+			}
+		}
+	}
+
+	// Check that the recorded state was kept.
+	data, err := os.ReadFile(filepath.Join(dir, ChecksumFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case !cs.AppendOnly:
+		t.Fatal("append-only mode lost")
+	case cs.GetFile("a.txt") == nil || cs.GetFile("a.txt").Size != 6:
+		t.Fatal("changed file was recorded")
+	case cs.GetFile("b.txt") == nil:
+		t.Fatal("removed file was dropped")
+	case cs.GetFile("c.txt") == nil:
+		t.Fatal("added file was not recorded")
+	}
+
+	// The change must still be detected.
+	scan = scanTree(t, dir, ScanConfig{})
+	if changed := scan.Stats.Files.Changed.Load(); changed != 1 {
+		t.Fatalf("%d changed files, expected 1", changed)
+	}
+}
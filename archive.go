@@ -0,0 +1,321 @@
+package checkser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Member is a file in an archive. Archives are treated as virtual dirs, so
+// that changed members can be pinpointed. Members are digested with the
+// algorithm of their archive.
+type Member struct {
+	// Name is the slash separated path of the member in the archive.
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	Size   int64  `json:"size,omitempty" yaml:"size,omitempty"`
+	Digest string `json:"sum,omitempty" yaml:"sum,omitempty"`
+
+	// Extra holds unknown fields, which are preserved when rewriting.
+	Extra map[string]any `json:"-" yaml:",inline"`
+	// binUnknown holds unknown integer keys of binary checksum files.
+	binUnknown binUnknown
+
+	Change Change `json:"-" yaml:"-"`
+}
+
+// Archive formats.
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveFormat returns the archive format of the file with the given name,
+// or an empty string if it is not a supported archive.
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	default:
+		return ""
+	}
+}
+
+// GetMember returns the member with the given name.
+func (file *File) GetMember(name string) *Member {
+	idx := slices.IndexFunc(file.Members, func(m *Member) bool {
+		return m.Name == name
+	})
+	if idx >= 0 {
+		return file.Members[idx]
+	}
+	return nil
+}
+
+// needsIndex returns whether the file is an archive that should be digested
+// to index its members.
+func (scan *Scan) needsIndex(file *File) bool {
+	return scan.cfg.Archives && len(file.Members) == 0 && archiveFormat(file.Name) != ""
+}
+
+// digestArchive digests the archive and indexes its members in one pass.
+// Zip archives that cannot be streamed are read a second time to index their
+// members. Indexing errors are added to the file.
+func (scan *Scan) digestArchive(h Hash, file *File) (string, error) {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	hasher, err := h.newHasher(scan.cfg.Key)
+	if err != nil {
+		return "", err
+	}
+	defer hasher.Reset() // Internal state may leak data if kept in memory.
+
+	var r io.Reader = &statsReader{r: f, stats: scan.Stats}
+	if scan.readLimit != nil {
+		r = &rateLimitedReader{r: r, rl: scan.readLimit}
+	}
+	r = io.TeeReader(r, hasher)
+
+	// Index members while reading the archive.
+	var members []*Member
+	var indexErr error
+	format := archiveFormat(file.Name)
+	if format == archiveZip {
+		members, indexErr = indexZipStream(h, scan.cfg.Key, r)
+	} else {
+		members, indexErr = indexTar(h, scan.cfg.Key, r, format == archiveTarGz)
+	}
+
+	// Read the rest of the file, eg. padding or after an error.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	if errors.Is(indexErr, errZipNotStreamable) {
+		members, indexErr = indexZip(h, scan.cfg.Key, f)
+	}
+
+	// Compare with recorded members.
+	if indexErr != nil {
+		file.ErrMsgs = append(file.ErrMsgs, fmt.Sprintf("failed to index archive: %s", indexErr))
+		return sum, nil
+	}
+	file.Changed.Members = mergeMembers(file.Members, members, file.Algorithm == string(h))
+	return sum, nil
+}
+
+// indexTar digests all regular files of a tar archive.
+func indexTar(h Hash, key []byte, r io.Reader, gzipped bool) ([]*Member, error) {
+	if gzipped {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close() //nolint:errcheck
+		r = zr
+	}
+
+	index := make(map[string]*Member)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return sortedMembers(index), nil
+		case err != nil:
+			return nil, err
+		case !hdr.FileInfo().Mode().IsRegular():
+			continue
+		}
+
+		name := memberName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		sum, err := h.DigestReaderWithKey(tr, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		// Later entries replace earlier ones, as when extracting.
+		index[name] = &Member{
+			Name:   name,
+			Size:   hdr.Size,
+			Digest: sum,
+		}
+	}
+}
+
+// indexZip digests all regular files of a zip archive with random access.
+// This reads the archive a second time, after it was digested as a whole,
+// for archives that cannot be streamed.
+// The errors of all members that cannot be read are returned.
+func indexZip(h Hash, key []byte, f *os.File) ([]*Member, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*Member)
+	var errs []error
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		name := memberName(zf.Name)
+		if name == "" {
+			continue
+		}
+		sum, err := digestZipMember(h, key, zf)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		index[name] = &Member{
+			Name:   name,
+			Size:   int64(zf.UncompressedSize64), //nolint:gosec // Checked by zip reader.
+			Digest: sum,
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return sortedMembers(index), nil
+}
+
+func digestZipMember(h Hash, key []byte, zf *zip.File) (string, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close() //nolint:errcheck
+
+	// Reading until EOF also verifies the CRC32 of the member.
+	return h.DigestReaderWithKey(rc, key)
+}
+
+// memberName cleans the name of a member. Names that leave the archive are
+// kept inside, like most tools extract them.
+func memberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func sortedMembers(index map[string]*Member) []*Member {
+	members := make([]*Member, 0, len(index))
+	for _, member := range index {
+		members = append(members, member)
+	}
+	slices.SortFunc(members, func(a, b *Member) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return members
+}
+
+// mergeMembers sets the change of all found members compared to the recorded
+// ones, and adds the removed members. Digests are only compared if the
+// archive is digested with its recorded algorithm.
+func mergeMembers(recorded, found []*Member, compareDigests bool) []*Member {
+	recordedIndex := make(map[string]*Member, len(recorded))
+	for _, member := range recorded {
+		recordedIndex[member.Name] = member
+	}
+
+	merged := make([]*Member, 0, len(found))
+	for _, member := range found {
+		old, ok := recordedIndex[member.Name]
+		switch {
+		case !ok:
+			member.Change = Added
+		case old.Size != member.Size,
+			compareDigests && !strings.EqualFold(old.Digest, member.Digest):
+			member.Change = Changed
+		default:
+			member.Change = NoChange
+		}
+		if ok {
+			member.Extra = old.Extra
+			member.binUnknown = old.binUnknown
+			delete(recordedIndex, member.Name)
+		}
+		merged = append(merged, member)
+	}
+
+	// Add remaining recorded members as removed.
+	for _, member := range recorded {
+		if _, ok := recordedIndex[member.Name]; ok {
+			merged = append(merged, &Member{
+				Name:   member.Name,
+				Size:   member.Size,
+				Digest: member.Digest,
+				Change: Removed,
+			})
+		}
+	}
+	return merged
+}
+
+// MemberChanges returns the members of the archive that changed, were added
+// or were removed, if the archive was indexed.
+func (file *File) MemberChanges() []*Member {
+	var changes []*Member
+	for _, member := range file.Changed.Members {
+		if member.Change != NoChange {
+			changes = append(changes, member)
+		}
+	}
+	return changes
+}
+
+// MemberPath returns the path of the member, as if the archive was a dir.
+func (file *File) MemberPath(member *Member) string {
+	return filepath.Join(file.Path, filepath.FromSlash(member.Name))
+}
+
+// membersIndexed returns whether the members of an unchanged archive were
+// indexed and need to be recorded.
+func (file *File) membersIndexed() bool {
+	return file.Change == NoChange &&
+		file.Changed.Digest == file.Digest &&
+		len(file.MemberChanges()) > 0
+}
+
+// applyMembers records the indexed members of the archive. Members of
+// archives that changed without being indexed are dropped, as they are stale.
+// It must be called before the digest of the file is applied.
+func (file *File) applyMembers() {
+	if file.Change == NoChange && file.Changed.Digest != file.Digest {
+		// Not digested or change refused.
+		return
+	}
+
+	switch {
+	case file.Changed.Members != nil:
+		file.Members = slices.DeleteFunc(slices.Clone(file.Changed.Members), func(m *Member) bool {
+			return m.Change == Removed
+		})
+	case file.Changed.Digest != file.Digest:
+		file.Members = nil
+	}
+}
@@ -0,0 +1,167 @@
+package checkser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestZip writes a zip archive with a dir, a deflated member with a
+// data descriptor and a stored member with known sizes.
+func writeTestZip(t *testing.T, name string, members map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create("sub/"); err != nil {
+		t.Fatal(err)
+	}
+	for _, memberName := range []string{"a.txt", "sub/b.txt"} {
+		data := members[memberName]
+		if memberName == "a.txt" {
+			w, err := zw.Create(memberName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               memberName,
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE([]byte(data)),
+			CompressedSize64:   uint64(len(data)),
+			UncompressedSize64: uint64(len(data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, name, buf.String())
+	return buf.Bytes()
+}
+
+func writeTestTar(t *testing.T, name string, members map[string]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, memberName := range []string{"a.txt", "sub/b.txt"} {
+		data := members[memberName]
+		if err := tw.WriteHeader(&tar.Header{Name: memberName, Mode: 0o644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, name, buf.String())
+}
+
+func TestArchiveMemberChanges(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"test.zip", "test.tar"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, name)
+			writeArchive := writeTestTar
+			if name == "test.zip" {
+				writeArchive = func(t *testing.T, name string, members map[string]string) {
+					t.Helper()
+					writeTestZip(t, name, members)
+				}
+			}
+			cfg := ScanConfig{Archives: true, DigestAll: true}
+
+			writeArchive(t, path, map[string]string{"a.txt": "hello\n", "sub/b.txt": "corrupt me\n"})
+			updateTree(t, dir, cfg)
+			assertUnchanged(t, scanTree(t, dir, cfg))
+
+			// A changed member is pinpointed.
+			writeArchive(t, path, map[string]string{"a.txt": "hello\n", "sub/b.txt": "corrupt m3\n"})
+			file := findFile(scanTree(t, dir, cfg), name)
+			switch {
+			case file == nil:
+				t.Fatal("archive not found")
+			case file.Change != Changed:
+				t.Fatalf("archive change is %s, expected changed", file.Change.Name())
+			case len(file.ErrMsgs) > 0:
+				t.Fatalf("unexpected errors: %v", file.ErrMsgs)
+			}
+			changes := file.MemberChanges()
+			switch {
+			case len(changes) != 1:
+				t.Fatalf("%d changed members, expected 1", len(changes))
+			case changes[0].Name != "sub/b.txt" || changes[0].Change != Changed:
+				t.Fatalf("unexpected member change %s of %s", changes[0].Change.Name(), changes[0].Name)
+			}
+		})
+	}
+}
+
+func TestZipIndexStreamed(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.zip")
+	data := writeTestZip(t, path, map[string]string{"a.txt": "hello\n", "sub/b.txt": "corrupt me\n"})
+
+	// Streaming finds the same members as random access.
+	streamed, err := indexZipStream(SHA2_256, nil, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to stream zip: %s", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+	indexed, err := indexZip(SHA2_256, nil, f)
+	if err != nil {
+		t.Fatalf("failed to index zip: %s", err)
+	}
+	if len(streamed) != 2 || len(indexed) != len(streamed) {
+		t.Fatalf("streamed %d and indexed %d members, expected 2", len(streamed), len(indexed))
+	}
+	for i, member := range streamed {
+		if member.Name != indexed[i].Name || member.Size != indexed[i].Size || member.Digest != indexed[i].Digest {
+			t.Fatalf("streamed member %+v differs from indexed %+v", member, indexed[i])
+		}
+	}
+
+	// Corrupted members fail their CRC check.
+	corrupted := bytes.Clone(data)
+	i := bytes.Index(corrupted, []byte("corrupt me\n"))
+	if i < 0 {
+		t.Fatal("member data not found in zip")
+	}
+	corrupted[i] ^= 1
+	if _, err := indexZipStream(SHA2_256, nil, bytes.NewReader(corrupted)); !errors.Is(err, zip.ErrChecksum) {
+		t.Fatalf("unexpected error for corrupted member: %v", err)
+	}
+
+	// Data before the first member needs random access.
+	prefixed := append([]byte("prefix"), data...)
+	if _, err := indexZipStream(SHA2_256, nil, bytes.NewReader(prefixed)); !errors.Is(err, errZipNotStreamable) {
+		t.Fatalf("unexpected error for prefixed zip: %v", err)
+	}
+}
@@ -0,0 +1,479 @@
+package checkser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Binary checksum files are encoded as CBOR (RFC 8949).
+// Digests are stored as raw bytes and timestamps as unix nanoseconds.
+// Keys are small integers to keep the encoding compact.
+// Fields with string keys that are unknown to this version are kept in key 15,
+// like unknown fields of the text formats. Unknown integer keys, which newer
+// versions may add, are kept as is in the Unknown field of each map and are
+// written back when rewriting, so that no data is lost. Checksum files with
+// unknown integer keys are never converted to a text format.
+
+// maxDecompressedSize is the maximum size of a decompressed checksum file.
+// This is enough for dirs with millions of files.
+const maxDecompressedSize = 256 << 20 // 256 MiB
+
+var (
+	// cborMagic is the self-described CBOR tag (55799), used to detect the format.
+	cborMagic = []byte{0xd9, 0xd9, 0xf7}
+	// zstdMagic is the zstd frame magic number.
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	cborEnc cbor.EncMode
+	cborDec cbor.DecMode
+
+	zstdEnc *zstd.Encoder
+	zstdDec *zstd.Decoder
+)
+
+func init() {
+	var err error
+
+	// Use deterministic encoding, so that the same checksums always produce the same digest.
+	cborEnc, err = cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		panic(err)
+	}
+	// Lift limits for huge directories.
+	cborDec, err = cbor.DecOptions{
+		MaxArrayElements: math.MaxInt32,
+		MaxMapPairs:      math.MaxInt32,
+		DefaultMapType:   reflect.TypeOf(map[string]any(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+
+	zstdEnc, err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(err)
+	}
+	// Limit memory, as a small compressed file could expand to gigabytes.
+	zstdDec, err = zstd.NewReader(nil,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxMemory(maxDecompressedSize),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+type binChecksums struct {
+	Version   int    `cbor:"1,keyasint,omitempty"`
+	UpdatedAt int64  `cbor:"2,keyasint,omitempty"`
+	UpdatedBy string `cbor:"3,keyasint,omitempty"`
+
+	Files       []*binFile      `cbor:"4,keyasint,omitempty"`
+	Directories []*binDirectory `cbor:"5,keyasint,omitempty"`
+	Specials    []*binSpecial   `cbor:"6,keyasint,omitempty"`
+
+	Compat    int    `cbor:"7,keyasint,omitempty"`
+	Generator string `cbor:"8,keyasint,omitempty"`
+
+	AppendOnly bool `cbor:"9,keyasint,omitempty"`
+
+	Extra map[string]any `cbor:"15,keyasint,omitempty"`
+
+	Unknown binUnknown `cbor:"-"`
+}
+
+type binFile struct {
+	Name      string `cbor:"1,keyasint,omitempty"`
+	Size      int64  `cbor:"2,keyasint,omitempty"`
+	Modified  int64  `cbor:"3,keyasint,omitempty"`
+	Algorithm string `cbor:"4,keyasint,omitempty"`
+	Digest    []byte `cbor:"5,keyasint,omitempty"`
+	DigestHex string `cbor:"6,keyasint,omitempty"`
+
+	Members []*binMember `cbor:"7,keyasint,omitempty"`
+
+	Extra map[string]any `cbor:"15,keyasint,omitempty"`
+
+	Unknown binUnknown `cbor:"-"`
+}
+
+type binMember struct {
+	Name      string `cbor:"1,keyasint,omitempty"`
+	Size      int64  `cbor:"2,keyasint,omitempty"`
+	Digest    []byte `cbor:"5,keyasint,omitempty"`
+	DigestHex string `cbor:"6,keyasint,omitempty"`
+
+	Extra map[string]any `cbor:"15,keyasint,omitempty"`
+
+	Unknown binUnknown `cbor:"-"`
+}
+
+type binDirectory struct {
+	Name      string `cbor:"1,keyasint,omitempty"`
+	Algorithm string `cbor:"4,keyasint,omitempty"`
+	Digest    []byte `cbor:"5,keyasint,omitempty"`
+	DigestHex string `cbor:"6,keyasint,omitempty"`
+
+	Extra map[string]any `cbor:"15,keyasint,omitempty"`
+
+	Unknown binUnknown `cbor:"-"`
+}
+
+type binSpecial struct {
+	Name     string `cbor:"1,keyasint,omitempty"`
+	Type     string `cbor:"2,keyasint,omitempty"`
+	Modified int64  `cbor:"3,keyasint,omitempty"`
+
+	Extra map[string]any `cbor:"15,keyasint,omitempty"`
+
+	Unknown binUnknown `cbor:"-"`
+}
+
+func packBinary(cs *Checksums) ([]byte, error) {
+	bin := &binChecksums{
+		Version:     cs.Version,
+		Compat:      cs.Compat,
+		UpdatedAt:   binTime(cs.UpdatedAt),
+		UpdatedBy:   cs.UpdatedBy,
+		Generator:   cs.Generator,
+		AppendOnly:  cs.AppendOnly,
+		Extra:       cs.Extra,
+		Unknown:     cs.binUnknown,
+		Files:       make([]*binFile, 0, len(cs.Files)),
+		Directories: make([]*binDirectory, 0, len(cs.Directories)),
+		Specials:    make([]*binSpecial, 0, len(cs.Specials)),
+	}
+	for _, file := range cs.Files {
+		binFile := &binFile{
+			Name:      file.Name,
+			Size:      file.Size,
+			Modified:  binTime(file.Modified),
+			Algorithm: file.Algorithm,
+			Extra:     file.Extra,
+			Unknown:   file.binUnknown,
+		}
+		binFile.Digest, binFile.DigestHex = binDigest(file.Digest)
+		for _, member := range file.Members {
+			binMember := &binMember{
+				Name:    member.Name,
+				Size:    member.Size,
+				Extra:   member.Extra,
+				Unknown: member.binUnknown,
+			}
+			binMember.Digest, binMember.DigestHex = binDigest(member.Digest)
+			binFile.Members = append(binFile.Members, binMember)
+		}
+		bin.Files = append(bin.Files, binFile)
+	}
+	for _, dir := range cs.Directories {
+		binDir := &binDirectory{
+			Name:      dir.Name,
+			Algorithm: dir.Algorithm,
+			Extra:     dir.Extra,
+			Unknown:   dir.binUnknown,
+		}
+		binDir.Digest, binDir.DigestHex = binDigest(dir.Digest)
+		bin.Directories = append(bin.Directories, binDir)
+	}
+	for _, special := range cs.Specials {
+		bin.Specials = append(bin.Specials, &binSpecial{
+			Name:     special.Name,
+			Type:     special.Type,
+			Modified: binTime(special.Modified),
+			Extra:    special.Extra,
+			Unknown:  special.binUnknown,
+		})
+	}
+
+	// Encode.
+	buf := bytes.NewBuffer(nil)
+	buf.Write(cborMagic)
+	err := cborEnc.NewEncoder(buf).Encode(bin)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func loadBinary(data []byte, cs *Checksums) error {
+	bin := &binChecksums{}
+	err := cborDec.Unmarshal(data, bin)
+	if err != nil {
+		return err
+	}
+
+	cs.Version = bin.Version
+	cs.Compat = bin.Compat
+	cs.UpdatedAt = fromBinTime(bin.UpdatedAt)
+	cs.UpdatedBy = bin.UpdatedBy
+	cs.Generator = bin.Generator
+	cs.AppendOnly = bin.AppendOnly
+	cs.Extra = bin.Extra
+	cs.binUnknown = bin.Unknown
+	for _, binFile := range bin.Files {
+		file := &File{
+			Name:      binFile.Name,
+			Size:      binFile.Size,
+			Modified:  fromBinTime(binFile.Modified),
+			Algorithm: binFile.Algorithm,
+			Digest:    fromBinDigest(binFile.Digest, binFile.DigestHex),
+			Extra:     binFile.Extra,
+
+			binUnknown: binFile.Unknown,
+		}
+		for _, binMember := range binFile.Members {
+			file.Members = append(file.Members, &Member{
+				Name:   binMember.Name,
+				Size:   binMember.Size,
+				Digest: fromBinDigest(binMember.Digest, binMember.DigestHex),
+				Extra:  binMember.Extra,
+
+				binUnknown: binMember.Unknown,
+			})
+		}
+		cs.Files = append(cs.Files, file)
+	}
+	for _, binDir := range bin.Directories {
+		cs.Directories = append(cs.Directories, &Directory{
+			Name:      binDir.Name,
+			Algorithm: binDir.Algorithm,
+			Digest:    fromBinDigest(binDir.Digest, binDir.DigestHex),
+			Extra:     binDir.Extra,
+
+			binUnknown: binDir.Unknown,
+		})
+	}
+	for _, binSpecial := range bin.Specials {
+		cs.Specials = append(cs.Specials, &Special{
+			Name:     binSpecial.Name,
+			Type:     binSpecial.Type,
+			Modified: fromBinTime(binSpecial.Modified),
+			Extra:    binSpecial.Extra,
+
+			binUnknown: binSpecial.Unknown,
+		})
+	}
+
+	return nil
+}
+
+// binUnknown holds the entries of a binary map with integer keys that are
+// unknown to this version.
+type binUnknown map[uint64]cbor.RawMessage
+
+// hasBinUnknown returns whether the checksums or any of their entries hold
+// unknown integer keys, which cannot be written in a text format.
+func (cs *Checksums) hasBinUnknown() bool {
+	if len(cs.binUnknown) > 0 {
+		return true
+	}
+	for _, file := range cs.Files {
+		if len(file.binUnknown) > 0 {
+			return true
+		}
+		for _, member := range file.Members {
+			if len(member.binUnknown) > 0 {
+				return true
+			}
+		}
+	}
+	for _, dir := range cs.Directories {
+		if len(dir.binUnknown) > 0 {
+			return true
+		}
+	}
+	for _, special := range cs.Specials {
+		if len(special.binUnknown) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (bin *binChecksums) UnmarshalCBOR(data []byte) error {
+	type plain binChecksums
+	return unmarshalBinWithUnknown(data, (*plain)(bin), &bin.Unknown)
+}
+
+// MarshalCBOR implements cbor.Marshaler.
+func (bin *binChecksums) MarshalCBOR() ([]byte, error) {
+	type plain binChecksums
+	return marshalBinWithUnknown((*plain)(bin), bin.Unknown)
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (bin *binFile) UnmarshalCBOR(data []byte) error {
+	type plain binFile
+	return unmarshalBinWithUnknown(data, (*plain)(bin), &bin.Unknown)
+}
+
+// MarshalCBOR implements cbor.Marshaler.
+func (bin *binFile) MarshalCBOR() ([]byte, error) {
+	type plain binFile
+	return marshalBinWithUnknown((*plain)(bin), bin.Unknown)
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (bin *binMember) UnmarshalCBOR(data []byte) error {
+	type plain binMember
+	return unmarshalBinWithUnknown(data, (*plain)(bin), &bin.Unknown)
+}
+
+// MarshalCBOR implements cbor.Marshaler.
+func (bin *binMember) MarshalCBOR() ([]byte, error) {
+	type plain binMember
+	return marshalBinWithUnknown((*plain)(bin), bin.Unknown)
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (bin *binDirectory) UnmarshalCBOR(data []byte) error {
+	type plain binDirectory
+	return unmarshalBinWithUnknown(data, (*plain)(bin), &bin.Unknown)
+}
+
+// MarshalCBOR implements cbor.Marshaler.
+func (bin *binDirectory) MarshalCBOR() ([]byte, error) {
+	type plain binDirectory
+	return marshalBinWithUnknown((*plain)(bin), bin.Unknown)
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (bin *binSpecial) UnmarshalCBOR(data []byte) error {
+	type plain binSpecial
+	return unmarshalBinWithUnknown(data, (*plain)(bin), &bin.Unknown)
+}
+
+// MarshalCBOR implements cbor.Marshaler.
+func (bin *binSpecial) MarshalCBOR() ([]byte, error) {
+	type plain binSpecial
+	return marshalBinWithUnknown((*plain)(bin), bin.Unknown)
+}
+
+// unmarshalBinWithUnknown decodes the map in data into v, which must be a
+// pointer to a struct without CBOR methods, and collects all entries with
+// integer keys that are not fields of v.
+func unmarshalBinWithUnknown(data []byte, v any, unknown *binUnknown) error {
+	if err := cborDec.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	// Collect unknown integer keys.
+	var entries map[any]cbor.RawMessage
+	if err := cborDec.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	known := binKnownKeys(reflect.TypeOf(v).Elem())
+	for key, raw := range entries {
+		intKey, ok := key.(uint64)
+		if !ok || known[intKey] {
+			continue
+		}
+		if *unknown == nil {
+			*unknown = make(binUnknown)
+		}
+		(*unknown)[intKey] = raw
+	}
+	return nil
+}
+
+// marshalBinWithUnknown encodes v, which must be a pointer to a struct without
+// CBOR methods, and adds the unknown entries.
+func marshalBinWithUnknown(v any, unknown binUnknown) ([]byte, error) {
+	data, err := cborEnc.Marshal(v)
+	if err != nil || len(unknown) == 0 {
+		return data, err
+	}
+
+	// Add unknown entries to the encoded map.
+	var entries map[uint64]cbor.RawMessage
+	if err := cborDec.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for key, raw := range unknown {
+		if _, ok := entries[key]; !ok {
+			entries[key] = raw
+		}
+	}
+	return cborEnc.Marshal(entries)
+}
+
+// binKnownKeysCache caches the integer keys of the binary types.
+var binKnownKeysCache sync.Map
+
+// binKnownKeys returns the integer keys of all fields of the struct type t.
+func binKnownKeys(t reflect.Type) map[uint64]bool {
+	if known, ok := binKnownKeysCache.Load(t); ok {
+		return known.(map[uint64]bool) //nolint:forcetypeassert
+	}
+
+	known := make(map[uint64]bool, t.NumField())
+	for i := range t.NumField() {
+		name, opts, _ := strings.Cut(t.Field(i).Tag.Get("cbor"), ",")
+		if !strings.Contains(opts, "keyasint") {
+			continue
+		}
+		if key, err := strconv.ParseUint(name, 10, 64); err == nil {
+			known[key] = true
+		}
+	}
+	binKnownKeysCache.Store(t, known)
+	return known
+}
+
+func compressZstd(data []byte) []byte {
+	return zstdEnc.EncodeAll(data, make([]byte, 0, len(data)/2))
+}
+
+func decompressZstd(data []byte) ([]byte, error) {
+	decompressed, err := zstdDec.DecodeAll(data, nil)
+	switch {
+	case errors.Is(err, zstd.ErrDecoderSizeExceeded), errors.Is(err, zstd.ErrWindowSizeExceeded):
+		return nil, fmt.Errorf("%w: decompressed size exceeds %d MiB", ErrInvalidChecksumFile, maxDecompressedSize>>20)
+	case err != nil:
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	return decompressed, nil
+}
+
+func binTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromBinTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+// binDigest returns the raw bytes of a hex digest.
+// Digests that are not valid hex are returned as is in the second return value.
+func binDigest(digest string) (raw []byte, fallback string) {
+	raw, err := hex.DecodeString(digest)
+	if err != nil || hex.EncodeToString(raw) != digest {
+		return nil, digest
+	}
+	return raw, ""
+}
+
+func fromBinDigest(raw []byte, fallback string) string {
+	if fallback != "" {
+		return fallback
+	}
+	return hex.EncodeToString(raw)
+}
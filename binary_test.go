@@ -0,0 +1,154 @@
+package checkser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestBinaryKeepsUnknownKeys(t *testing.T) {
+	t.Parallel()
+
+	// Encode a checksum file of a newer schema with unknown integer keys.
+	data, err := cborEnc.Marshal(map[uint64]any{
+		1: SchemaVersion + 1,
+		7: SchemaVersion,
+		4: []map[uint64]any{{
+			1:  "a.txt",
+			2:  6,
+			4:  string(SHA2_256),
+			5:  bytes.Repeat([]byte{1}, 32),
+			30: map[uint64]any{1: "nested", 2: []byte{1, 2, 3}},
+		}},
+		20: "top level",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(bytes.Clone(cborMagic), data...)
+
+	// Load and rewrite.
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if cs.GetFile("a.txt") == nil {
+		t.Fatal("file missing after loading")
+	}
+	packed, err := PackChecksums(cs)
+	if err != nil {
+		t.Fatalf("failed to pack: %s", err)
+	}
+
+	// Check that unknown keys were kept.
+	var top map[uint64]cbor.RawMessage
+	if err := cbor.Unmarshal(bytes.TrimPrefix(packed, cborMagic), &top); err != nil {
+		t.Fatal(err)
+	}
+	var topLevel string
+	if err := cbor.Unmarshal(top[20], &topLevel); err != nil || topLevel != "top level" {
+		t.Fatalf("unknown top level key lost: %q, %v", topLevel, err)
+	}
+	var files []map[uint64]cbor.RawMessage
+	if err := cbor.Unmarshal(top[4], &files); err != nil || len(files) != 1 {
+		t.Fatalf("failed to decode files: %v", err)
+	}
+	var nested map[uint64]any
+	if err := cbor.Unmarshal(files[0][30], &nested); err != nil || nested[1] != "nested" {
+		t.Fatalf("unknown file key lost: %v, %v", nested, err)
+	}
+
+	// Rewriting again must produce the same data.
+	cs, err = LoadChecksums(packed)
+	if err != nil {
+		t.Fatalf("failed to load rewritten data: %s", err)
+	}
+	repacked, err := PackChecksums(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packed, repacked) {
+		t.Fatal("rewriting is not deterministic")
+	}
+}
+
+func TestBinaryDecompressionLimit(t *testing.T) {
+	t.Parallel()
+
+	// Zstd frame claiming 300 MiB of content, with a single RLE block.
+	data := bytes.Clone(zstdMagic)
+	data = append(data, 0xe0)                              // Single segment, 8 byte content size.
+	data = binary.LittleEndian.AppendUint64(data, 300<<20) // Content size.
+	data = append(data, 0x0b, 0x00, 0x00, 0x00)            // Last RLE block of size 1.
+
+	_, err := LoadChecksums(data)
+	if !errors.Is(err, ErrInvalidChecksumFile) {
+		t.Fatalf("error is %v, expected %s", err, ErrInvalidChecksumFile)
+	}
+}
+
+func TestBinaryUnknownKeysNotConvertedToText(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	updateTree(t, dir, ScanConfig{Format: FormatCBOR})
+
+	// Add an unknown integer key to the root checksum file.
+	checksumFile := filepath.Join(dir, ChecksumFilename)
+	data, err := os.ReadFile(checksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var top map[uint64]cbor.RawMessage
+	if err := cbor.Unmarshal(bytes.TrimPrefix(data, cborMagic), &top); err != nil {
+		t.Fatal(err)
+	}
+	top[20], _ = cborEnc.Marshal("from the future")
+	data, err = cborEnc.Marshal(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(bytes.Clone(cborMagic), data...)
+	if err := os.WriteFile(checksumFile, data, 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+
+	// Converting to a text format must be refused.
+	_, err = ConvertChecksumFiles(dir, FormatYAML, nil)
+	if !errors.Is(err, ErrUnknownBinaryKeys) {
+		t.Fatalf("error is %v, expected %s", err, ErrUnknownBinaryKeys)
+	}
+	converted, err := os.ReadFile(checksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(converted, data) {
+		t.Fatal("checksum file was modified")
+	}
+
+	// Updating in a text format must keep the binary format.
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "new\n")
+	updateTree(t, dir, ScanConfig{Format: FormatYAML})
+	updated, err := os.ReadFile(checksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if DetectFormat(updated) != FormatCBOR {
+		t.Fatalf("checksum file was converted to %s", DetectFormat(updated))
+	}
+	cs, err := LoadChecksums(updated)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case cs.GetFile("b.txt") == nil:
+		t.Fatal("new file not recorded")
+	case len(cs.binUnknown) != 1:
+		t.Fatalf("unknown keys lost: %v", cs.binUnknown)
+	}
+}
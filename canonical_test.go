@@ -0,0 +1,116 @@
+package checkser
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCanonicalRewritesOnlyChanges(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "b\n")
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "c.txt"), "c\n")
+	writeTestFile(t, filepath.Join(dir, "other", "d.txt"), "d\n")
+	cfg := ScanConfig{Canonical: true}
+	updateTree(t, dir, cfg)
+
+	// Mark all checksum files as old, to see which are rewritten.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	checksumFiles := map[string][]byte{}
+	for _, sub := range []string{"", "sub", "other"} {
+		name := filepath.Join(dir, sub, ChecksumFilename)
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksumFiles[sub] = data
+		if err := os.Chtimes(name, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rewritten := func(sub string) bool {
+		t.Helper()
+
+		name := filepath.Join(dir, sub, ChecksumFilename)
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return !info.ModTime().Equal(old) || !bytes.Equal(data, checksumFiles[sub])
+	}
+
+	// Without changes, nothing is rewritten.
+	updateTree(t, dir, cfg)
+	for _, sub := range []string{"", "sub", "other"} {
+		if rewritten(sub) {
+			t.Fatalf("checksum file in %q rewritten without changes", sub)
+		}
+	}
+
+	// A change rewrites its dir and the parents only.
+	writeTestFile(t, filepath.Join(dir, "sub", "c.txt"), "changed\n")
+	updateTree(t, dir, cfg)
+	switch {
+	case !rewritten("sub"):
+		t.Fatal("checksum file of changed dir not rewritten")
+	case !rewritten(""):
+		t.Fatal("root checksum file not rewritten")
+	case rewritten("other"):
+		t.Fatal("checksum file of unchanged dir rewritten")
+	}
+	assertUnchanged(t, scanTree(t, dir, cfg))
+}
+
+func TestCanonicalSortsEntries(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a\n")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "b\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// Write entries in reverse order.
+	name := filepath.Join(dir, ChecksumFilename)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.Files[0], cs.Files[1] = cs.Files[1], cs.Files[0]
+	if cs.isSorted() {
+		t.Fatal("reversed entries are sorted")
+	}
+	unsorted, err := PackChecksums(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, unsorted, 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+
+	// Canonical mode sorts the entries, even without changes.
+	updateTree(t, dir, ScanConfig{Canonical: true})
+	data, err = os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err = LoadChecksums(data)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case !cs.isSorted():
+		t.Fatal("entries not sorted in canonical mode")
+	}
+}
@@ -0,0 +1,207 @@
+package checkser
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Chain is the chain of checksum files from a subtree up to the root of the
+// tree it belongs to. The root of a tree is the top-most directory of an
+// unbroken line of ancestors that all have a checksum file.
+type Chain struct {
+	// Root is the root dir of the tree.
+	Root string
+
+	// Links holds the links from the subtree up to the root.
+	// It is empty if the given dir is the root of its tree.
+	Links []*ChainLink
+}
+
+// ChainLink is the link between a checksum file and its parent.
+type ChainLink struct {
+	// Dir is the dir holding the checksum file.
+	Dir string
+	// Parent is the dir holding the parent checksum file, which records the digest of Dir.
+	Parent string
+
+	// Data holds the raw checksum file of Dir.
+	Data []byte
+	// Entry is the entry of Dir in the parent checksum file.
+	Entry *Directory
+
+	// Err holds the reason the link could not be verified.
+	Err error
+}
+
+// Errors.
+var (
+	ErrChainBroken = errors.New("chain of checksum files broken")
+)
+
+// Err returns an error if any link of the chain could not be verified.
+func (chain *Chain) Err() error {
+	var errs []error
+	for _, link := range chain.Links {
+		if link.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", link.Dir, link.Err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrChainBroken, errors.Join(errs...))
+}
+
+// VerifyChain verifies the chain of checksum files from the scanned dir up to
+// the root of its tree, starting with the checksum file loaded by the scan.
+func (scan *Scan) VerifyChain() (*Chain, error) {
+	return FindChain(scan.manifestDir, scan.rootData, scan.cfg.Key)
+}
+
+// FindChain finds and verifies the chain of checksum files from dir up to the
+// root of its tree. If data is nil, the checksum file of dir is read from disk.
+// The key is needed for keyed trees and enforces keyed digests if set.
+// Verification failures are recorded in the links, see Chain.Err().
+func FindChain(dir string, data []byte, key []byte) (*Chain, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	chain := &Chain{
+		Root: dir,
+	}
+
+	// Read checksum file of dir, if not given.
+	if data == nil {
+		data, err = readChecksumFile(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Reached file system root.
+			return chain, nil
+		}
+
+		// Read parent checksum file.
+		parentData, err := readChecksumFile(parent)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Reached the root of the tree.
+				return chain, nil
+			}
+			return nil, err
+		}
+		link := &ChainLink{
+			Dir:    dir,
+			Parent: parent,
+			Data:   data,
+		}
+		chain.Root = parent
+		chain.Links = append(chain.Links, link)
+
+		// Verify link.
+		parentCS, err := LoadChecksums(parentData)
+		if err != nil {
+			link.Err = fmt.Errorf("failed to load parent checksum file: %w", err)
+		} else {
+			link.Entry = parentCS.GetDir(norm.NFC.String(filepath.Base(dir)))
+			link.Err = link.verify(key)
+		}
+
+		// Continue with parent.
+		dir = parent
+		data = parentData
+	}
+}
+
+func (link *ChainLink) verify(key []byte) error {
+	switch {
+	case link.Data == nil:
+		return errors.New("checksum file missing")
+	case link.Entry == nil:
+		return errors.New("dir not recorded in parent checksum file")
+	case link.Entry.Algorithm == "":
+		return errors.New("no checksum recorded in parent checksum file")
+	case key != nil && !Hash(link.Entry.Algorithm).IsKeyed():
+		return errors.New("dir integrity not protected: checksum is not keyed")
+	}
+
+	sum, err := Hash(link.Entry.Algorithm).DigestWithKey(link.Data, key)
+	if err != nil {
+		return err
+	}
+	if sum != link.Entry.Digest {
+		return fmt.Errorf("%w: checksum did not match", ErrIntegrityViolated)
+	}
+	return nil
+}
+
+func readChecksumFile(dir string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(dir, ChecksumFilename))
+}
+
+// loadVerifiedChecksums loads the checksum file of a dir and checks if it
+// matches the entry of its parent dir, if given.
+func loadVerifiedChecksums(dir string, pathDir *Directory, key []byte) (*Checksums, error) {
+	data, err := readChecksumFile(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum file in %s: %w", dir, err)
+	}
+	return verifiedChecksums(dir, data, pathDir, key)
+}
+
+// verifiedChecksums loads the checksum file data of a dir and checks if it
+// matches the entry of its parent dir, if given.
+func verifiedChecksums(dir string, data []byte, pathDir *Directory, key []byte) (*Checksums, error) {
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checksum file in %s: %w", dir, err)
+	}
+
+	if pathDir != nil && pathDir.Algorithm != "" {
+		dirChecksum, err := Hash(pathDir.Algorithm).DigestWithKey(data, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to digest checksum file in %s: %w", dir, err)
+		}
+		if !strings.EqualFold(dirChecksum, pathDir.Digest) {
+			return nil, fmt.Errorf("%w: %s", ErrIntegrityViolated, dir)
+		}
+	}
+	return cs, nil
+}
+
+// FindTreeRoot returns the root of the tree dir belongs to, which is the
+// top-most dir of an unbroken line of ancestors that all have a checksum file.
+func FindTreeRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Reached file system root.
+			return dir, nil
+		}
+		_, err := os.Stat(filepath.Join(parent, ChecksumFilename))
+		switch {
+		case err == nil:
+			dir = parent
+		case errors.Is(err, fs.ErrNotExist):
+			return dir, nil
+		default:
+			return "", err
+		}
+	}
+}
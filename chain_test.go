@@ -0,0 +1,70 @@
+package checkser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindChain(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "deeper", "b.txt"), "deeper\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// The chain leads from the subtree up to the tree root.
+	deeper := filepath.Join(dir, "sub", "deeper")
+	chain, err := FindChain(deeper, nil, nil)
+	switch {
+	case err != nil:
+		t.Fatalf("failed to find chain: %s", err)
+	case chain.Root != dir:
+		t.Fatalf("chain root is %s, expected %s", chain.Root, dir)
+	case len(chain.Links) != 2:
+		t.Fatalf("%d links, expected 2", len(chain.Links))
+	case chain.Err() != nil:
+		t.Fatalf("intact chain failed to verify: %s", chain.Err())
+	}
+
+	// The tree root has no links.
+	chain, err = FindChain(dir, nil, nil)
+	switch {
+	case err != nil:
+		t.Fatalf("failed to find chain: %s", err)
+	case len(chain.Links) != 0 || chain.Root != dir:
+		t.Fatalf("tree root has %d links to %s", len(chain.Links), chain.Root)
+	}
+
+	// A key enforces keyed digests.
+	chain, err = FindChain(deeper, nil, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("failed to find chain: %s", err)
+	}
+	if !errors.Is(chain.Err(), ErrChainBroken) {
+		t.Fatalf("unkeyed chain accepted with key: %v", chain.Err())
+	}
+
+	// Tampering with a checksum file in between breaks the chain.
+	checksumFile := filepath.Join(dir, "sub", ChecksumFilename)
+	data, err := os.ReadFile(checksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(checksumFile, append(data, '\n'), 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	chain, err = FindChain(deeper, nil, nil)
+	switch {
+	case err != nil:
+		t.Fatalf("failed to find chain: %s", err)
+	case chain.Links[0].Err != nil:
+		t.Fatalf("untouched link failed: %s", chain.Links[0].Err)
+	case !errors.Is(chain.Links[1].Err, ErrIntegrityViolated):
+		t.Fatalf("unexpected error of tampered link: %v", chain.Links[1].Err)
+	case !errors.Is(chain.Err(), ErrChainBroken):
+		t.Fatalf("unexpected chain error: %v", chain.Err())
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var catChecksumsCmd = &cobra.Command{
+	Use:   "cat-checksums [file|dir]",
+	Short: "Print a checksum file as YAML, or in the format given with --format.",
+	RunE:  catChecksums,
+	Args:  cobra.ExactArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(catChecksumsCmd)
+}
+
+func catChecksums(_ *cobra.Command, args []string) error {
+	path := args[0]
+
+	// Use checksum file if a dir is given.
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, checkser.ChecksumFilename)
+	}
+
+	// Load checksum file.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cs, err := checkser.LoadChecksums(data)
+	if err != nil {
+		return fmt.Errorf("failed to load checksum file: %w", err)
+	}
+
+	// Pack in requested format.
+	format := checkser.FormatYAML
+	if flagFormat != "" {
+		format = checkser.Format(flagFormat)
+	}
+	if !format.IsValid() {
+		return checkser.ErrUnsupportedFormat
+	}
+	cs.SetFormat(format)
+	packed, err := checkser.PackChecksums(cs)
+	if err != nil {
+		return fmt.Errorf("failed to pack checksum file: %w", err)
+	}
+
+	_, err = os.Stdout.Write(packed)
+	return err
+}
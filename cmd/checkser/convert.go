@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert [dir]",
+	Short: "Convert all checksum files to the format given with --format. No files are digested.",
+	RunE:  convert,
+	Args:  cobra.ExactArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+}
+
+func convert(_ *cobra.Command, args []string) error {
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	if flagFormat == "" {
+		return errors.New("target format must be set with --format")
+	}
+
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	var signingKey *checkser.SigningKey
+	if flagSignKey != "" {
+		signingKey, err = loadSigningKey(flagSignKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	converted, err := checkser.ConvertChecksumFiles(dir, checkser.Format(flagFormat), key)
+	fmt.Printf("Converted %d checksum files.\n", converted)
+	if err != nil {
+		return fmt.Errorf("conversion failed: %w", err)
+	}
+
+	// Sign the rewritten root checksum file.
+	switch {
+	case converted == 0:
+	case signingKey != nil:
+		err := checkser.SignRootChecksumFile(dir, signingKey)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Signed root checksum file.")
+	case fileExists(filepath.Join(dir, checkser.SignatureFilename)):
+		fmt.Println("Root checksum file changed, its signature is no longer valid. Sign it again with checkser sign.")
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var copyCmd = &cobra.Command{
+	Use:   "copy [src] [dst]",
+	Short: "Copy a tree and verify all data against the checksum files of the source while copying.",
+	RunE:  copyTree,
+	Args:  cobra.ExactArgs(2),
+}
+
+func init() {
+	rootCmd.AddCommand(copyCmd)
+}
+
+func copyTree(_ *cobra.Command, args []string) error {
+	src, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid source directory: %w", err)
+	}
+	dst, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid destination directory: %w", err)
+	}
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Copy tree.
+	fmt.Fprintf(output, "Copying %s to %s\n\n", src, dst)
+	result, err := checkser.CopyTree(src, dst, checkser.CopyConfig{
+		DefaultHash: checkser.Hash(flagDefaultHash),
+		Format:      checkser.Format(flagFormat),
+		Key:         key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy tree: %w", err)
+	}
+
+	// Print failures and unverified files.
+	for _, failure := range result.Failures {
+		path := failure.Path
+		if path == "" {
+			path = "."
+		}
+		if errors.Is(failure.Err, checkser.ErrSourceMismatch) {
+			fmt.Fprintf(output, "mismatch   %s\n", path)
+		} else {
+			fmt.Fprintf(output, "failed     %s\n", path)
+		}
+		fmt.Fprintln(output, "        Error: "+failure.Err.Error())
+	}
+	for _, path := range result.Unverified {
+		fmt.Fprintf(output, "unverified %s\n", path)
+	}
+	if len(result.Failures) > 0 || len(result.Unverified) > 0 {
+		fmt.Fprintln(output, "")
+	}
+
+	fmt.Fprintf(output,
+		"Copied %d files (%dB), %d dirs and %d other; wrote %d checksum files.\n",
+		result.Files,
+		result.Bytes,
+		result.Dirs,
+		result.Specials,
+		result.WrittenChecksums,
+	)
+
+	// Summarize.
+	var code int
+	var mismatched int
+	for _, failure := range result.Failures {
+		if errors.Is(failure.Err, checkser.ErrSourceMismatch) {
+			code |= exitChanged
+			mismatched++
+		} else {
+			code |= exitErrors
+		}
+	}
+	if len(result.Unverified) > 0 {
+		code |= exitAdded
+	}
+	if code != 0 {
+		return &exitError{
+			code: code,
+			err: fmt.Errorf(
+				"copy incomplete: %d mismatched, %d failed, %d unverified",
+				mismatched,
+				len(result.Failures)-mismatched,
+				len(result.Unverified),
+			),
+		}
+	}
+
+	fmt.Fprintln(output, "Copy verified.")
+	return nil
+}
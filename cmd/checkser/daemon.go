@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	daemonCmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "Run verify, scrub and update jobs on the trees in the config file (--config) on schedule.",
+		RunE:  runDaemon,
+		Args:  cobra.NoArgs,
+	}
+
+	daemonStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the state of the last run of every tree.",
+		RunE:  daemonStatus,
+		Args:  cobra.NoArgs,
+	}
+
+	flagDaemonConfig string
+	flagDaemonOnce   bool
+	flagDaemonJSON   bool
+)
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+
+	daemonCmd.PersistentFlags().StringVar(&flagDaemonConfig, "config", "", "daemon config file")
+	daemonCmd.Flags().BoolVar(&flagDaemonOnce, "once", false, "run all jobs once and exit, ignoring schedules")
+	daemonCmd.Flags().StringVar(&flagMetricsAddr, "metrics-addr", "", "serve Prometheus metrics on /metrics at this address, eg. localhost:9190")
+	daemonStatusCmd.Flags().BoolVar(&flagDaemonJSON, "json", false, "print state as JSON")
+}
+
+// Parallel modes.
+const (
+	parallelSequential = "sequential"
+	parallelDevice     = "device"
+)
+
+// daemonConfig is the config file of the daemon.
+type daemonConfig struct {
+	// StateFile is where the state of the last runs is persisted.
+	// Defaults to the config file with the extension .state.json.
+	StateFile string `yaml:"state_file"`
+
+	// Parallel defines which jobs may run at the same time:
+	// "sequential" runs one job at a time, "device" one job per device.
+	Parallel string `yaml:"parallel"`
+	// MaxJobs limits how many jobs run at the same time in "device" mode.
+	// Zero means no limit.
+	MaxJobs int `yaml:"max_jobs"`
+
+	Trees []*treeConfig `yaml:"trees"`
+
+	// Hooks are run on the events of all jobs.
+	hooksConfig `yaml:",inline"`
+}
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+func loadDaemonConfig(path string) (*daemonConfig, error) {
+	if path == "" {
+		return nil, errors.New("config file must be set with --config")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	cfg := &daemonConfig{}
+	err = yaml.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	// Check config.
+	if cfg.StateFile == "" {
+		cfg.StateFile = strings.TrimSuffix(path, filepath.Ext(path)) + ".state.json"
+	}
+	switch cfg.Parallel {
+	case "":
+		cfg.Parallel = parallelSequential
+	case parallelSequential, parallelDevice:
+	default:
+		return nil, fmt.Errorf("unknown parallel mode %q", cfg.Parallel)
+	}
+	if cfg.MaxJobs < 0 {
+		return nil, fmt.Errorf("invalid max_jobs %d", cfg.MaxJobs)
+	}
+	if len(cfg.Trees) == 0 {
+		return nil, errors.New("no trees configured")
+	}
+	names := make(map[string]struct{}, len(cfg.Trees))
+	for _, tree := range cfg.Trees {
+		if _, ok := names[tree.Name]; ok {
+			return nil, fmt.Errorf("tree %s: duplicate name", tree.Name)
+		}
+		names[tree.Name] = struct{}{}
+
+		// Lock trees next to the state, so that the daemon and the API
+		// server never run jobs on the same tree at the same time.
+		tree.lockPath = fmt.Sprintf("%s.%s.lock", cfg.StateFile, url.PathEscape(tree.Name))
+	}
+	if err := cfg.Hooks.prepare(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// treeSchedule returns the parsed schedule of the tree.
+func treeSchedule(tree *treeConfig) (cron.Schedule, error) {
+	if tree.Schedule == "" {
+		return nil, fmt.Errorf("tree %s: schedule missing", tree.Name)
+	}
+	schedule, err := cronParser.Parse(tree.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("tree %s: invalid schedule: %w", tree.Name, err)
+	}
+	return schedule, nil
+}
+
+// daemonState is the persisted state of the daemon.
+type daemonState struct {
+	lock sync.Mutex
+	path string
+
+	Trees map[string]*treeState `json:"trees"`
+}
+
+// treeState is the state of a tree.
+type treeState struct {
+	Running     bool       `json:"running"`
+	LastRun     *jobResult `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+func loadDaemonState(path string) (*daemonState, error) {
+	state := &daemonState{
+		path:  path,
+		Trees: make(map[string]*treeState),
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return state, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+	if state.Trees == nil {
+		state.Trees = make(map[string]*treeState)
+	}
+	return state, nil
+}
+
+// tree returns the state of the tree. The state must be locked.
+func (state *daemonState) tree(name string) *treeState {
+	ts, ok := state.Trees[name]
+	if !ok {
+		ts = &treeState{}
+		state.Trees[name] = ts
+	}
+	return ts
+}
+
+// save persists the state. The state must be locked.
+func (state *daemonState) save() error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write atomically.
+	tmpPath := state.path + ".tmp"
+	err = os.WriteFile(tmpPath, append(data, '\n'), 0o0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, state.path)
+}
+
+// daemon schedules and runs jobs.
+type daemon struct {
+	cfg     *daemonConfig
+	state   *daemonState
+	metrics *metrics
+
+	// queues holds a job queue per device, or a single one when running sequentially.
+	queues  map[string]chan *treeConfig
+	workers sync.WaitGroup
+	// slots limits the number of running jobs, if max_jobs is set.
+	slots chan struct{}
+}
+
+func runDaemon(_ *cobra.Command, _ []string) error {
+	cfg, err := loadDaemonConfig(flagDaemonConfig)
+	if err != nil {
+		return err
+	}
+
+	// Check trees and schedules.
+	schedules := make(map[string]cron.Schedule, len(cfg.Trees))
+	for _, tree := range cfg.Trees {
+		if err := tree.prepare(); err != nil {
+			return err
+		}
+		if !flagDaemonOnce {
+			schedules[tree.Name], err = treeSchedule(tree)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Lock state, so that only one daemon runs per config.
+	unlock, err := lockFile(cfg.StateFile + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	state, err := loadDaemonState(cfg.StateFile)
+	if err != nil {
+		return err
+	}
+
+	// Reset running state of previous runs that were interrupted.
+	for _, ts := range state.Trees {
+		ts.Running = false
+	}
+
+	// Export metrics, starting with the persisted results.
+	m, err := newMetrics(flagMetricsFile)
+	if err != nil {
+		return err
+	}
+	for _, tree := range cfg.Trees {
+		if ts, ok := state.Trees[tree.Name]; ok && ts.LastRun != nil {
+			m.set(ts.LastRun, ts.LastSuccess)
+		}
+	}
+	if flagMetricsAddr != "" {
+		srv := m.serve(flagMetricsAddr)
+		defer srv.Close() //nolint:errcheck
+	}
+
+	d := &daemon{
+		cfg:     cfg,
+		state:   state,
+		metrics: m,
+		queues:  make(map[string]chan *treeConfig),
+	}
+	if cfg.MaxJobs > 0 {
+		d.slots = make(chan struct{}, cfg.MaxJobs)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Start workers.
+	for _, tree := range cfg.Trees {
+		queueID := d.queueID(tree)
+		if _, ok := d.queues[queueID]; !ok {
+			queue := make(chan *treeConfig, len(cfg.Trees))
+			d.queues[queueID] = queue
+			d.workers.Add(1)
+			go d.worker(ctx, queue)
+		}
+	}
+
+	// Run all jobs once.
+	if flagDaemonOnce {
+		for _, tree := range cfg.Trees {
+			d.enqueue(tree)
+		}
+		d.stop()
+		return nil
+	}
+
+	// Schedule jobs.
+	scheduler := cron.New(cron.WithParser(cronParser))
+	for _, tree := range cfg.Trees {
+		scheduler.Schedule(schedules[tree.Name], cron.FuncJob(func() {
+			d.enqueue(tree)
+		}))
+		logf("Scheduled %s of %s (%s), next run at %s", tree.Mode, tree.Name, tree.Path, schedules[tree.Name].Next(time.Now()).Format(time.DateTime))
+	}
+	scheduler.Start()
+
+	<-ctx.Done()
+	logf("Stopping, waiting for running jobs to finish...")
+	<-scheduler.Stop().Done()
+	d.stop()
+	return nil
+}
+
+// queueID returns the ID of the queue the tree's jobs are run in.
+func (d *daemon) queueID(tree *treeConfig) string {
+	if d.cfg.Parallel == parallelDevice {
+		return deviceID(tree.Path)
+	}
+	return ""
+}
+
+// enqueue queues a job for the tree, unless one is already queued or running.
+func (d *daemon) enqueue(tree *treeConfig) {
+	d.state.lock.Lock()
+	defer d.state.lock.Unlock()
+
+	ts := d.state.tree(tree.Name)
+	if ts.Running {
+		logf("Skipping %s of %s: still running", tree.Mode, tree.Name)
+		return
+	}
+	ts.Running = true
+	d.queues[d.queueID(tree)] <- tree
+}
+
+// stop waits for all queued jobs to finish.
+func (d *daemon) stop() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.workers.Wait()
+}
+
+func (d *daemon) worker(ctx context.Context, queue chan *treeConfig) {
+	defer d.workers.Done()
+
+	for tree := range queue {
+		// Skip queued jobs when stopping.
+		if ctx.Err() != nil {
+			d.state.lock.Lock()
+			d.state.tree(tree.Name).Running = false
+			d.state.lock.Unlock()
+			continue
+		}
+
+		if d.slots != nil {
+			d.slots <- struct{}{}
+		}
+		d.runJob(tree)
+		if d.slots != nil {
+			<-d.slots
+		}
+	}
+}
+
+func (d *daemon) runJob(tree *treeConfig) {
+	logf("Starting %s of %s (%s)", tree.Mode, tree.Name, tree.Path)
+
+	// Persist running state.
+	d.state.lock.Lock()
+	if err := d.state.save(); err != nil {
+		logf("Failed to save state: %s", err)
+	}
+	d.state.lock.Unlock()
+
+	// Run job.
+	j := newJob(tree, tree.Mode)
+	j.hooks = d.cfg.Hooks
+	result := j.run()
+	logf("Finished %s of %s in %s: %s", tree.Mode, tree.Name, time.Duration(result.Duration*float64(time.Second)).Round(time.Second), formatResult(result))
+	for _, msg := range result.Errors {
+		logf("Error in %s: %s", tree.Name, msg)
+	}
+
+	if err := d.metrics.record(result); err != nil {
+		logf("%s", err)
+	}
+
+	// Persist result.
+	d.state.lock.Lock()
+	defer d.state.lock.Unlock()
+	ts := d.state.tree(tree.Name)
+	ts.Running = false
+	ts.LastRun = result
+	if result.succeeded() {
+		ts.LastSuccess = &result.End
+	}
+	if err := d.state.save(); err != nil {
+		logf("Failed to save state: %s", err)
+	}
+}
+
+func daemonStatus(_ *cobra.Command, _ []string) error {
+	cfg, err := loadDaemonConfig(flagDaemonConfig)
+	if err != nil {
+		return err
+	}
+	state, err := loadDaemonState(cfg.StateFile)
+	if err != nil {
+		return err
+	}
+
+	if flagDaemonJSON {
+		data, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	for _, tree := range cfg.Trees {
+		fmt.Printf("%s (%s)\n", tree.Name, tree.Path)
+		fmt.Printf("    Mode: %s\n", tree.Mode)
+		if schedule, err := treeSchedule(tree); err == nil {
+			fmt.Printf("    Schedule: %s, next run at %s\n", tree.Schedule, schedule.Next(time.Now()).Format(time.DateTime))
+		}
+
+		ts := state.Trees[tree.Name]
+		switch {
+		case ts == nil || ts.LastRun == nil:
+			fmt.Println("    Last run: never")
+		default:
+			fmt.Printf("    Last run: %s, took %s: %s\n",
+				ts.LastRun.Start.Format(time.DateTime),
+				time.Duration(ts.LastRun.Duration*float64(time.Second)).Round(time.Second),
+				formatResult(ts.LastRun),
+			)
+			for _, msg := range ts.LastRun.Errors {
+				fmt.Printf("        Error: %s\n", msg)
+			}
+		}
+		if ts != nil && ts.LastSuccess != nil {
+			fmt.Printf("    Last success: %s\n", ts.LastSuccess.Format(time.DateTime))
+		}
+		if ts != nil && ts.Running {
+			fmt.Println("    Running now")
+		}
+	}
+	return nil
+}
+
+// formatResult returns a short summary of the result.
+func formatResult(result *jobResult) string {
+	if result.Stats == nil {
+		return result.Status
+	}
+	total := result.Stats.Total
+	return fmt.Sprintf(
+		"%s (%d files, %d added, %d changed, %d removed, %d timestamp changed, %d failed, %d errors)",
+		result.Status,
+		result.Stats.FoundFiles,
+		total.Added, total.Changed, total.Removed, total.TimestampChanged, total.Failed,
+		len(result.Errors)+int(result.Stats.FindingErrors+result.Stats.DigestErrors+result.Stats.WriteErrors), //nolint:gosec // Error counts are small.
+	)
+}
+
+// logf prints a log line with a timestamp.
+func logf(format string, a ...any) {
+	fmt.Printf("%s %s\n", time.Now().Format(time.DateTime), fmt.Sprintf(format, a...))
+}
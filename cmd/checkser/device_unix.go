@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// deviceID returns an identifier of the device the path is stored on.
+func deviceID(path string) string {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return path
+	}
+	return strconv.FormatUint(uint64(st.Dev), 10) //nolint:unconvert // Type differs between platforms.
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// deviceID returns an identifier of the device the path is stored on.
+func deviceID(path string) string {
+	return strings.ToUpper(filepath.VolumeName(path))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffCmd = &cobra.Command{
+		Use:   "diff [treeA] [treeB]",
+		Short: "Compare two trees by their checksum files, without reading files.",
+		RunE:  diff,
+		Args:  cobra.ExactArgs(2),
+	}
+
+	flagDiffDigest bool
+)
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().BoolVar(&flagDiffDigest, "digest", false, "digest files whose recorded state is stale, or that were recorded with different hash algorithms")
+}
+
+func diff(_ *cobra.Command, args []string) error {
+	if err := checkReportFlag(); err != nil {
+		return err
+	}
+
+	dirA, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	dirB, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Compare trees.
+	fmt.Fprintf(output, "Comparing A %s with B %s\n\n", dirA, dirB)
+	d, err := checkser.DiffTrees(dirA, dirB, checkser.DiffConfig{
+		Digest: flagDiffDigest,
+		Key:    key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compare trees: %w", err)
+	}
+
+	// Write report.
+	switch flagReport {
+	case reportJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	case reportNDJSON:
+		enc := json.NewEncoder(os.Stdout)
+		for _, entry := range d.Entries {
+			if err = enc.Encode(entry); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write report: %s\n", err)
+	}
+
+	// Print differences.
+	for _, entry := range d.Entries {
+		printDiffEntry(entry)
+	}
+	if len(d.Entries) > 0 {
+		fmt.Fprintln(output, "")
+	}
+	if flagDiffDigest {
+		fmt.Fprintf(output, "Digested %d files.\n", d.Digested)
+	}
+
+	// Summarize.
+	var code int
+	for kind, count := range d.Counts {
+		if count == 0 {
+			continue
+		}
+		switch kind {
+		case checkser.DiffOnlyA:
+			code |= exitRemoved
+		case checkser.DiffOnlyB:
+			code |= exitAdded
+		case checkser.DiffType, checkser.DiffSize, checkser.DiffDigest:
+			code |= exitChanged
+		case checkser.DiffTimestamp:
+			code |= exitTimestamp
+		case checkser.DiffAlgorithm, checkser.DiffError:
+			code |= exitErrors
+		}
+	}
+	if code != 0 {
+		return &exitError{
+			code: code,
+			err: fmt.Errorf(
+				"trees differ: %d only in A, %d only in B, %d type, %d size, %d digest, %d timestamp, %d not comparable, %d errors",
+				d.Counts[checkser.DiffOnlyA],
+				d.Counts[checkser.DiffOnlyB],
+				d.Counts[checkser.DiffType],
+				d.Counts[checkser.DiffSize],
+				d.Counts[checkser.DiffDigest],
+				d.Counts[checkser.DiffTimestamp],
+				d.Counts[checkser.DiffAlgorithm],
+				d.Counts[checkser.DiffError],
+			),
+		}
+	}
+
+	fmt.Fprintln(output, "Trees are identical.")
+	return nil
+}
+
+func printDiffEntry(entry *checkser.DiffEntry) {
+	path := entry.Path
+	if entry.Type == checkser.ReportTypeDir {
+		path += "/"
+	}
+
+	switch entry.Kind {
+	case checkser.DiffOnlyA:
+		fmt.Fprintf(output, "only in A  %s\n", path)
+	case checkser.DiffOnlyB:
+		fmt.Fprintf(output, "only in B  %s\n", path)
+	case checkser.DiffType:
+		fmt.Fprintf(output, "type       %s (%s => %s)\n", path, entry.A.Type, entry.B.Type)
+	case checkser.DiffSize:
+		fmt.Fprintf(output, "size       %s (%dB => %dB)\n", path, *entry.A.Size, *entry.B.Size)
+	case checkser.DiffDigest:
+		fmt.Fprintf(output, "digest     %s (%s %s => %s %s)\n", path, entry.A.Algorithm, entry.A.Digest, entry.B.Algorithm, entry.B.Digest)
+	case checkser.DiffAlgorithm:
+		fmt.Fprintf(output, "algorithm  %s (%s => %s, use --digest to compare)\n", path, entry.A.Algorithm, entry.B.Algorithm)
+	case checkser.DiffTimestamp:
+		fmt.Fprintf(output, "timestamp  %s (%s => %s)\n", path, formatDiffTime(entry.A.Modified), formatDiffTime(entry.B.Modified))
+	case checkser.DiffError:
+		fmt.Fprintf(output, "error      %s\n", path)
+	}
+	for _, msg := range entry.ErrMsgs {
+		fmt.Fprintln(output, "        Error: "+msg)
+	}
+}
+
+func formatDiffTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dhaavi/checkser"
+)
+
+// Exit codes are a bitmask, so that multiple findings can be reported at once.
+const (
+	// exitFatal is used when the command could not run, eg. because of invalid flags.
+	exitFatal = 1
+	// exitErrors is used for scan, read and write errors, for policy violations,
+	// and when the chain, signature or MAC of the root checksum file could not be verified.
+	exitErrors = 2
+	// exitDigestErrors is used when files could not be digested.
+	exitDigestErrors = 4
+	// exitChanged is used when the content of files or dirs changed.
+	exitChanged = 8
+	// exitAdded is used when files or dirs were added.
+	exitAdded = 16
+	// exitRemoved is used when files or dirs were removed.
+	exitRemoved = 32
+	// exitTimestamp is used when only the modification time of files changed.
+	exitTimestamp = 64
+)
+
+var exitCodeNames = []struct {
+	code int
+	name string
+}{
+	{exitFatal, "fatal"},
+	{exitErrors, "errors"},
+	{exitDigestErrors, "digest errors"},
+	{exitChanged, "changed"},
+	{exitAdded, "added"},
+	{exitRemoved, "removed"},
+	{exitTimestamp, "timestamp changed"},
+}
+
+// exitError is an error that exits with a specific code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error() + " (exit code " + describeExitCode(e.code) + ")"
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// resultCode returns the exit code bitmask for the stats of a run.
+func resultCode(stats *checkser.StatsSnapshot, runErrors bool) (code int) {
+	if stats == nil {
+		return exitFatal
+	}
+	if runErrors || stats.FindingErrors > 0 || stats.WriteErrors > 0 || stats.PolicyViolations > 0 {
+		code |= exitErrors
+	}
+	if stats.DigestErrors > 0 {
+		code |= exitDigestErrors
+	}
+	// Only report changes that are flagged by the policy.
+	if stats.Flagged.Changed > 0 {
+		code |= exitChanged
+	}
+	if stats.Flagged.Added > 0 {
+		code |= exitAdded
+	}
+	if stats.Flagged.Removed > 0 {
+		code |= exitRemoved
+	}
+	if stats.Flagged.TimestampChanged > 0 {
+		code |= exitTimestamp
+	}
+	return code
+}
+
+// describeExitCode returns the code with the names of its bits.
+func describeExitCode(code int) string {
+	names := make([]string, 0, len(exitCodeNames))
+	for _, bit := range exitCodeNames {
+		if code&bit.code != 0 {
+			names = append(names, bit.name)
+		}
+	}
+	return strconv.Itoa(code) + ": " + strings.Join(names, ", ")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dhaavi/checkser"
+)
+
+func TestResultCode(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		stats     *checkser.StatsSnapshot
+		runErrors bool
+		code      int
+	}{
+		{"no stats", nil, false, exitFatal},
+		{"no changes", &checkser.StatsSnapshot{}, false, 0},
+		{"run errors", &checkser.StatsSnapshot{}, true, exitErrors},
+		{"finding errors", &checkser.StatsSnapshot{FindingErrors: 1}, false, exitErrors},
+		{"write errors", &checkser.StatsSnapshot{WriteErrors: 1}, false, exitErrors},
+		{"policy violations", &checkser.StatsSnapshot{PolicyViolations: 1}, false, exitErrors},
+		{"digest errors", &checkser.StatsSnapshot{DigestErrors: 1}, false, exitDigestErrors},
+		{"changed", &checkser.StatsSnapshot{Flagged: checkser.ChangeSetSnapshot{Changed: 1}}, false, exitChanged},
+		{"added", &checkser.StatsSnapshot{Flagged: checkser.ChangeSetSnapshot{Added: 1}}, false, exitAdded},
+		{"removed", &checkser.StatsSnapshot{Flagged: checkser.ChangeSetSnapshot{Removed: 1}}, false, exitRemoved},
+		{"timestamp", &checkser.StatsSnapshot{Flagged: checkser.ChangeSetSnapshot{TimestampChanged: 1}}, false, exitTimestamp},
+		{"unflagged changes", &checkser.StatsSnapshot{Total: checkser.ChangeSetSnapshot{Changed: 1, Added: 1}}, false, 0},
+		{"combined", &checkser.StatsSnapshot{
+			DigestErrors: 2,
+			Flagged:      checkser.ChangeSetSnapshot{Changed: 1, Removed: 3},
+		}, true, exitErrors | exitDigestErrors | exitChanged | exitRemoved},
+	} {
+		if code := resultCode(tc.stats, tc.runErrors); code != tc.code {
+			t.Errorf("%s: exit code %d, expected %d", tc.name, code, tc.code)
+		}
+	}
+}
+
+func TestVerifyFileCode(t *testing.T) {
+	t.Parallel()
+
+	errChain := errors.New("chain broken")
+	for _, tc := range []struct {
+		change checkser.Change
+		err    error
+		code   int
+	}{
+		{checkser.NoChange, nil, 0},
+		{checkser.NoChange, errChain, exitErrors},
+		{checkser.TimestampChanged, nil, exitTimestamp},
+		{checkser.TimestampChanged, errChain, exitErrors | exitTimestamp},
+		{checkser.Changed, checkser.ErrFileIntegrityViolated, exitChanged},
+		{checkser.Added, checkser.ErrNotRecorded, exitAdded},
+		{checkser.Removed, errChain, exitRemoved},
+		{checkser.Failed, errChain, exitDigestErrors},
+		{checkser.Invalid, errChain, exitErrors},
+	} {
+		v := &checkser.FileVerification{Change: tc.change}
+		if code := verifyFileCode(v, tc.err); code != tc.code {
+			t.Errorf("%s with error %v: exit code %d, expected %d", tc.change.Name(), tc.err, code, tc.code)
+		}
+	}
+	if code := verifyFileCode(nil, errChain); code != exitErrors {
+		t.Errorf("exit code %d without verification, expected %d", code, exitErrors)
+	}
+}
+
+func TestDescribeExitCode(t *testing.T) {
+	t.Parallel()
+
+	if desc := describeExitCode(exitErrors | exitChanged | exitTimestamp); desc != "74: errors, changed, timestamp changed" {
+		t.Fatalf("unexpected description %q", desc)
+	}
+}
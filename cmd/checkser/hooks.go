@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dhaavi/checkser"
+	"gopkg.in/yaml.v3"
+)
+
+// Hook events.
+const (
+	hookStart   = "start"
+	hookFinish  = "finish"
+	hookChanges = "changes"
+	hookErrors  = "errors"
+)
+
+const (
+	defaultHookTimeout    = 30 * time.Second
+	defaultHookMaxEntries = 100
+)
+
+// hooksConfig holds hooks, either in their own file or in the daemon config.
+type hooksConfig struct {
+	Hooks hooks `yaml:"hooks"`
+}
+
+// hooks are run on the events of runs.
+type hooks []*hookConfig
+
+// hookConfig configures a hook.
+type hookConfig struct {
+	Name string `yaml:"name"`
+
+	// Events are the events the hook runs on: start, finish, changes, errors.
+	Events []string `yaml:"events"`
+
+	// Command is executed with the JSON payload on stdin.
+	Command []string `yaml:"command"`
+	// URL receives the JSON payload with a POST request.
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+
+	// Changes limits the changes event to these changes.
+	// Defaults to all changes except no_change.
+	// Changes ignored by the policy are never included.
+	Changes []string `yaml:"changes"`
+	// Paths limits the changes and errors events to entries matching one of
+	// these globs, relative to the checked dir.
+	Paths []string `yaml:"paths"`
+
+	// MaxEntries limits the number of entries in the payload.
+	MaxEntries int           `yaml:"max_entries"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// hookPayload is sent to hooks as JSON.
+type hookPayload struct {
+	Event string    `json:"event"`
+	Hook  string    `json:"hook,omitempty"`
+	Tree  string    `json:"tree,omitempty"`
+	Root  string    `json:"root"`
+	Mode  string    `json:"mode"`
+	Time  time.Time `json:"time"`
+
+	// Result holds the result with the stats of the run, except on start.
+	Result *jobResult `json:"result,omitempty"`
+
+	// Entries holds the entries matching the hook on changes and errors.
+	Entries []*checkser.ReportEntry `json:"entries,omitempty"`
+	// MatchedEntries is the number of matching entries, which may be more than included.
+	MatchedEntries int `json:"matched_entries,omitempty"`
+}
+
+func loadHooks(file string) (hooks, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks: %w", err)
+	}
+	cfg := &hooksConfig{}
+	err = yaml.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse hooks: %w", err)
+	}
+	err = cfg.Hooks.prepare()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Hooks, nil
+}
+
+// prepare checks the hook configs and sets defaults.
+func (hs hooks) prepare() error {
+	for i, hook := range hs {
+		if hook.Name == "" {
+			hook.Name = fmt.Sprintf("hook %d", i+1)
+		}
+
+		switch {
+		case len(hook.Command) == 0 && hook.URL == "":
+			return fmt.Errorf("%s: command or url required", hook.Name)
+		case len(hook.Command) > 0 && hook.URL != "":
+			return fmt.Errorf("%s: only one of command and url may be set", hook.Name)
+		case len(hook.Events) == 0:
+			return fmt.Errorf("%s: events missing", hook.Name)
+		}
+		for _, event := range hook.Events {
+			switch event {
+			case hookStart, hookFinish, hookChanges, hookErrors:
+			default:
+				return fmt.Errorf("%s: unknown event %q", hook.Name, event)
+			}
+		}
+		for _, change := range hook.Changes {
+			if checkser.ParseChange(change) == checkser.Invalid {
+				return fmt.Errorf("%s: unknown change %q", hook.Name, change)
+			}
+		}
+		for _, pattern := range hook.Paths {
+			if err := checkser.ValidGlob(pattern); err != nil {
+				return fmt.Errorf("%s: invalid path %q: %w", hook.Name, pattern, err)
+			}
+		}
+
+		if hook.MaxEntries <= 0 {
+			hook.MaxEntries = defaultHookMaxEntries
+		}
+		if hook.Timeout <= 0 {
+			hook.Timeout = defaultHookTimeout
+		}
+	}
+	return nil
+}
+
+// needReport returns whether any hook needs the entries of the scan.
+func (hs hooks) needReport() bool {
+	for _, hook := range hs {
+		if slices.Contains(hook.Events, hookChanges) || slices.Contains(hook.Events, hookErrors) {
+			return true
+		}
+	}
+	return false
+}
+
+// start runs the hooks of the start event.
+func (hs hooks) start(result *jobResult) (errs []error) {
+	for _, hook := range hs {
+		if !slices.Contains(hook.Events, hookStart) {
+			continue
+		}
+		payload := newHookPayload(hookStart, hook, result)
+		payload.Time = result.Start
+		payload.Result = nil
+		errs = appendHookError(errs, hook, hook.send(payload))
+	}
+	return errs
+}
+
+// finish runs the hooks of the finish, changes and errors events.
+// The report must have been created before writing checksum files, and may be nil.
+func (hs hooks) finish(result *jobResult, report *checkser.Report) (errs []error) {
+	for _, hook := range hs {
+		for _, event := range hook.Events {
+			payload := newHookPayload(event, hook, result)
+			switch event {
+			case hookFinish:
+			case hookChanges:
+				entries := hook.matchEntries(result.Root, report, func(entry *checkser.ReportEntry) bool {
+					if entry.Action == string(checkser.ActionIgnore) {
+						return false
+					}
+					if len(hook.Changes) == 0 {
+						return entry.Change != checkser.NoChange.Name()
+					}
+					return slices.Contains(hook.Changes, entry.Change)
+				})
+				if len(entries) == 0 {
+					continue
+				}
+				payload.setEntries(entries, hook.MaxEntries)
+			case hookErrors:
+				entries := hook.matchEntries(result.Root, report, func(entry *checkser.ReportEntry) bool {
+					return len(entry.ErrMsgs) > 0
+				})
+				if len(entries) == 0 && len(result.Errors) == 0 {
+					continue
+				}
+				payload.setEntries(entries, hook.MaxEntries)
+			default:
+				continue
+			}
+			errs = appendHookError(errs, hook, hook.send(payload))
+		}
+	}
+	return errs
+}
+
+func newHookPayload(event string, hook *hookConfig, result *jobResult) *hookPayload {
+	return &hookPayload{
+		Event:  event,
+		Hook:   hook.Name,
+		Tree:   result.Tree,
+		Root:   result.Root,
+		Mode:   result.Mode,
+		Time:   result.End,
+		Result: result,
+	}
+}
+
+func (payload *hookPayload) setEntries(entries []*checkser.ReportEntry, maxEntries int) {
+	payload.MatchedEntries = len(entries)
+	if len(entries) > maxEntries {
+		entries = entries[:maxEntries]
+	}
+	payload.Entries = entries
+}
+
+func appendHookError(errs []error, hook *hookConfig, err error) []error {
+	if err != nil {
+		return append(errs, fmt.Errorf("%s failed: %w", hook.Name, err))
+	}
+	return errs
+}
+
+// matchEntries returns the entries of the report matching the filter and the
+// paths of the hook, which are relative to root.
+func (hook *hookConfig) matchEntries(root string, report *checkser.Report, filter func(entry *checkser.ReportEntry) bool) []*checkser.ReportEntry {
+	if report == nil {
+		return nil
+	}
+
+	var entries []*checkser.ReportEntry
+	for _, entry := range report.Entries {
+		if !filter(entry) {
+			continue
+		}
+		if len(hook.Paths) > 0 {
+			rel, err := filepath.Rel(root, entry.Path)
+			if err != nil || !slices.ContainsFunc(hook.Paths, func(pattern string) bool {
+				return checkser.MatchGlob(pattern, filepath.ToSlash(rel))
+			}) {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// send runs the command or posts to the URL of the hook.
+func (hook *hookConfig) send(payload *hookPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hook.Timeout)
+	defer cancel()
+
+	// Run command.
+	if len(hook.Command) > 0 {
+		cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...) //nolint:gosec // Configured by the user.
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Env = append(os.Environ(), "CHECKSER_EVENT="+payload.Event)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			if msg := strings.TrimSpace(string(output)); msg != "" {
+				return fmt.Errorf("%w: %s", err, msg)
+			}
+			return err
+		}
+		return nil
+	}
+
+	// Post to webhook.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("webhook returned " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhaavi/checkser"
+)
+
+// Job modes.
+const (
+	// modeVerify checks for changes and digests changed files only.
+	modeVerify = "verify"
+	// modeScrub digests all files to detect silent corruption.
+	modeScrub = "scrub"
+	// modeUpdate updates checksum files to reflect file system changes.
+	modeUpdate = "update"
+
+	// modeCheck is an interactive check and only used for results.
+	modeCheck = "check"
+	// modeWatch are the updates of watch mode and only used for results.
+	modeWatch = "watch"
+)
+
+// Job status.
+const (
+	statusRunning = "running"
+	statusOK      = "ok"
+	statusChanges = "changes"
+	statusFailed  = "failed"
+)
+
+// treeConfig configures a tree for jobs.
+type treeConfig struct {
+	Name        string `yaml:"name"`
+	Path        string `yaml:"path"`
+	Schedule    string `yaml:"schedule"`
+	Mode        string `yaml:"mode"`
+	DefaultHash string `yaml:"default_hash"`
+	Canonical   bool   `yaml:"canonical"`
+	KeyFile     string `yaml:"key_file"`
+	SignKey     string `yaml:"sign_key"`
+	TrustedKeys string `yaml:"trusted_keys"`
+	// Policy is a policy rules file, with paths relative to the tree root.
+	Policy string `yaml:"policy"`
+	// AppendOnly enables append-only mode for the tree.
+	AppendOnly bool `yaml:"append_only"`
+	// Archives indexes the members of archives in the tree.
+	Archives bool `yaml:"archives"`
+	// DigestAll digests all files in verify jobs, instead of only the files
+	// whose size or modification time changed. Scrub jobs always digest all files.
+	DigestAll bool `yaml:"digest_all"`
+
+	Limits struct {
+		// ReadRate limits the read rate for digesting, eg. 50MiB (per second).
+		ReadRate string `yaml:"read_rate"`
+	} `yaml:"limits"`
+
+	readRate    int64
+	lockPath    string
+	key         []byte
+	signingKey  *checkser.SigningKey
+	trustedKeys *checkser.TrustedKeys
+	policy      *checkser.Policy
+}
+
+// prepare checks the tree config and loads the keys.
+func (tree *treeConfig) prepare() (err error) {
+	switch {
+	case tree.Name == "":
+		return errors.New("tree name missing")
+	case tree.Path == "":
+		return fmt.Errorf("tree %s: path missing", tree.Name)
+	}
+	tree.Path, err = filepath.Abs(tree.Path)
+	if err != nil {
+		return fmt.Errorf("tree %s: invalid path: %w", tree.Name, err)
+	}
+
+	switch tree.Mode {
+	case "":
+		tree.Mode = modeVerify
+	case modeVerify, modeScrub, modeUpdate:
+	default:
+		return fmt.Errorf("tree %s: unknown mode %q", tree.Name, tree.Mode)
+	}
+
+	if tree.Limits.ReadRate != "" {
+		tree.readRate, err = parseByteSize(tree.Limits.ReadRate)
+		if err != nil {
+			return fmt.Errorf("tree %s: invalid read rate: %w", tree.Name, err)
+		}
+	}
+
+	// Load keys.
+	tree.key, err = loadKey(tree.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tree %s: %w", tree.Name, err)
+	}
+	if tree.SignKey != "" {
+		tree.signingKey, err = loadSigningKey(tree.SignKey)
+		if err != nil {
+			return fmt.Errorf("tree %s: %w", tree.Name, err)
+		}
+	}
+	tree.trustedKeys, err = loadTrustedKeysOrDefault(tree.TrustedKeys)
+	if err != nil {
+		return fmt.Errorf("tree %s: %w", tree.Name, err)
+	}
+
+	// Load policy. Its root is set when the tree root is known.
+	tree.policy, err = loadPolicy(tree.Policy, "")
+	if err != nil {
+		return fmt.Errorf("tree %s: %w", tree.Name, err)
+	}
+
+	return nil
+}
+
+// jobResult is the result of a job.
+type jobResult struct {
+	Tree     string    `json:"tree"`
+	Root     string    `json:"root"`
+	Mode     string    `json:"mode"`
+	Status   string    `json:"status"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end,omitempty"`
+	Duration float64   `json:"duration_seconds"`
+
+	// Code is the exit code bitmask of verify for the findings.
+	Code int `json:"code"`
+
+	Errors []string                `json:"errors,omitempty"`
+	Stats  *checkser.StatsSnapshot `json:"stats,omitempty"`
+}
+
+// complete sets the end, stats and status of the result.
+// The scan may be nil if it failed before scanning.
+func (result *jobResult) complete(scan *checkser.Scan) {
+	result.End = time.Now()
+	result.Duration = result.End.Sub(result.Start).Seconds()
+	if scan != nil {
+		result.Stats = scan.Stats.Snapshot()
+	}
+
+	switch {
+	case len(result.Errors) > 0,
+		result.Stats == nil,
+		result.Stats.FindingErrors > 0,
+		result.Stats.DigestErrors > 0,
+		result.Stats.WriteErrors > 0,
+		result.Stats.PolicyViolations > 0,
+		result.Stats.Total.Failed > 0:
+		result.Status = statusFailed
+	case scan != nil && hasChanges(&scan.Stats.Flagged):
+		result.Status = statusChanges
+	default:
+		result.Status = statusOK
+	}
+	result.Code = resultCode(result.Stats, len(result.Errors) > 0)
+}
+
+// succeeded returns whether the tree was found intact, or was updated without errors.
+func (result *jobResult) succeeded() bool {
+	switch result.Status {
+	case statusOK:
+		return true
+	case statusChanges:
+		return result.Mode == modeUpdate || result.Mode == modeWatch
+	default:
+		return false
+	}
+}
+
+// hasChanges returns whether the change set has any changes.
+func hasChanges(changes *checkser.ChangeSet) bool {
+	return changes.Removed.Load() > 0 ||
+		changes.Added.Load() > 0 ||
+		changes.Changed.Load() > 0 ||
+		changes.TimestampChanged.Load() > 0
+}
+
+// job runs a scan of a tree without any interaction.
+type job struct {
+	tree   *treeConfig
+	mode   string
+	scan   *checkser.Scan
+	result *jobResult
+
+	// rootDir is the root of the scan, which is the tree root when updating a subtree.
+	rootDir string
+
+	// onScan is called with the scan before it starts, if set.
+	onScan func(scan *checkser.Scan)
+
+	// unlock releases the lock of the tree, once locked.
+	unlock func()
+
+	hooks hooks
+}
+
+func newJob(tree *treeConfig, mode string) *job {
+	return &job{
+		tree:    tree,
+		mode:    mode,
+		rootDir: tree.Path,
+		result: &jobResult{
+			Tree:   tree.Name,
+			Root:   tree.Path,
+			Mode:   mode,
+			Status: statusRunning,
+			Start:  time.Now(),
+		},
+	}
+}
+
+// run runs the whole job.
+func (j *job) run() *jobResult {
+	if err := j.lock(); err != nil {
+		j.finish(err)
+		return j.result
+	}
+	defer j.release()
+
+	j.startHooks()
+	err := j.check()
+	report := j.hookReport()
+
+	if err == nil && j.canApply() {
+		j.apply()
+	}
+	j.finish(err)
+	j.finishHooks(report)
+	return j.result
+}
+
+// lock locks the tree, so that no other daemon or API server runs a job on it
+// at the same time.
+func (j *job) lock() error {
+	unlock, err := lockFile(j.tree.lockPath)
+	if err != nil {
+		return fmt.Errorf("failed to lock tree: %w", err)
+	}
+	j.unlock = unlock
+	return nil
+}
+
+// release releases the lock of the tree, if locked.
+func (j *job) release() {
+	if j.unlock != nil {
+		j.unlock()
+		j.unlock = nil
+	}
+}
+
+// check scans the tree and digests files.
+func (j *job) check() error {
+	tree := j.tree
+
+	// Update from the tree root, if the tree is part of a larger tree.
+	var (
+		paths  []string
+		policy *checkser.Policy
+	)
+	if j.mode == modeUpdate || tree.policy != nil {
+		treeRoot, err := checkser.FindTreeRoot(tree.Path)
+		if err != nil {
+			return fmt.Errorf("failed to find tree root: %w", err)
+		}
+		if tree.policy != nil {
+			policy = &checkser.Policy{}
+			*policy = *tree.policy
+			policy.Root = treeRoot
+		}
+		if j.mode == modeUpdate && treeRoot != tree.Path {
+			paths, err = subtreePaths(treeRoot, tree.Path, nil)
+			if err != nil {
+				return err
+			}
+			j.rootDir = treeRoot
+		}
+	}
+
+	// Scan.
+	scan, err := checkser.New(j.rootDir, checkser.ScanConfig{
+		DefaultHash:   checkser.Hash(tree.DefaultHash),
+		DigestAll:     j.mode == modeScrub || tree.DigestAll,
+		Key:           tree.key,
+		Canonical:     tree.Canonical,
+		Paths:         paths,
+		ReadRateLimit: tree.readRate,
+		AppendOnly:    tree.AppendOnly,
+		Archives:      tree.Archives,
+		Policy:        policy,
+		LiveUpdates:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	j.scan = scan
+	if j.onScan != nil {
+		j.onScan(scan)
+	}
+	err = scan.Scan()
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+
+	// Verify chain, signature and MAC.
+	rc := checkRoot(scan, j.rootDir, tree.key, tree.trustedKeys, true)
+	for _, err := range rc.errs() {
+		j.addError(err.Error())
+	}
+
+	// Digest and calculate changes.
+	scan.DigestFiles()
+	scan.CalculateChangeStats()
+
+	return nil
+}
+
+// apply writes the checksum files and signs the root checksum file.
+func (j *job) apply() {
+	j.scan.WriteChecksumFiles()
+	for _, line := range j.scan.WriteErrors() {
+		j.addError(line)
+	}
+
+	switch {
+	case j.tree.signingKey == nil:
+	case j.scan.Stats.WriteErrors.Load() > 0:
+		// Never sign a root whose chain of checksum files is incomplete.
+		j.addError(fmt.Sprintf("not signing root checksum file, as %d checksum files failed to write", j.scan.Stats.WriteErrors.Load()))
+	default:
+		err := checkser.SignRootChecksumFile(j.rootDir, j.tree.signingKey)
+		if err != nil {
+			j.addError(fmt.Sprintf("failed to sign root checksum file: %s", err))
+		}
+	}
+}
+
+// finish completes the result.
+func (j *job) finish(err error) {
+	if err != nil {
+		j.addError(err.Error())
+	}
+	j.result.complete(j.scan)
+}
+
+// canApply returns whether the changes found by check may be applied.
+// Changes are only applied if the tree root could be verified, in order to
+// never re-sign a tampered tree.
+func (j *job) canApply() bool {
+	return j.mode == modeUpdate && j.hasChanges() && len(j.result.Errors) == 0
+}
+
+func (j *job) hasChanges() bool {
+	return j.scan != nil && (hasChanges(&j.scan.Stats.Total) ||
+		j.scan.Stats.AppendOnlyEnabled.Load() > 0 ||
+		j.scan.Stats.ArchivesIndexed.Load() > 0)
+}
+
+// needsReview returns whether any of the changes are flagged by the policy.
+// Without a policy, all changes are flagged.
+func (j *job) needsReview() bool {
+	return j.scan != nil && hasChanges(&j.scan.Stats.Flagged)
+}
+
+func (j *job) startHooks() {
+	for _, err := range j.hooks.start(j.result) {
+		logf("Hook of %s: %s", j.tree.Name, err)
+	}
+}
+
+// hookReport returns a report for the hooks, if needed.
+// It must be called before applying changes.
+func (j *job) hookReport() *checkser.Report {
+	if j.scan == nil || !j.hooks.needReport() {
+		return nil
+	}
+	return j.scan.Report()
+}
+
+func (j *job) finishHooks(report *checkser.Report) {
+	for _, err := range j.hooks.finish(j.result, report) {
+		logf("Hook of %s: %s", j.tree.Name, err)
+	}
+}
+
+func (j *job) addError(msg string) {
+	j.result.Errors = append(j.result.Errors, msg)
+}
+
+// parseByteSize parses sizes like 1048576, 512K, 50MiB or 1GB.
+// Units are binary, regardless of the "i".
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	number := strings.TrimRightFunc(s, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	unit := strings.ToUpper(strings.TrimSpace(s[len(number):]))
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	switch unit {
+	case "":
+		return n, nil
+	case "K":
+		return n << 10, nil
+	case "M":
+		return n << 20, nil
+	case "G":
+		return n << 30, nil
+	case "T":
+		return n << 40, nil
+	default:
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+}
@@ -11,16 +11,16 @@ func liveUpdates(scan *checkser.Scan, progressFunc func() string) (done func())
 	finalized := make(chan struct{})
 
 	// Print initial line.
-	fmt.Printf("%s", progressFunc())
+	fmt.Fprintf(output, "%s", progressFunc())
 
 	// Print updates.
 	go func() {
 		for {
 			select {
 			case <-scan.LiveUpdateSignal():
-				fmt.Printf("\r%s", progressFunc())
+				fmt.Fprintf(output, "\r%s", progressFunc())
 			case <-stop:
-				fmt.Printf("\r%s\n", progressFunc())
+				fmt.Fprintf(output, "\r%s\n", progressFunc())
 				close(finalized)
 				return
 			}
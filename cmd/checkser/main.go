@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/dhaavi/checkser"
 	"github.com/spf13/cobra"
 )
 
@@ -39,6 +42,22 @@ var (
 	flagDefaultHash string
 	flagRebuild     bool
 	flagDigestAll   bool
+	flagFormat      string
+	flagKeyFile     string
+	flagChain       bool
+	flagCanonical   bool
+	flagPaths       []string
+	flagReport      string
+	flagMetricsFile string
+	flagMetricsAddr string
+	flagHooks       string
+	flagPolicy      string
+	flagAppendOnly  bool
+	flagArchives    bool
+
+	// output is where human readable output is written to.
+	// It is switched to stderr when a report is written to stdout.
+	output io.Writer = os.Stdout
 )
 
 func init() {
@@ -49,13 +68,55 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&flagDefaultHash, "default-hash", "", "define default hash algorithm to be used")
 	rootCmd.PersistentFlags().BoolVar(&flagRebuild, "rebuild", false, "complete rebuild: all files are digested, all checksum files rewritten (produces virtual changes)")
 	rootCmd.PersistentFlags().BoolVar(&flagDigestAll, "digest-all", false, "always digest files, not only when size/modtime changed")
+	rootCmd.PersistentFlags().StringVar(&flagFormat, "format", "", "checksum file format to write: yaml, json, cbor, cbor+zstd (default: format of root checksum file)")
+	rootCmd.PersistentFlags().BoolVar(&flagCanonical, "canonical", false, "write canonical checksum files: sorted entries, only rewritten when the content changed")
+	rootCmd.PersistentFlags().BoolVar(&flagChain, "chain", true, "verify the checksum files of parent dirs up to the tree root, if the dir is part of a larger tree")
+	rootCmd.PersistentFlags().StringArrayVar(&flagPaths, "path", nil, "only check the given file or dir, relative to the given dir; can be repeated")
+	rootCmd.PersistentFlags().StringVar(&flagKeyFile, "key-file", "", "enable keyed mode: all digests are keyed with the contents of this file, keep it outside of the tree")
+	rootCmd.PersistentFlags().StringVar(&flagReport, "report", "", "write a machine-readable report to stdout: json, ndjson")
+	rootCmd.PersistentFlags().StringVar(&flagHooks, "hooks", "", "run the hooks in this file on start, finish, changes and errors; the daemon config may be used")
+	rootCmd.PersistentFlags().BoolVar(&flagAppendOnly, "append-only", false, "enable append-only mode for the dir, or the dirs given with --path: removed and changed entries are never recorded; cannot be disabled")
+	rootCmd.PersistentFlags().BoolVar(&flagArchives, "archives", false, "index the members of zip, tar and tar.gz archives, so that changed members are pinpointed")
+	rootCmd.PersistentFlags().StringVar(&flagPolicy, "policy", "", "apply the policy rules in this file to ignore, accept, warn about or refuse changes")
+	rootCmd.PersistentFlags().StringVar(&flagMetricsFile, "metrics-file", "", "write Prometheus metrics to this file after every run, for the node_exporter textfile collector")
 }
 
 func main() {
+	checkser.Generator = "checkser " + Version
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		os.Exit(exitFatal)
+	}
+}
+
+func loadKey(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return key, nil
+}
+
+// loadPolicy loads the policy with paths relative to the given tree root.
+func loadPolicy(policyFile, treeRoot string) (*checkser.Policy, error) {
+	if policyFile == "" {
+		return nil, nil
+	}
+	policy, err := checkser.LoadPolicy(policyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy: %w", err)
 	}
+	policy.Root = treeRoot
+	return policy, nil
 }
 
 func check(cmd *cobra.Command, args []string) error {
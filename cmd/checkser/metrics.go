@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhaavi/checkser"
+)
+
+// metricsKey identifies the runs of a tree in the metrics.
+type metricsKey struct {
+	root string
+	mode string
+}
+
+// metricsRun holds the last run of a tree.
+type metricsRun struct {
+	result      *jobResult
+	lastSuccess *time.Time
+}
+
+// metrics holds the results of the last runs and exports them in the
+// Prometheus text format, as a textfile for the node_exporter or over HTTP.
+type metrics struct {
+	lock sync.Mutex
+
+	// file is the textfile to write after every run.
+	file string
+
+	runs map[metricsKey]*metricsRun
+
+	// retained holds the sample lines of other runs read from the textfile,
+	// per metric name, so that multiple invocations can share one textfile.
+	retained map[metricsKey]map[string][]string
+}
+
+// newMetrics returns new metrics. If file is set, it is written after every
+// run, keeping the samples of other trees found in it.
+func newMetrics(file string) (*metrics, error) {
+	m := &metrics{
+		file:     file,
+		runs:     make(map[metricsKey]*metricsRun),
+		retained: make(map[metricsKey]map[string][]string),
+	}
+	if file == "" {
+		return m, nil
+	}
+
+	// Read samples from an existing textfile.
+	data, err := os.ReadFile(file)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return m, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read metrics file: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, ok := parseMetricsLine(line)
+		if !ok || !strings.HasPrefix(name, "checkser_") {
+			continue
+		}
+		key := metricsKey{root: labels["root"], mode: labels["mode"]}
+		samples, ok := m.retained[key]
+		if !ok {
+			samples = make(map[string][]string)
+			m.retained[key] = samples
+		}
+		samples[name] = append(samples[name], line)
+	}
+
+	return m, nil
+}
+
+// record records the result of a run and writes the textfile, if configured.
+func (m *metrics) record(result *jobResult) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := metricsKey{root: result.Root, mode: result.Mode}
+	run, ok := m.runs[key]
+	if !ok {
+		run = &metricsRun{
+			lastSuccess: m.retainedLastSuccess(key),
+		}
+		m.runs[key] = run
+	}
+	delete(m.retained, key)
+
+	run.result = result
+	if result.succeeded() {
+		run.lastSuccess = &result.End
+	}
+
+	return m.writeFile()
+}
+
+// set sets the last run of a tree, eg. from persisted state, without writing the textfile.
+func (m *metrics) set(result *jobResult, lastSuccess *time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := metricsKey{root: result.Root, mode: result.Mode}
+	delete(m.retained, key)
+	m.runs[key] = &metricsRun{
+		result:      result,
+		lastSuccess: lastSuccess,
+	}
+}
+
+// retainedLastSuccess returns the last success time of a run read from the textfile.
+func (m *metrics) retainedLastSuccess(key metricsKey) *time.Time {
+	for _, line := range m.retained[key]["checkser_last_success_timestamp_seconds"] {
+		fields := strings.Fields(line[strings.LastIndexByte(line, '}')+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		lastSuccess := time.Unix(int64(seconds), 0)
+		return &lastSuccess
+	}
+	return nil
+}
+
+// writeFile writes the textfile atomically, as the node_exporter may read it at any time.
+// The metrics must be locked.
+func (m *metrics) writeFile() error {
+	if m.file == "" {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	m.write(buf)
+	tmpPath := m.file + ".tmp"
+	err := os.WriteFile(tmpPath, buf.Bytes(), 0o0644) //nolint:gosec // Metrics are public.
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	err = os.Rename(tmpPath, m.file)
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// serve serves the metrics on /metrics at the given address in the background.
+func (m *metrics) serve(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logf("Failed to serve metrics: %s", err)
+		}
+	}()
+	return srv
+}
+
+// metricDef defines a metric and how to get its samples from a run.
+type metricDef struct {
+	name    string
+	help    string
+	samples func(run *metricsRun) []metricSample
+}
+
+type metricSample struct {
+	labels []string // Pairs of label names and values.
+	value  float64
+}
+
+var metricDefs = []metricDef{
+	{
+		name: "checkser_run_timestamp_seconds",
+		help: "Start time of the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			return []metricSample{{value: float64(run.result.Start.Unix())}}
+		},
+	},
+	{
+		name: "checkser_run_duration_seconds",
+		help: "Duration of the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			return []metricSample{{value: run.result.Duration}}
+		},
+	},
+	{
+		name: "checkser_run_success",
+		help: "Whether the last run found the tree intact or updated it without errors.",
+		samples: func(run *metricsRun) []metricSample {
+			if run.result.succeeded() {
+				return []metricSample{{value: 1}}
+			}
+			return []metricSample{{value: 0}}
+		},
+	},
+	{
+		name: "checkser_last_success_timestamp_seconds",
+		help: "End time of the last successful run.",
+		samples: func(run *metricsRun) []metricSample {
+			if run.lastSuccess == nil {
+				return nil
+			}
+			return []metricSample{{value: float64(run.lastSuccess.Unix())}}
+		},
+	},
+	{
+		name: "checkser_found",
+		help: "Number of entries found by type in the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			stats := run.result.Stats
+			if stats == nil {
+				return nil
+			}
+			return []metricSample{
+				{labels: []string{"type", "files"}, value: float64(stats.FoundFiles)},
+				{labels: []string{"type", "dirs"}, value: float64(stats.FoundDirs)},
+				{labels: []string{"type", "special"}, value: float64(stats.FoundSpecial)},
+				{labels: []string{"type", "checksum_files"}, value: float64(stats.FoundChecksums)},
+			}
+		},
+	},
+	{
+		name: "checkser_digested_files",
+		help: "Number of files digested in the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			if run.result.Stats == nil {
+				return nil
+			}
+			return []metricSample{{value: float64(run.result.Stats.DigestFiles)}}
+		},
+	},
+	{
+		name: "checkser_digested_bytes",
+		help: "Number of bytes digested in the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			if run.result.Stats == nil {
+				return nil
+			}
+			return []metricSample{{value: float64(run.result.Stats.DigestBytes)}}
+		},
+	},
+	{
+		name: "checkser_changes",
+		help: "Number of changes by type and change in the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			stats := run.result.Stats
+			if stats == nil {
+				return nil
+			}
+			var samples []metricSample
+			for _, set := range []struct {
+				name string
+				cs   *checkser.ChangeSetSnapshot
+			}{
+				{"files", &stats.Files},
+				{"dirs", &stats.Dirs},
+				{"special", &stats.Special},
+			} {
+				samples = append(samples,
+					metricSample{labels: []string{"type", set.name, "change", "added"}, value: float64(set.cs.Added)},
+					metricSample{labels: []string{"type", set.name, "change", "removed"}, value: float64(set.cs.Removed)},
+					metricSample{labels: []string{"type", set.name, "change", "changed"}, value: float64(set.cs.Changed)},
+					metricSample{labels: []string{"type", set.name, "change", "timestamp_changed"}, value: float64(set.cs.TimestampChanged)},
+					metricSample{labels: []string{"type", set.name, "change", "no_change"}, value: float64(set.cs.NoChange)},
+					metricSample{labels: []string{"type", set.name, "change", "failed"}, value: float64(set.cs.Failed)},
+				)
+			}
+			return samples
+		},
+	},
+	{
+		name: "checkser_errors",
+		help: "Number of errors by stage in the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			samples := []metricSample{
+				{labels: []string{"stage", "run"}, value: float64(len(run.result.Errors))},
+			}
+			if stats := run.result.Stats; stats != nil {
+				samples = append(samples,
+					metricSample{labels: []string{"stage", "find"}, value: float64(stats.FindingErrors)},
+					metricSample{labels: []string{"stage", "digest"}, value: float64(stats.DigestErrors)},
+					metricSample{labels: []string{"stage", "write"}, value: float64(stats.WriteErrors)},
+				)
+			}
+			return samples
+		},
+	},
+	{
+		name: "checkser_written_checksum_files",
+		help: "Number of checksum files written in the last run.",
+		samples: func(run *metricsRun) []metricSample {
+			if run.result.Stats == nil {
+				return nil
+			}
+			return []metricSample{{value: float64(run.result.Stats.WriteDone)}}
+		},
+	},
+}
+
+// write writes all metrics in the Prometheus text format.
+// The metrics must be locked.
+func (m *metrics) write(w io.Writer) {
+	// Sort runs for stable output.
+	keys := make([]metricsKey, 0, len(m.runs))
+	for key := range m.runs {
+		keys = append(keys, key)
+	}
+	retainedKeys := make([]metricsKey, 0, len(m.retained))
+	for key := range m.retained {
+		retainedKeys = append(retainedKeys, key)
+	}
+	compareKeys := func(a, b metricsKey) int {
+		if c := strings.Compare(a.root, b.root); c != 0 {
+			return c
+		}
+		return strings.Compare(a.mode, b.mode)
+	}
+	slices.SortFunc(keys, compareKeys)
+	slices.SortFunc(retainedKeys, compareKeys)
+
+	for _, def := range metricDefs {
+		fmt.Fprintf(w, "# HELP %s %s\n", def.name, def.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", def.name)
+		for _, key := range keys {
+			run := m.runs[key]
+			for _, sample := range def.samples(run) {
+				labels := append([]string{
+					"root", run.result.Root,
+					"tree", run.result.Tree,
+					"mode", run.result.Mode,
+				}, sample.labels...)
+				fmt.Fprintf(w, "%s{%s} %s\n", def.name, formatLabels(labels), strconv.FormatFloat(sample.value, 'f', -1, 64))
+			}
+		}
+		for _, key := range retainedKeys {
+			for _, line := range m.retained[key][def.name] {
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats pairs of label names and values.
+func formatLabels(labels []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	return b.String()
+}
+
+// parseMetricsLine parses the name and labels of a sample line in the Prometheus text format.
+func parseMetricsLine(line string) (name string, labels map[string]string, ok bool) {
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return "", nil, false
+	}
+	name = line[:end]
+	labels = make(map[string]string)
+	if line[end] != '{' {
+		return name, labels, true
+	}
+
+	rest := line[end+1:]
+	for {
+		rest = strings.TrimLeft(rest, ", ")
+		if strings.HasPrefix(rest, "}") {
+			return name, labels, true
+		}
+
+		// Parse label name.
+		eq := strings.Index(rest, `="`)
+		if eq <= 0 {
+			return "", nil, false
+		}
+		labelName := rest[:eq]
+		rest = rest[eq+2:]
+
+		// Parse escaped label value.
+		var value strings.Builder
+		for {
+			if rest == "" {
+				return "", nil, false
+			}
+			c := rest[0]
+			rest = rest[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\\' && rest != "" {
+				c = rest[0]
+				rest = rest[1:]
+				if c == 'n' {
+					c = '\n'
+				}
+			}
+			value.WriteByte(c)
+		}
+		labels[labelName] = value.String()
+	}
+}
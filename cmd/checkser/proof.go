@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	proveCmd = &cobra.Command{
+		Use:   "prove [file]",
+		Short: "Export a proof bundle of the file with the chain of checksum files up to the tree root.",
+		RunE:  prove,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	checkProofCmd = &cobra.Command{
+		Use:   "check-proof [proof file] [file]",
+		Short: "Verify a file against a proof bundle and a trusted root digest (--root-digest) or signature (--trusted-keys). Use - to read the file from stdin.",
+		RunE:  checkProof,
+		Args:  cobra.ExactArgs(2),
+	}
+
+	flagProofOut   string
+	flagRootDigest string
+)
+
+func init() {
+	rootCmd.AddCommand(proveCmd)
+	rootCmd.AddCommand(checkProofCmd)
+
+	proveCmd.Flags().StringVarP(&flagProofOut, "out", "o", "", "write proof to this file instead of stdout")
+	checkProofCmd.Flags().StringVar(&flagRootDigest, "root-digest", "", "trusted digest of the root checksum file, as printed by prove")
+}
+
+func prove(_ *cobra.Command, args []string) error {
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Create proof.
+	proof, err := checkser.Prove(args[0], key)
+	if err != nil {
+		return fmt.Errorf("failed to create proof: %w", err)
+	}
+	data, err := proof.Pack()
+	if err != nil {
+		return fmt.Errorf("failed to pack proof: %w", err)
+	}
+	rootDigest, err := proof.RootDigest(key)
+	if err != nil {
+		return err
+	}
+
+	// Write proof.
+	if flagProofOut != "" {
+		err = os.WriteFile(flagProofOut, data, 0o0644)
+		if err != nil {
+			return fmt.Errorf("failed to write proof: %w", err)
+		}
+	} else {
+		_, err = os.Stdout.Write(data)
+		if err != nil {
+			return fmt.Errorf("failed to write proof: %w", err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Root digest: %s %s\n", proof.RootAlgorithm, rootDigest)
+	if len(proof.Signature) > 0 {
+		fmt.Fprintln(os.Stderr, "Root checksum file is signed, the signature is included.")
+	}
+	return nil
+}
+
+func checkProof(_ *cobra.Command, args []string) error {
+	if flagRootDigest == "" && flagTrustedKeys == "" {
+		return errors.New("trusted root digest (--root-digest) or trusted keys (--trusted-keys) required")
+	}
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Load proof.
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read proof: %w", err)
+	}
+	proof, err := checkser.LoadProof(data)
+	if err != nil {
+		return err
+	}
+
+	// Verify proof.
+	if flagRootDigest != "" {
+		err = proof.VerifyRoot(flagRootDigest, key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(output, "Root: matches trusted root digest")
+	}
+	if flagTrustedKeys != "" {
+		trustedKeys, err := loadTrustedKeys(flagTrustedKeys)
+		if err != nil {
+			return err
+		}
+		signedBy, err := proof.VerifySignature(trustedKeys, key)
+		if err != nil {
+			return fmt.Errorf("root checksum file signature: %w", err)
+		}
+		fmt.Fprintf(output, "Root: signed by %s\n", signedBy)
+	}
+
+	// Verify file.
+	var file io.Reader = os.Stdin
+	if args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close() //nolint:errcheck
+		file = f
+	}
+	err = proof.VerifyFile(file, key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "%s: OK (%s)\n", args[1], proof.Path)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dhaavi/checkser"
+)
+
+// Report formats.
+const (
+	reportJSON   = "json"
+	reportNDJSON = "ndjson"
+)
+
+func checkReportFlag() error {
+	switch flagReport {
+	case "":
+		// No report.
+	case reportJSON, reportNDJSON:
+		// Write human readable output to stderr instead.
+		output = os.Stderr
+	default:
+		return fmt.Errorf("unknown report format %q", flagReport)
+	}
+	return nil
+}
+
+func writeReport(scan *checkser.Scan, report *checkser.Report) {
+	report.Finalize(scan)
+
+	var err error
+	switch flagReport {
+	case reportJSON:
+		err = report.WriteJSON(os.Stdout)
+	case reportNDJSON:
+		err = report.WriteNDJSON(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write report: %s\n", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+)
+
+// rootCheck holds the results of verifying the chain of checksum files up to
+// the tree root and the signature and MAC of the root checksum file.
+type rootCheck struct {
+	rootDir string
+
+	chain    *checkser.Chain
+	chainErr error
+
+	sigChecked bool
+	signedBy   string
+	sigErr     error
+	// sigUnverified is set if the root checksum file is signed, but there
+	// are no trusted keys to verify the signature with.
+	sigUnverified bool
+
+	macChecked bool
+	macErr     error
+}
+
+// checkRoot verifies the chain of checksum files from the scanned dir up to
+// the root of its tree and the signature and MAC of the root checksum file.
+func checkRoot(scan *checkser.Scan, dir string, key []byte, trustedKeys *checkser.TrustedKeys, verifyChain bool) *rootCheck {
+	rc := &rootCheck{
+		rootDir: dir,
+	}
+
+	// Verify chain up to the tree root.
+	if verifyChain {
+		rc.chain, rc.chainErr = scan.VerifyChain()
+		switch {
+		case rc.chainErr != nil:
+			rc.chainErr = fmt.Errorf("failed to verify chain: %w", rc.chainErr)
+		case len(rc.chain.Links) > 0:
+			rc.rootDir = rc.chain.Root
+			rc.chainErr = rc.chain.Err()
+		}
+	}
+
+	// Verify signature.
+	if trustedKeys != nil {
+		rc.sigChecked = true
+		if rc.rootDir == dir {
+			rc.signedBy, rc.sigErr = scan.VerifySignature(trustedKeys)
+		} else {
+			rc.signedBy, rc.sigErr = checkser.VerifyRootChecksumFile(rc.rootDir, trustedKeys)
+		}
+	} else if _, err := os.Stat(filepath.Join(rc.rootDir, checkser.SignatureFilename)); err == nil {
+		rc.sigUnverified = true
+	}
+
+	// Verify MAC.
+	if key != nil {
+		rc.macChecked = true
+		if rc.rootDir == dir {
+			rc.macErr = scan.VerifyRootMAC()
+		} else {
+			rc.macErr = checkser.VerifyRootMACFile(rc.rootDir, key)
+		}
+	}
+
+	return rc
+}
+
+// errs returns all errors.
+func (rc *rootCheck) errs() (errs []error) {
+	if rc.chainErr != nil {
+		errs = append(errs, rc.chainErr)
+	}
+	if rc.sigErr != nil {
+		errs = append(errs, fmt.Errorf("root checksum file signature: %w", rc.sigErr))
+	}
+	if rc.macErr != nil {
+		errs = append(errs, rc.macErr)
+	}
+	return errs
+}
+
+// verifyRoot verifies the chain of checksum files from the scanned dir up to
+// the root of its tree and the signature and MAC of the root checksum file.
+// It prints the results and returns the tree root and all errors.
+func verifyRoot(scan *checkser.Scan, dir string, key []byte, trustedKeys *checkser.TrustedKeys) (rootDir string, errs []error) {
+	rc := checkRoot(scan, dir, key, trustedKeys, flagChain)
+	var printed bool
+
+	// Print chain.
+	switch {
+	case rc.chain == nil && rc.chainErr != nil:
+		fmt.Fprintf(output, "Chain: %s\n", rc.chainErr)
+		printed = true
+	case rc.chain == nil || len(rc.chain.Links) == 0:
+		// Dir is the tree root.
+	case rc.chainErr != nil:
+		fmt.Fprintf(output, "Chain: broken between %s and tree root %s:\n", dir, rc.rootDir)
+		for _, link := range rc.chain.Links {
+			if link.Err != nil {
+				fmt.Fprintf(output, "        Error: %s: %s\n", link.Dir, link.Err)
+			}
+		}
+		printed = true
+	default:
+		fmt.Fprintf(output, "Chain: verified %d checksum files up to tree root %s\n", len(rc.chain.Links), rc.rootDir)
+		printed = true
+	}
+
+	// Print signature.
+	switch {
+	case rc.sigChecked && rc.sigErr != nil:
+		fmt.Fprintf(output, "Signature: %s\n", rc.sigErr)
+		printed = true
+	case rc.sigChecked:
+		fmt.Fprintf(output, "Signature: valid, signed by %s\n", rc.signedBy)
+		printed = true
+	case rc.sigUnverified:
+		printUnverifiedSignature()
+		printed = true
+	}
+
+	// Print MAC.
+	if rc.macChecked {
+		if rc.macErr != nil {
+			fmt.Fprintf(output, "Root MAC: %s\n", rc.macErr)
+		} else {
+			fmt.Fprintln(output, "Root MAC: valid")
+		}
+		printed = true
+	}
+
+	if printed {
+		fmt.Fprintln(output, "")
+	}
+	return rc.rootDir, rc.errs()
+}
+
+// printUnverifiedSignature warns that the signature of the root checksum file
+// was not verified, as no trusted keys are configured.
+func printUnverifiedSignature() {
+	fmt.Fprintf(output, "Signature: WARNING: root checksum file is signed, but not verified without trusted keys (--trusted-keys or %s)\n", defaultTrustedKeysFile())
+}
@@ -7,28 +7,133 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/dhaavi/checkser"
 	"github.com/spf13/cobra"
 )
 
+var errChangesDetected = errors.New("changes or errors detected")
+
 var (
 	runInteractive bool
 	runUpdate      bool
 	runVerify      bool
 )
 
-func run(_ *cobra.Command, args []string) error {
+func run(_ *cobra.Command, args []string) (err error) {
+	if err := checkReportFlag(); err != nil {
+		return err
+	}
+
 	dir, err := filepath.Abs(args[0])
 	if err != nil {
 		return fmt.Errorf("invalid directory: %w", err)
 	}
 
+	// Record metrics and run hooks.
+	var (
+		scan       *checkser.Scan
+		rootErrs   []error
+		m          *metrics
+		hs         hooks
+		hookReport *checkser.Report
+	)
+	if flagMetricsFile != "" || flagHooks != "" {
+		m, err = newMetrics(flagMetricsFile)
+		if err != nil {
+			return err
+		}
+		hs, err = loadHooks(flagHooks)
+		if err != nil {
+			return err
+		}
+		result := &jobResult{
+			Root:  dir,
+			Mode:  runMode(),
+			Start: time.Now(),
+		}
+		printErrs(hs.start(result))
+		defer func() {
+			for _, rootErr := range rootErrs {
+				result.Errors = append(result.Errors, rootErr.Error())
+			}
+			// Changes and root errors are already part of the result.
+			if err != nil && !errors.Is(err, errChangesDetected) && (len(rootErrs) == 0 || !errors.Is(err, rootErrs[0])) {
+				result.Errors = append(result.Errors, err.Error())
+			}
+			result.complete(scan)
+			if flagMetricsFile != "" {
+				if mErr := m.record(result); mErr != nil {
+					fmt.Fprintln(output, mErr)
+				}
+			}
+			printErrs(hs.finish(result, hookReport))
+		}()
+	}
+
+	// Get paths to limit the scan to.
+	paths, err := scanPaths(dir)
+	if err != nil {
+		return err
+	}
+
+	// Find root of the tree, if dir is part of a larger tree.
+	treeRoot, err := checkser.FindTreeRoot(dir)
+	if err != nil {
+		return fmt.Errorf("failed to find tree root: %w", err)
+	}
+	if treeRoot != dir && flagChain && !runVerify {
+		// Scan from the tree root, so that the checksum files of all parent
+		// dirs are updated with the subtree.
+		paths, err = subtreePaths(treeRoot, dir, paths)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "Checking %s within tree root %s\n\n", dir, treeRoot)
+		dir = treeRoot
+	}
+
+	// Load policy, which is relative to the tree root.
+	policy, err := loadPolicy(flagPolicy, treeRoot)
+	if err != nil {
+		return err
+	}
+
+	// Load key for keyed mode.
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Load keys for signing and verifying the root checksum file.
+	var (
+		signingKey  *checkser.SigningKey
+		trustedKeys *checkser.TrustedKeys
+	)
+	if flagSignKey != "" && !runVerify {
+		signingKey, err = loadSigningKey(flagSignKey)
+		if err != nil {
+			return err
+		}
+	}
+	trustedKeys, err = loadTrustedKeysFlag()
+	if err != nil {
+		return err
+	}
+
 	// Create new scan.
-	scan, err := checkser.New(dir, checkser.ScanConfig{
+	scan, err = checkser.New(dir, checkser.ScanConfig{
 		DefaultHash: checkser.Hash(flagDefaultHash),
 		Rebuild:     flagRebuild,
 		DigestAll:   flagDigestAll || runVerify,
+		Format:      checkser.Format(flagFormat),
+		Key:         key,
+		Canonical:   flagCanonical,
+		Paths:       paths,
+		AppendOnly:  flagAppendOnly,
+		Archives:    flagArchives,
+		Policy:      policy,
 		LiveUpdates: runInteractive,
 	})
 	if err != nil {
@@ -40,7 +145,7 @@ func run(_ *cobra.Command, args []string) error {
 	if runInteractive {
 		stopLive = liveUpdates(scan, scan.FmtFindStatusProgress)
 	} else {
-		fmt.Println("Finding files and directories...")
+		fmt.Fprintln(output, "Finding files and directories...")
 	}
 	err = scan.Scan()
 	stopLive()
@@ -48,23 +153,46 @@ func run(_ *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid directory: %w", err)
 	}
 	for _, line := range scan.FmtFindStatus() {
-		fmt.Println(line)
+		fmt.Fprintln(output, line)
 	}
-	fmt.Println("")
+	fmt.Fprintln(output, "")
 
-	// Prompt before continuing when there are errors.
+	// Verify chain of checksum files up to the tree root, its signature and MAC.
+	_, rootErrs = verifyRoot(scan, dir, key, trustedKeys)
+
+	// Prompt before continuing when the root checksum file could not be verified.
 	cliReader := bufio.NewReader(os.Stdin)
+	if runInteractive && len(rootErrs) > 0 {
+	actionRoot:
+		for {
+			fmt.Fprintf(output, "Root checksum file could not be verified - continue? [y]es, [q]uit: ")
+			line, err := cliReader.ReadString('\n')
+			if err != nil {
+				fmt.Fprintf(output, "failed to read action: %s\n", err)
+			}
+			switch strings.TrimSpace(line) {
+			case "Y", "y":
+				break actionRoot
+
+			case "Q", "q":
+				return nil
+			}
+		}
+		fmt.Fprintln(output, "")
+	}
+
+	// Prompt before continuing when there are errors.
 	if runInteractive && scan.Stats.FindingErrors.Load() > 0 {
 	actionFind:
 		for {
 			if lessIsAvailable() {
-				fmt.Printf("Encountered %d errors during scan - continue? [y]es, [q]uit, [v]iew errors (with less): ", scan.Stats.FindingErrors.Load())
+				fmt.Fprintf(output, "Encountered %d errors during scan - continue? [y]es, [q]uit, [v]iew errors (with less): ", scan.Stats.FindingErrors.Load())
 			} else {
-				fmt.Printf("Encountered %d errors during scan - continue? [y]es, [q]uit, [v]iew errors: ", scan.Stats.FindingErrors.Load())
+				fmt.Fprintf(output, "Encountered %d errors during scan - continue? [y]es, [q]uit, [v]iew errors: ", scan.Stats.FindingErrors.Load())
 			}
 			line, err := cliReader.ReadString('\n')
 			if err != nil {
-				fmt.Printf("failed to read action: %s\n", err)
+				fmt.Fprintf(output, "failed to read action: %s\n", err)
 			}
 			switch strings.TrimSpace(line) {
 			case "Y", "y":
@@ -77,65 +205,118 @@ func run(_ *cobra.Command, args []string) error {
 				viewDetails(scan, checkser.ErrMsgs)
 			}
 		}
-		fmt.Println("")
+		fmt.Fprintln(output, "")
 	}
 
 	// Digest any needed files.
 	if runInteractive {
 		stopLive = liveUpdates(scan, scan.FmtDigestStatusProgress)
 	} else {
-		fmt.Println("Digesting files...")
+		fmt.Fprintln(output, "Digesting files...")
 	}
 	scan.DigestFiles()
 	stopLive()
 	for _, line := range scan.FmtDigestStatus() {
-		fmt.Println(line)
+		fmt.Fprintln(output, line)
 	}
-	fmt.Println("")
+	fmt.Fprintln(output, "")
 
 	// Calculate changes.
-	fmt.Println("Detected Changes:")
+	fmt.Fprintln(output, "Detected Changes:")
 	scan.CalculateChangeStats()
+	// Create reports before writing, as writing applies the changes.
+	if flagReport != "" {
+		report := scan.Report()
+		defer writeReport(scan, report)
+	}
+	if hs.needReport() {
+		hookReport = scan.Report()
+	}
 	for _, line := range scan.FmtChangeStatus() {
-		fmt.Println(line)
+		fmt.Fprintln(output, line)
 	}
-	fmt.Println("")
+	fmt.Fprintln(output, "")
 
-	// Check if there are any changes.
+	// Check if there are any changes that need review.
+	review := true
 	switch {
+	case len(rootErrs) > 0:
 	case scan.Stats.FindingErrors.Load() > 0:
 	case scan.Stats.DigestErrors.Load() > 0:
-	case scan.Stats.Total.Removed.Load() > 0:
-	case scan.Stats.Total.Added.Load() > 0:
-	case scan.Stats.Total.Changed.Load() > 0:
-	case scan.Stats.Total.TimestampChanged.Load() > 0:
+	case scan.Stats.Flagged.Removed.Load() > 0:
+	case scan.Stats.Flagged.Added.Load() > 0:
+	case scan.Stats.Flagged.Changed.Load() > 0:
+	case scan.Stats.Flagged.TimestampChanged.Load() > 0:
 	case scan.Stats.Total.Failed.Load() > 0:
+	case scan.Stats.Total.Removed.Load() > 0,
+		scan.Stats.Total.Added.Load() > 0,
+		scan.Stats.Total.Changed.Load() > 0,
+		scan.Stats.Total.TimestampChanged.Load() > 0:
+		// All changes are ignored or accepted by the policy.
+		fmt.Fprintf(output,
+			"All changes are ignored or accepted by policy (%d ignored, %d accepted).\n",
+			scan.Stats.PolicyIgnored.Load(),
+			scan.Stats.PolicyAccepted.Load(),
+		)
+		if runVerify {
+			return nil
+		}
+		review = false
+	case scan.Stats.AppendOnlyEnabled.Load() > 0 && !runVerify:
+		// Record append-only mode.
+		fmt.Fprintf(output, "No changes found. Enabling append-only mode for %d dirs.\n", scan.Stats.AppendOnlyEnabled.Load())
+		review = false
+	case scan.Stats.ArchivesIndexed.Load() > 0 && !runVerify:
+		// Record members of archives.
+		fmt.Fprintf(output, "No changes found. Indexing the members of %d archives.\n", scan.Stats.ArchivesIndexed.Load())
+		review = false
 	default:
-		fmt.Printf(
+		fmt.Fprintf(output,
 			"Checked all %d files, %d dirs and %d other. No changes found.\n",
 			scan.Stats.Files.NoChange.Load(),
 			scan.Stats.Dirs.NoChange.Load(),
 			scan.Stats.Special.NoChange.Load(),
 		)
+
+		// Bring checksum files into canonical form, if needed.
+		// Checksum files already in canonical form are not touched.
+		if flagCanonical && !runVerify {
+			scan.WriteChecksumFiles()
+			if scan.Stats.WriteDone.Load() > 0 {
+				fmt.Fprintf(output, "Rewrote %d checksum files in canonical form.\n", scan.Stats.WriteDone.Load())
+			}
+			for _, line := range scan.WriteErrors() {
+				fmt.Fprintln(output, line)
+			}
+		}
 		return nil
 	}
 
 	// Return an error if we are just verifying.
 	if runVerify {
-		return errors.New("changes or errors detected")
+		code := resultCode(scan.Stats.Snapshot(), len(rootErrs) > 0)
+		if len(rootErrs) > 0 {
+			return &exitError{code: code, err: errors.Join(rootErrs...)}
+		}
+		return &exitError{code: code, err: errChangesDetected}
 	}
 
-	if runInteractive {
+	// Changes violating the policy or append-only mode are never recorded.
+	if violations := scan.Stats.PolicyViolations.Load(); violations > 0 {
+		fmt.Fprintf(output, "%d changes violate the policy or append-only mode and will not be recorded.\n", violations)
+	}
+
+	if runInteractive && review {
 	action:
 		for {
 			if lessIsAvailable() {
-				fmt.Printf("Apply? [y]es, [q]uit, [v]iew changes (with less): [a]dded, [r]emoved, [c]hanged, [n]o change, [f]ailed: ")
+				fmt.Fprintf(output, "Apply? [y]es, [q]uit, [v]iew changes (with less): [a]dded, [r]emoved, [c]hanged, [n]o change, [f]ailed: ")
 			} else {
-				fmt.Printf("Apply? [y]es, [q]uit, [v]iew changes: [a]dded, [r]emoved, [c]hanged, [n]o change, [f]ailed: ")
+				fmt.Fprintf(output, "Apply? [y]es, [q]uit, [v]iew changes: [a]dded, [r]emoved, [c]hanged, [n]o change, [f]ailed: ")
 			}
 			line, err := cliReader.ReadString('\n')
 			if err != nil {
-				fmt.Printf("failed to read action: %s\n", err)
+				fmt.Fprintf(output, "failed to read action: %s\n", err)
 			}
 			switch strings.TrimSpace(line) {
 			case "Y", "y":
@@ -158,39 +339,121 @@ func run(_ *cobra.Command, args []string) error {
 				viewDetails(scan, checkser.Failed)
 			}
 		}
-		fmt.Println("")
+		fmt.Fprintln(output, "")
 	}
 
 	// Write checksum files.
 	if runInteractive {
 		stopLive = liveUpdates(scan, scan.FmtWriteStatusProgress)
 	} else {
-		fmt.Println("Writing checksum files...")
+		fmt.Fprintln(output, "Writing checksum files...")
 	}
 	scan.WriteChecksumFiles()
 	stopLive()
-	fmt.Printf("Successfully written %d checksum files.\n", scan.Stats.WriteDone.Load())
+	fmt.Fprintf(output, "Successfully written %d checksum files.\n", scan.Stats.WriteDone.Load())
 	if scan.Stats.WriteErrors.Load() > 0 {
-		fmt.Printf("Encountered %d errors during writing checksum files:\n", scan.Stats.WriteErrors.Load())
+		fmt.Fprintf(output, "Encountered %d errors during writing checksum files:\n", scan.Stats.WriteErrors.Load())
 		for _, line := range scan.WriteErrors() {
-			fmt.Println(line)
+			fmt.Fprintln(output, line)
 		}
 	}
 
+	// Sign the new root checksum file.
+	switch {
+	case treeRoot != dir:
+		fmt.Fprintf(output, "The checksum files of the parent dirs up to the tree root are now out of date. Update them with checkser update %s\n", treeRoot)
+	case signingKey != nil && scan.Stats.WriteErrors.Load() > 0:
+		// Never sign a root whose chain of checksum files is incomplete.
+		return &exitError{
+			code: exitErrors,
+			err:  fmt.Errorf("not signing root checksum file, as %d checksum files failed to write", scan.Stats.WriteErrors.Load()),
+		}
+	case signingKey != nil:
+		err := checkser.SignRootChecksumFile(dir, signingKey)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(output, "Signed root checksum file.")
+	case trustedKeys != nil || fileExists(filepath.Join(dir, checkser.SignatureFilename)):
+		fmt.Fprintln(output, "Root checksum file changed, its signature is no longer valid. Sign it again with checkser sign.")
+	}
+
 	// Return an error if running update.
+	// Changes are applied, so only errors are reported in the exit code.
 	if runUpdate {
-		if scan.Stats.FindingErrors.Load() > 0 ||
-			scan.Stats.DigestErrors.Load() > 0 ||
-			scan.Stats.WriteErrors.Load() > 0 {
-			fmt.Println("")
-			return fmt.Errorf(
-				"update complete, encountered %d scan errors, %d digest errors and %d write errors",
-				scan.Stats.FindingErrors.Load(),
-				scan.Stats.DigestErrors.Load(),
-				scan.Stats.WriteErrors.Load(),
-			)
+		code := resultCode(scan.Stats.Snapshot(), len(rootErrs) > 0) & (exitErrors | exitDigestErrors)
+		if code != 0 {
+			fmt.Fprintln(output, "")
+			return &exitError{
+				code: code,
+				err: fmt.Errorf(
+					"update complete, encountered %d scan errors, %d digest errors, %d write errors, %d root checksum file errors and %d violations",
+					scan.Stats.FindingErrors.Load(),
+					scan.Stats.DigestErrors.Load(),
+					scan.Stats.WriteErrors.Load(),
+					len(rootErrs),
+					scan.Stats.PolicyViolations.Load(),
+				),
+			}
 		}
 	}
 
 	return nil
 }
+
+// runMode returns the mode of the run for results.
+func runMode() string {
+	switch {
+	case runVerify:
+		return modeVerify
+	case runUpdate:
+		return modeUpdate
+	default:
+		return modeCheck
+	}
+}
+
+func printErrs(errs []error) {
+	for _, err := range errs {
+		fmt.Fprintln(output, err)
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// scanPaths returns the paths given with --path relative to dir.
+func scanPaths(dir string) ([]string, error) {
+	paths := make([]string, 0, len(flagPaths))
+	for _, path := range flagPaths {
+		if filepath.IsAbs(path) {
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %s: %w", path, err)
+			}
+			path = relPath
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// subtreePaths returns the paths within the subtree dir relative to the tree root.
+// If no paths are given, the whole subtree is returned.
+func subtreePaths(treeRoot, dir string, paths []string) ([]string, error) {
+	subDir, err := filepath.Rel(treeRoot, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tree root: %w", err)
+	}
+	if len(paths) == 0 {
+		return []string{subDir}, nil
+	}
+
+	subPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		subPaths = append(subPaths, filepath.Join(subDir, path))
+	}
+	return subPaths, nil
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a local HTTP API to run jobs on the trees in the config file (--config) and review their changes.",
+		RunE:  serve,
+		Args:  cobra.NoArgs,
+	}
+
+	flagServeListen    string
+	flagServeTokenFile string
+)
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&flagDaemonConfig, "config", "", "daemon config file with the trees to serve")
+	serveCmd.Flags().StringVar(&flagServeListen, "listen", "localhost:9180", "address to listen on")
+	serveCmd.Flags().StringVar(&flagServeTokenFile, "token-file", "", "file with the token that requests must authenticate with as bearer token")
+	_ = serveCmd.MarkFlagRequired("token-file")
+}
+
+// Serve job states.
+const (
+	// stateRunning is set while checking the tree.
+	stateRunning = "running"
+	// stateAwaitingApproval is set when an update found changes that need to be approved.
+	stateAwaitingApproval = "awaiting_approval"
+	// stateApplying is set while writing checksum files after approval.
+	stateApplying = "applying"
+	// stateDone is set when the job is finished.
+	stateDone = "done"
+)
+
+// minEventInterval is the minimum interval between progress events.
+const minEventInterval = 250 * time.Millisecond
+
+// server serves the HTTP API.
+type server struct {
+	trees   map[string]*treeConfig
+	hooks   hooks
+	metrics *metrics
+	token   string
+	// listenHost is the host the server listens on.
+	listenHost string
+
+	lock   sync.Mutex
+	jobs   map[string]*serveJob
+	lastID int
+}
+
+// serveJob is a job started over the API.
+type serveJob struct {
+	*job
+	id string
+
+	lock  sync.Mutex
+	state string
+
+	// liveScan is the scan of the job for progress, once started.
+	liveScan *checkser.Scan
+	// report holds all entries of the scan, once checked.
+	report *checkser.Report
+	// notify is closed and replaced when the job makes progress.
+	notify chan struct{}
+	// done is closed when the job is done.
+	done chan struct{}
+}
+
+// serveJobView is the JSON representation of a job.
+type serveJobView struct {
+	ID    string `json:"id"`
+	Tree  string `json:"tree"`
+	Mode  string `json:"mode"`
+	State string `json:"state"`
+
+	// Progress holds the live stats while running or applying.
+	Progress *checkser.StatsSnapshot `json:"progress,omitempty"`
+	// Result holds the result when awaiting approval or done.
+	Result *jobResult `json:"result,omitempty"`
+}
+
+func serve(_ *cobra.Command, _ []string) error {
+	cfg, err := loadDaemonConfig(flagDaemonConfig)
+	if err != nil {
+		return err
+	}
+
+	srv := &server{
+		trees: make(map[string]*treeConfig, len(cfg.Trees)),
+		hooks: cfg.Hooks,
+		jobs:  make(map[string]*serveJob),
+	}
+	for _, tree := range cfg.Trees {
+		if err := tree.prepare(); err != nil {
+			return err
+		}
+		srv.trees[tree.Name] = tree
+	}
+	token, err := os.ReadFile(flagServeTokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read token file: %w", err)
+	}
+	srv.token = strings.TrimSpace(string(token))
+	if srv.token == "" {
+		return errors.New("token file is empty")
+	}
+	srv.listenHost, _, err = net.SplitHostPort(flagServeListen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address: %w", err)
+	}
+	srv.metrics, err = newMetrics(flagMetricsFile)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/trees", srv.handleTrees)
+	mux.HandleFunc("POST /api/trees/{tree}/jobs", srv.handleStartJob)
+	mux.HandleFunc("GET /api/jobs", srv.handleJobs)
+	mux.HandleFunc("GET /api/jobs/{id}", srv.handleJob)
+	mux.HandleFunc("GET /api/jobs/{id}/events", srv.handleJobEvents)
+	mux.HandleFunc("GET /api/jobs/{id}/changes", srv.handleJobChanges)
+	mux.HandleFunc("POST /api/jobs/{id}/approve", srv.handleApprove)
+	mux.HandleFunc("POST /api/jobs/{id}/discard", srv.handleDiscard)
+	mux.Handle("GET /metrics", srv.metrics)
+
+	httpSrv := &http.Server{
+		Addr:              flagServeListen,
+		Handler:           srv.authenticate(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpSrv.Shutdown(shutdownCtx)
+	}()
+
+	logf("Serving API on http://%s/api/", flagServeListen)
+	err = httpSrv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// authenticate checks the bearer token, if configured.
+// authenticate rejects requests without the bearer token and requests that
+// may come from other web sites: with a foreign Host (DNS rebinding), a foreign
+// Origin or, for requests that change state, without a JSON content type, as
+// browsers do not send those cross-origin without asking the server first.
+func (srv *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !srv.allowedHost(r.Host):
+			writeError(w, http.StatusForbidden, errors.New("host not allowed"))
+			return
+		case r.Header.Get("Origin") != "" && !srv.allowedOrigin(r.Header.Get("Origin"), r.Host):
+			writeError(w, http.StatusForbidden, errors.New("origin not allowed"))
+			return
+		case r.Method != http.MethodGet && r.Method != http.MethodHead && !isJSONContentType(r.Header.Get("Content-Type")):
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost returns whether the Host header names the server directly, by
+// IP address, localhost or the host it listens on.
+func (srv *server) allowedHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.ParseIP(host) != nil ||
+		strings.EqualFold(host, "localhost") ||
+		(srv.listenHost != "" && strings.EqualFold(host, srv.listenHost))
+}
+
+// allowedOrigin returns whether the origin is the server itself.
+func (srv *server) allowedOrigin(origin, hostport string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme == "http" && strings.EqualFold(u.Host, hostport)
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+func (srv *server) handleTrees(w http.ResponseWriter, _ *http.Request) {
+	type treeView struct {
+		Name    string        `json:"name"`
+		Path    string        `json:"path"`
+		Mode    string        `json:"mode"`
+		LastJob *serveJobView `json:"last_job,omitempty"`
+	}
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	trees := make([]*treeView, 0, len(srv.trees))
+	for _, tree := range srv.trees {
+		view := &treeView{
+			Name: tree.Name,
+			Path: tree.Path,
+			Mode: tree.Mode,
+		}
+		if sj := srv.lastJob(tree.Name); sj != nil {
+			view.LastJob = sj.view()
+		}
+		trees = append(trees, view)
+	}
+	slices.SortFunc(trees, func(a, b *treeView) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	writeJSON(w, http.StatusOK, trees)
+}
+
+func (srv *server) handleStartJob(w http.ResponseWriter, r *http.Request) {
+	tree, ok := srv.trees[r.PathValue("tree")]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown tree"))
+		return
+	}
+
+	// Get mode, defaulting to the mode of the tree.
+	var req struct {
+		Mode string `json:"mode"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = tree.Mode
+	case modeVerify, modeScrub, modeUpdate:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown mode %q", req.Mode))
+		return
+	}
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	// Only run one job per tree at a time.
+	if last := srv.lastJob(tree.Name); last != nil {
+		if state := last.getState(); state != stateDone {
+			writeError(w, http.StatusConflict, fmt.Errorf("job %s of tree is %s", last.id, state))
+			return
+		}
+		// Only keep the last job of every tree, as scans can be large.
+		delete(srv.jobs, last.id)
+	}
+
+	// Start job. The tree is locked until the job is done.
+	j := newJob(tree, req.Mode)
+	if err := j.lock(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	srv.lastID++
+	sj := &serveJob{
+		job:    j,
+		id:     strconv.Itoa(srv.lastID),
+		state:  stateRunning,
+		notify: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	sj.onScan = sj.watchProgress
+	sj.hooks = srv.hooks
+	srv.jobs[sj.id] = sj
+	go srv.runJob(sj)
+
+	logf("Started %s of %s as job %s", req.Mode, tree.Name, sj.id)
+	w.Header().Set("Location", "/api/jobs/"+sj.id)
+	writeJSON(w, http.StatusAccepted, sj.view())
+}
+
+// lastJob returns the last job of the tree. The server must be locked.
+func (srv *server) lastJob(treeName string) *serveJob {
+	for _, sj := range srv.jobs {
+		if sj.tree.Name == treeName {
+			return sj
+		}
+	}
+	return nil
+}
+
+// runJob checks the tree and waits for approval if the job is an update with
+// changes that need review. Changes accepted by the policy are applied directly.
+func (srv *server) runJob(sj *serveJob) {
+	sj.startHooks()
+	err := sj.check()
+	sj.finish(err)
+
+	var report *checkser.Report
+	if sj.scan != nil {
+		report = sj.scan.Report()
+	}
+
+	state := stateDone
+	if err == nil && sj.canApply() {
+		if sj.needsReview() {
+			state = stateAwaitingApproval
+		} else {
+			sj.apply()
+			sj.result.complete(sj.scan)
+		}
+	}
+	sj.setState(state, func() {
+		sj.report = report
+	})
+	logf("Checked %s of %s in job %s: %s", sj.mode, sj.tree.Name, sj.id, formatResult(sj.result))
+
+	if state == stateDone {
+		srv.jobDone(sj)
+	}
+}
+
+// jobDone releases the tree and records metrics and runs hooks of the finished job.
+func (srv *server) jobDone(sj *serveJob) {
+	sj.release()
+
+	if err := srv.metrics.record(sj.result); err != nil {
+		logf("%s", err)
+	}
+
+	sj.lock.Lock()
+	report := sj.report
+	sj.lock.Unlock()
+	sj.finishHooks(report)
+}
+
+func (srv *server) handleJobs(w http.ResponseWriter, _ *http.Request) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	views := make([]*serveJobView, 0, len(srv.jobs))
+	for _, sj := range srv.jobs {
+		views = append(views, sj.view())
+	}
+	slices.SortFunc(views, func(a, b *serveJobView) int {
+		return strings.Compare(a.Tree, b.Tree)
+	})
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (srv *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	sj := srv.getJob(w, r)
+	if sj == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, sj.view())
+}
+
+// handleJobEvents streams the progress of the job as server-sent events,
+// until it awaits approval or is done.
+func (srv *server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	sj := srv.getJob(w, r)
+	if sj == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		// Get notified on the next progress before taking the view, so that no progress is missed.
+		notify := sj.progress()
+		view := sj.view()
+
+		event := "progress"
+		if view.State == stateAwaitingApproval || view.State == stateDone {
+			event = "end"
+		}
+		data, err := json.Marshal(view)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		flusher.Flush()
+		if event == "end" {
+			return
+		}
+
+		// Wait for progress, but limit the rate of events.
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+		select {
+		case <-time.After(minEventInterval):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleJobChanges lists the entries of the job, optionally filtered by change
+// (?change=added&change=removed) or by having errors (?errors=true).
+func (srv *server) handleJobChanges(w http.ResponseWriter, r *http.Request) {
+	sj := srv.getJob(w, r)
+	if sj == nil {
+		return
+	}
+
+	// Parse filter.
+	query := r.URL.Query()
+	changes := make([]string, 0, len(query["change"]))
+	for _, name := range query["change"] {
+		for _, name := range strings.Split(name, ",") {
+			if checkser.ParseChange(name) == checkser.Invalid {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("unknown change %q", name))
+				return
+			}
+			changes = append(changes, name)
+		}
+	}
+	onlyErrors, _ := strconv.ParseBool(query.Get("errors"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 1000
+	}
+
+	sj.lock.Lock()
+	report := sj.report
+	sj.lock.Unlock()
+	if report == nil {
+		writeError(w, http.StatusConflict, errors.New("job has not checked the tree yet"))
+		return
+	}
+
+	// Filter entries.
+	entries := make([]*checkser.ReportEntry, 0)
+	total := 0
+	for _, entry := range report.Entries {
+		switch {
+		case len(changes) > 0 && !slices.Contains(changes, entry.Change):
+			continue
+		case onlyErrors && len(entry.ErrMsgs) == 0:
+			continue
+		}
+		if total >= offset && len(entries) < limit {
+			entries = append(entries, entry)
+		}
+		total++
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Total   int                     `json:"total"`
+		Entries []*checkser.ReportEntry `json:"entries"`
+	}{
+		Total:   total,
+		Entries: entries,
+	})
+}
+
+// handleApprove applies the changes of an update awaiting approval.
+func (srv *server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	sj := srv.getJob(w, r)
+	if sj == nil {
+		return
+	}
+	if !sj.transition(stateAwaitingApproval, stateApplying) {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s, not %s", sj.getState(), stateAwaitingApproval))
+		return
+	}
+
+	sj.apply()
+	sj.result.complete(sj.scan)
+	sj.setState(stateDone, nil)
+	logf("Applied changes of job %s to %s: %s", sj.id, sj.tree.Name, formatResult(sj.result))
+	srv.jobDone(sj)
+
+	writeJSON(w, http.StatusOK, sj.view())
+}
+
+// handleDiscard discards the changes of an update awaiting approval.
+func (srv *server) handleDiscard(w http.ResponseWriter, r *http.Request) {
+	sj := srv.getJob(w, r)
+	if sj == nil {
+		return
+	}
+	if !sj.transition(stateAwaitingApproval, stateDone) {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s, not %s", sj.getState(), stateAwaitingApproval))
+		return
+	}
+	logf("Discarded changes of job %s to %s", sj.id, sj.tree.Name)
+	srv.jobDone(sj)
+
+	writeJSON(w, http.StatusOK, sj.view())
+}
+
+// getJob returns the job of the request or writes an error.
+func (srv *server) getJob(w http.ResponseWriter, r *http.Request) *serveJob {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	sj, ok := srv.jobs[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown job"))
+		return nil
+	}
+	return sj
+}
+
+// watchProgress forwards the live updates of the scan to the event streams.
+func (sj *serveJob) watchProgress(scan *checkser.Scan) {
+	sj.lock.Lock()
+	sj.liveScan = scan
+	sj.lock.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-scan.LiveUpdateSignal():
+				sj.broadcast()
+			case <-sj.done:
+				return
+			}
+		}
+	}()
+}
+
+// progress returns a channel that is closed on the next progress of the job.
+func (sj *serveJob) progress() <-chan struct{} {
+	sj.lock.Lock()
+	defer sj.lock.Unlock()
+	return sj.notify
+}
+
+func (sj *serveJob) broadcast() {
+	sj.lock.Lock()
+	defer sj.lock.Unlock()
+	close(sj.notify)
+	sj.notify = make(chan struct{})
+}
+
+func (sj *serveJob) getState() string {
+	sj.lock.Lock()
+	defer sj.lock.Unlock()
+	return sj.state
+}
+
+// setState sets the state, calls fn with the job locked and notifies the event streams.
+func (sj *serveJob) setState(state string, fn func()) {
+	sj.lock.Lock()
+	sj.setStateLocked(state)
+	if fn != nil {
+		fn()
+	}
+	sj.lock.Unlock()
+	sj.broadcast()
+}
+
+// setStateLocked sets the state. The job must be locked.
+func (sj *serveJob) setStateLocked(state string) {
+	sj.state = state
+	if state == stateDone {
+		close(sj.done)
+	}
+}
+
+// transition sets the state to the given state, if the job is in the expected state.
+func (sj *serveJob) transition(from, to string) bool {
+	sj.lock.Lock()
+	defer sj.lock.Unlock()
+
+	if sj.state != from {
+		return false
+	}
+	sj.setStateLocked(to)
+	return true
+}
+
+// view returns the JSON representation of the job.
+func (sj *serveJob) view() *serveJobView {
+	sj.lock.Lock()
+	defer sj.lock.Unlock()
+
+	view := &serveJobView{
+		ID:    sj.id,
+		Tree:  sj.tree.Name,
+		Mode:  sj.mode,
+		State: sj.state,
+	}
+	switch sj.state {
+	case stateRunning, stateApplying:
+		if sj.liveScan != nil {
+			view.Progress = sj.liveScan.Stats.Snapshot()
+		}
+	default:
+		// Copy the result, as it is modified when the changes are applied.
+		result := *sj.result
+		result.Errors = slices.Clone(result.Errors)
+		view.Result = &result
+	}
+	return view
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	signCmd = &cobra.Command{
+		Use:   "sign [dir]",
+		Short: "Sign the root checksum file with the key given with --sign-key.",
+		RunE:  sign,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	keygenCmd = &cobra.Command{
+		Use:   "keygen [secret key file]",
+		Short: "Generate an unencrypted minisign key pair for signing. The public key is written to [secret key file].pub.",
+		RunE:  keygen,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	flagSignKey     string
+	flagTrustedKeys string
+)
+
+func init() {
+	rootCmd.AddCommand(signCmd)
+	rootCmd.AddCommand(keygenCmd)
+
+	rootCmd.PersistentFlags().StringVar(&flagSignKey, "sign-key", "", "minisign or ssh secret key to sign the root checksum file with after writing")
+	rootCmd.PersistentFlags().StringVar(&flagTrustedKeys, "trusted-keys", "", "file with public keys trusted to sign the root checksum file; verification fails without a valid signature (default: ~/.config/checkser/trusted-keys, if it exists)")
+}
+
+func sign(_ *cobra.Command, args []string) error {
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	if flagSignKey == "" {
+		return errors.New("signing key must be set with --sign-key")
+	}
+
+	key, err := loadSigningKey(flagSignKey)
+	if err != nil {
+		return err
+	}
+	err = checkser.SignRootChecksumFile(dir, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed root checksum file, signature written to %s.\n", filepath.Join(dir, checkser.SignatureFilename))
+	return nil
+}
+
+func keygen(_ *cobra.Command, args []string) error {
+	secretKey, publicKey, err := checkser.GenerateMinisignKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	// Write key files, never overwriting existing keys.
+	err = writeNewFile(args[0], secretKey, 0o0600)
+	if err != nil {
+		return fmt.Errorf("failed to write secret key: %w", err)
+	}
+	err = writeNewFile(args[0]+".pub", publicKey, 0o0644)
+	if err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	fmt.Printf("Secret key written to %s, keep it outside of the checked tree.\n", args[0])
+	fmt.Printf("Public key written to %s, add it to your trusted keys file.\n", args[0]+".pub")
+	return nil
+}
+
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func loadSigningKey(keyFile string) (*checkser.SigningKey, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	key, err := checkser.ParseSigningKey(data, readKeyPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key: %w", err)
+	}
+	return key, nil
+}
+
+// defaultTrustedKeysFile returns the trusted keys file used if none is given,
+// eg. ~/.config/checkser/trusted-keys on Linux.
+func defaultTrustedKeysFile() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "checkser", "trusted-keys")
+}
+
+// loadTrustedKeysFlag loads the trusted keys set with --trusted-keys, or from
+// the default trusted keys file, if it exists.
+func loadTrustedKeysFlag() (*checkser.TrustedKeys, error) {
+	return loadTrustedKeysOrDefault(flagTrustedKeys)
+}
+
+// loadTrustedKeysOrDefault loads the given trusted keys file, or the default
+// trusted keys file, if none is given and it exists.
+func loadTrustedKeysOrDefault(trustedKeysFile string) (*checkser.TrustedKeys, error) {
+	if trustedKeysFile == "" {
+		trustedKeysFile = defaultTrustedKeysFile()
+		if trustedKeysFile == "" {
+			return nil, nil
+		}
+		if _, err := os.Stat(trustedKeysFile); errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+	}
+	return loadTrustedKeys(trustedKeysFile)
+}
+
+func loadTrustedKeys(trustedKeysFile string) (*checkser.TrustedKeys, error) {
+	data, err := os.ReadFile(trustedKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted keys: %w", err)
+	}
+	tk, err := checkser.LoadTrustedKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted keys: %w", err)
+	}
+	return tk, nil
+}
+
+// readKeyPassword returns the password for the signing key from the
+// environment or asks for it on the terminal.
+func readKeyPassword() ([]byte, error) {
+	if pw, ok := os.LookupEnv("CHECKSER_KEY_PASSWORD"); ok {
+		return []byte(pw), nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("%w: set CHECKSER_KEY_PASSWORD", checkser.ErrPasswordRequired)
+	}
+	fmt.Fprint(os.Stderr, "Password for signing key: ")
+	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr, "")
+	return pw, err
+}
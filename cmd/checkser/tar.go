@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tarCmd = &cobra.Command{
+		Use:   "tar",
+		Short: "Create and verify tar streams of trees with their checksum files.",
+	}
+	tarCreateCmd = &cobra.Command{
+		Use:   "create [dir] [file|-]",
+		Short: "Write a tree with its checksum files as tar stream, verifying and digesting all data while writing.",
+		RunE:  tarCreate,
+		Args:  cobra.ExactArgs(2),
+	}
+	tarVerifyCmd = &cobra.Command{
+		Use:   "verify [file|-]",
+		Short: "Verify all members of a tar stream against its embedded or external checksum files, without extracting.",
+		RunE:  tarVerify,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	flagTarManifests string
+)
+
+func init() {
+	rootCmd.AddCommand(tarCmd)
+	tarCmd.AddCommand(tarCreateCmd)
+	tarCmd.AddCommand(tarVerifyCmd)
+
+	tarVerifyCmd.Flags().StringVar(&flagTarManifests, "manifests", "", "dir to read the checksum files from instead of the tar stream, usually the source of the tar stream")
+	tarVerifyCmd.Flags().BoolVar(&flagIgnoreModTime, "ignore-mtime", false, "ignore modification times")
+}
+
+func tarCreate(_ *cobra.Command, args []string) error {
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Open destination.
+	var w io.Writer
+	if args[1] == "-" {
+		// Write human readable output to stderr instead.
+		output = os.Stderr
+		w = os.Stdout
+	} else {
+		f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o0644)
+		if err != nil {
+			return fmt.Errorf("failed to create tar file: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+		w = f
+	}
+
+	// Write tar stream.
+	fmt.Fprintf(output, "Writing %s as tar stream\n\n", dir)
+	result, err := checkser.WriteTar(w, dir, checkser.TarConfig{
+		DefaultHash: checkser.Hash(flagDefaultHash),
+		Key:         key,
+	})
+	if err == nil && args[1] != "-" {
+		err = w.(*os.File).Sync()
+	}
+	if err != nil {
+		if args[1] != "-" {
+			_ = os.Remove(args[1])
+		}
+		return fmt.Errorf("failed to write tar stream: %w", err)
+	}
+
+	fmt.Fprintln(output, "Differences:")
+	printTarEntries(result)
+	fmt.Fprintln(output, "")
+	fmt.Fprintf(output,
+		"Wrote %d files (%dB), %d dirs, %d other and %d checksum files.\n",
+		result.Files,
+		result.Bytes,
+		result.Dirs,
+		result.Specials,
+		result.ChecksumFiles,
+	)
+	fmt.Fprintf(output, "Stream digest: %s %s\n", result.Algorithm, result.Digest)
+
+	return tarResultErr(result, "tar stream written, but tree differs")
+}
+
+func tarVerify(_ *cobra.Command, args []string) error {
+	// Load keys.
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+	trustedKeys, err := loadTrustedKeysFlag()
+	if err != nil {
+		return err
+	}
+	var manifestDir string
+	if flagTarManifests != "" {
+		manifestDir, err = filepath.Abs(flagTarManifests)
+		if err != nil {
+			return fmt.Errorf("invalid manifest directory: %w", err)
+		}
+	}
+
+	// Open source.
+	var r io.Reader
+	if args[0] == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open tar file: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+		r = f
+	}
+
+	// Verify tar stream.
+	if manifestDir != "" {
+		fmt.Fprintf(output, "Verifying tar stream against the checksum files in %s\n\n", manifestDir)
+	} else {
+		fmt.Fprintln(output, "Verifying tar stream against its embedded checksum files")
+		fmt.Fprintln(output, "")
+	}
+	result, err := checkser.VerifyTar(r, checkser.TarConfig{
+		Key:           key,
+		TrustedKeys:   trustedKeys,
+		ManifestDir:   manifestDir,
+		IgnoreModTime: flagIgnoreModTime,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read tar stream: %w", err)
+	}
+
+	if result.Signed && trustedKeys == nil {
+		printUnverifiedSignature()
+		fmt.Fprintln(output, "")
+	}
+	fmt.Fprintln(output, "Differences:")
+	printTarEntries(result)
+	fmt.Fprintln(output, "")
+	fmt.Fprintf(output,
+		"Read %d files (%dB), %d dirs, %d other and %d checksum files.\n",
+		result.Files,
+		result.Bytes,
+		result.Dirs,
+		result.Specials,
+		result.ChecksumFiles,
+	)
+
+	if err := tarResultErr(result, "tar stream differs"); err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "Tar stream verified.")
+	return nil
+}
+
+// printTarEntries prints the errors and all entries that differ from the
+// checksum files.
+func printTarEntries(result *checkser.TarResult) {
+	for _, err := range result.Errors {
+		fmt.Fprintln(output, "Error: "+err.Error())
+	}
+
+	for _, entry := range result.Entries {
+		path := entry.Path
+		if entry.Type == checkser.ReportTypeDir {
+			path += "/"
+		}
+
+		switch entry.Change {
+		case checkser.Removed:
+			fmt.Fprintf(output, "missing    %s\n", path)
+		case checkser.Added:
+			fmt.Fprintf(output, "extra      %s\n", path)
+		case checkser.Changed:
+			fmt.Fprintf(output, "mismatch   %s\n", path)
+		case checkser.TimestampChanged:
+			fmt.Fprintf(output, "timestamp  %s\n", path)
+		default:
+			fmt.Fprintf(output, "failed     %s\n", path)
+		}
+		for _, msg := range entry.ErrMsgs {
+			fmt.Fprintln(output, "        Error: "+msg)
+		}
+	}
+
+	if len(result.Errors) == 0 && len(result.Entries) == 0 {
+		fmt.Fprintln(output, "None")
+	}
+}
+
+// tarResultErr returns an exit error if the tar stream differs from the
+// checksum files.
+func tarResultErr(result *checkser.TarResult, msg string) error {
+	var code int
+	for _, entry := range result.Entries {
+		switch entry.Change {
+		case checkser.Removed:
+			code |= exitRemoved
+		case checkser.Added:
+			code |= exitAdded
+		case checkser.Changed:
+			code |= exitChanged
+		case checkser.TimestampChanged:
+			code |= exitTimestamp
+		default:
+			code |= exitErrors
+		}
+	}
+	if len(result.Errors) > 0 {
+		code |= exitErrors
+	}
+	if code == 0 {
+		return nil
+	}
+
+	return &exitError{
+		code: code,
+		err: fmt.Errorf(
+			"%s: %d missing, %d extra, %d mismatched, %d timestamp changed, %d failed, %d errors",
+			msg,
+			result.Count(checkser.Removed),
+			result.Count(checkser.Added),
+			result.Count(checkser.Changed),
+			result.Count(checkser.TimestampChanged),
+			result.Count(checkser.Failed),
+			len(result.Errors),
+		),
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var treeHashCmd = &cobra.Command{
+	Use:   "tree-hash [dir]",
+	Short: "Print a content-only hash of the tree, calculated from its checksum files. Use --default-hash to select the hash algorithm.",
+	RunE:  treeHash,
+	Args:  cobra.ExactArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(treeHashCmd)
+}
+
+func treeHash(_ *cobra.Command, args []string) error {
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+
+	h := checkser.DefaultHash
+	if flagDefaultHash != "" {
+		h = checkser.Hash(flagDefaultHash)
+	}
+	sum, err := checkser.TreeHash(dir, h, key)
+	if err != nil {
+		return fmt.Errorf("failed to calculate tree hash: %w", err)
+	}
+	fmt.Println(sum)
+	return nil
+}
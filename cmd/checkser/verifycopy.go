@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyCopyCmd = &cobra.Command{
+		Use:   "verify-copy --manifests [src] [dst]",
+		Short: "Verify a copy against the checksum files of its source, without writing anything.",
+		RunE:  verifyCopy,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	flagManifests     string
+	flagIgnoreModTime bool
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCopyCmd)
+
+	verifyCopyCmd.Flags().StringVar(&flagManifests, "manifests", "", "dir to read the checksum files from, usually the source of the copy")
+	verifyCopyCmd.Flags().BoolVar(&flagIgnoreModTime, "ignore-mtime", false, "ignore modification times, eg. when they were not preserved by the copy")
+	_ = verifyCopyCmd.MarkFlagRequired("manifests")
+}
+
+func verifyCopy(_ *cobra.Command, args []string) error {
+	if err := checkReportFlag(); err != nil {
+		return err
+	}
+
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	manifestDir, err := filepath.Abs(flagManifests)
+	if err != nil {
+		return fmt.Errorf("invalid manifest directory: %w", err)
+	}
+	paths, err := scanPaths(dir)
+	if err != nil {
+		return err
+	}
+
+	// Load keys.
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+	trustedKeys, err := loadTrustedKeysFlag()
+	if err != nil {
+		return err
+	}
+
+	// Create new scan.
+	// All files are digested, as copies often do not preserve modification times.
+	scan, err := checkser.New(dir, checkser.ScanConfig{
+		DefaultHash:   checkser.Hash(flagDefaultHash),
+		DigestAll:     true,
+		Key:           key,
+		Paths:         paths,
+		ManifestDir:   manifestDir,
+		IgnoreModTime: flagIgnoreModTime,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Scan the copy with the checksum files of the source.
+	fmt.Fprintf(output, "Verifying %s against the checksum files in %s\n\n", dir, manifestDir)
+	fmt.Fprintln(output, "Finding files and directories...")
+	err = scan.Scan()
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	for _, line := range scan.FmtFindStatus() {
+		fmt.Fprintln(output, line)
+	}
+	fmt.Fprintln(output, "")
+
+	// Verify chain, signature and MAC of the checksum files.
+	_, rootErrs := verifyRoot(scan, manifestDir, key, trustedKeys)
+
+	// Digest all files.
+	fmt.Fprintln(output, "Digesting files...")
+	scan.DigestFiles()
+	for _, line := range scan.FmtDigestStatus() {
+		fmt.Fprintln(output, line)
+	}
+	fmt.Fprintln(output, "")
+
+	// Calculate and print differences.
+	scan.CalculateChangeStats()
+	if flagReport != "" {
+		defer writeReport(scan, scan.Report())
+	}
+	fmt.Fprintln(output, "Differences:")
+	printCopyDifferences(scan, dir)
+	fmt.Fprintln(output, "")
+
+	stats := scan.Stats
+	code := resultCode(stats.Snapshot(), len(rootErrs) > 0)
+	if code != 0 {
+		return &exitError{
+			code: code,
+			err: fmt.Errorf(
+				"copy differs: %d missing, %d extra, %d mismatched, %d timestamp changed, %d failed, %d errors",
+				stats.Total.Removed.Load(),
+				stats.Total.Added.Load(),
+				stats.Total.Changed.Load(),
+				stats.Total.TimestampChanged.Load(),
+				stats.Total.Failed.Load(),
+				stats.FindingErrors.Load()+stats.DigestErrors.Load()+uint64(len(rootErrs)),
+			),
+		}
+	}
+
+	fmt.Fprintf(output,
+		"Copy verified: all %d files, %d dirs and %d other match.\n",
+		stats.Files.NoChange.Load(),
+		stats.Dirs.NoChange.Load(),
+		stats.Special.NoChange.Load(),
+	)
+	return nil
+}
+
+// printCopyDifferences prints all entries that differ from the checksum files,
+// relative to the copy.
+func printCopyDifferences(scan *checkser.Scan, dir string) {
+	var printed bool
+	printEntry := func(path, suffix string, change checkser.Change, errMsgs []string) {
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			path = rel
+		}
+		path += suffix
+
+		switch change {
+		case checkser.Removed:
+			fmt.Fprintf(output, "missing    %s\n", path)
+		case checkser.Added:
+			fmt.Fprintf(output, "extra      %s\n", path)
+		case checkser.Changed:
+			fmt.Fprintf(output, "mismatch   %s\n", path)
+		case checkser.TimestampChanged:
+			fmt.Fprintf(output, "timestamp  %s\n", path)
+		case checkser.Failed:
+			fmt.Fprintf(output, "failed     %s\n", path)
+		default:
+			if len(errMsgs) == 0 {
+				return
+			}
+			fmt.Fprintf(output, "error      %s\n", path)
+		}
+		for _, msg := range errMsgs {
+			fmt.Fprintln(output, "        Error: "+msg)
+		}
+		printed = true
+	}
+
+	scan.Iterate(
+		func(file *checkser.File) {
+			printEntry(file.Path, "", file.Change, file.ErrMsgs)
+		},
+		func(dir *checkser.Directory) {
+			printEntry(dir.Path, "/", dir.Change, dir.ErrMsgs)
+		},
+		func(special *checkser.Special) {
+			printEntry(special.Path, "", special.Change, special.ErrMsgs)
+		},
+	)
+	if !printed {
+		fmt.Fprintln(output, "None")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var verifyFileCmd = &cobra.Command{
+	Use:   "verify-file [file]...",
+	Short: "Verify single files against the checksum files of their dirs, without scanning anything else.",
+	RunE:  verifyFile,
+	Args:  cobra.MinimumNArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(verifyFileCmd)
+}
+
+func verifyFile(_ *cobra.Command, args []string) error {
+	// Load keys.
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+	trustedKeys, err := loadTrustedKeysFlag()
+	if err != nil {
+		return err
+	}
+
+	// Verify files.
+	var failed, timestamps, code int
+	verifiedRoots := make(map[string]error)
+	for _, path := range args {
+		v, err := checkser.VerifyFile(path, key, flagChain)
+		if err == nil && v.Chain != nil {
+			// Verify tree root once.
+			rootErr, ok := verifiedRoots[v.Chain.Root]
+			if !ok {
+				rootErr = verifyTreeRoot(v.Chain.Root, key, trustedKeys)
+				verifiedRoots[v.Chain.Root] = rootErr
+			}
+			err = rootErr
+		}
+		fileCode := verifyFileCode(v, err)
+		code |= fileCode
+
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(output, "%s: FAILED: %s\n", path, err)
+		case fileCode == exitTimestamp:
+			timestamps++
+			fmt.Fprintf(output, "%s: OK, but modification time changed\n", path)
+		default:
+			fmt.Fprintf(output, "%s: OK\n", path)
+		}
+	}
+
+	switch {
+	case failed > 0:
+		return &exitError{
+			code: code,
+			err:  fmt.Errorf("%d of %d files failed verification", failed, len(args)),
+		}
+	case code != 0:
+		return &exitError{
+			code: code,
+			err:  fmt.Errorf("modification time of %d of %d files changed", timestamps, len(args)),
+		}
+	}
+	return nil
+}
+
+// verifyFileCode returns the exit code bitmask for the verification of a file.
+func verifyFileCode(v *checkser.FileVerification, err error) int {
+	if v == nil {
+		return exitErrors
+	}
+	switch v.Change {
+	case checkser.NoChange:
+		if err != nil {
+			// Chain, signature or MAC failed.
+			return exitErrors
+		}
+		return 0
+	case checkser.TimestampChanged:
+		if err != nil {
+			return exitErrors | exitTimestamp
+		}
+		return exitTimestamp
+	case checkser.Changed:
+		return exitChanged
+	case checkser.Added:
+		return exitAdded
+	case checkser.Removed:
+		return exitRemoved
+	case checkser.Failed:
+		return exitDigestErrors
+	default:
+		return exitErrors
+	}
+}
+
+// verifyTreeRoot verifies the signature and MAC of the root checksum file.
+func verifyTreeRoot(rootDir string, key []byte, trustedKeys *checkser.TrustedKeys) error {
+	switch {
+	case trustedKeys != nil:
+		_, err := checkser.VerifyRootChecksumFile(rootDir, trustedKeys)
+		if err != nil {
+			return fmt.Errorf("root checksum file signature: %w", err)
+		}
+	case fileExists(filepath.Join(rootDir, checkser.SignatureFilename)):
+		printUnverifiedSignature()
+	}
+
+	switch {
+	case key != nil:
+		return checkser.VerifyRootMACFile(rootDir, key)
+	case fileExists(filepath.Join(rootDir, checkser.RootMACFilename)):
+		return fmt.Errorf("%w: tree is in keyed mode", checkser.ErrKeyRequired)
+	}
+	return nil
+}
@@ -24,7 +24,7 @@ func viewDetails(scan *checkser.Scan, filter checkser.Change) {
 	// Create tmp file for less.
 	tmpFile, err := os.CreateTemp("", "checkser-change-view-")
 	if err != nil {
-		fmt.Printf("failed to create tmp file for viewing with less (err=%s), printing to stdout instead\n", err)
+		fmt.Fprintf(output, "failed to create tmp file for viewing with less (err=%s), printing to stdout instead\n", err)
 		printViewToStdout(scan, v)
 		return
 	} else {
@@ -41,11 +41,11 @@ func viewDetails(scan *checkser.Scan, filter checkser.Change) {
 	// Execute less for viewing.
 	cmd := exec.Command(lessBin, v.filepath)
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = output
 	cmd.Stderr = os.Stderr
 	err = cmd.Run()
 	if err != nil {
-		fmt.Printf("less exited with error: %s\n", err)
+		fmt.Fprintf(output, "less exited with error: %s\n", err)
 	}
 
 	// Delete file after viewing.
@@ -54,7 +54,7 @@ func viewDetails(scan *checkser.Scan, filter checkser.Change) {
 
 func printViewToStdout(scan *checkser.Scan, v *viewer) {
 	// Set writer.
-	v.writer = os.Stdout
+	v.writer = output
 
 	// Print.
 	fmt.Fprintln(v.writer, "Details:")
@@ -69,12 +69,16 @@ type viewer struct {
 	filter checkser.Change
 }
 
-func (v *viewer) view(change checkser.Change, errMsgs int) bool {
+func (v *viewer) view(change checkser.Change, action checkser.Action, errMsgs int) bool {
 	switch {
 	case v.filter == checkser.ErrMsgs:
 		// Filter for err msgs.
 		return errMsgs > 0
 
+	case action == checkser.ActionIgnore:
+		// Ignored by policy.
+		return false
+
 	case v.filter == checkser.Invalid:
 		// Filter is disabled.
 		return true
@@ -90,7 +94,7 @@ func (v *viewer) view(change checkser.Change, errMsgs int) bool {
 }
 
 func (v *viewer) formatFile(file *checkser.File) {
-	if !v.view(file.Change, len(file.ErrMsgs)) {
+	if !v.view(file.Change, file.Action, len(file.ErrMsgs)) {
 		return
 	}
 
@@ -105,6 +109,15 @@ func (v *viewer) formatFile(file *checkser.File) {
 		fmt.Fprintf(v.writer, "%s %s (%s => %s)\n", file.Change, file.Path, file.Modified, file.Changed.Modified)
 	}
 
+	v.printAction(file.Action)
+
+	// Print changed members of archives.
+	if file.Change == checkser.Changed {
+		for _, member := range file.MemberChanges() {
+			fmt.Fprintf(v.writer, "        Member %s %s\n", member.Change, member.Name)
+		}
+	}
+
 	// Print any error messages.
 	for _, msg := range file.ErrMsgs {
 		fmt.Fprintln(v.writer, "        Error: "+msg)
@@ -112,12 +125,14 @@ func (v *viewer) formatFile(file *checkser.File) {
 }
 
 func (v *viewer) formatDir(dir *checkser.Directory) {
-	if !v.view(dir.Change, len(dir.ErrMsgs)) {
+	if !v.view(dir.Change, dir.Action, len(dir.ErrMsgs)) {
 		return
 	}
 
 	fmt.Fprintf(v.writer, "%s %s/\n", dir.Change, dir.Path)
 
+	v.printAction(dir.Action)
+
 	// Print any error messages.
 	for _, msg := range dir.ErrMsgs {
 		fmt.Fprintln(v.writer, "        Error: "+msg)
@@ -125,7 +140,7 @@ func (v *viewer) formatDir(dir *checkser.Directory) {
 }
 
 func (v *viewer) formatSpecial(special *checkser.Special) {
-	if !v.view(special.Change, len(special.ErrMsgs)) {
+	if !v.view(special.Change, special.Action, len(special.ErrMsgs)) {
 		return
 	}
 
@@ -140,12 +155,21 @@ func (v *viewer) formatSpecial(special *checkser.Special) {
 		fmt.Fprintf(v.writer, "%s %s (%s => %s)\n", special.Change, special.Path, special.Modified, special.Changed.Modified)
 	}
 
+	v.printAction(special.Action)
+
 	// Print any error messages.
 	for _, msg := range special.ErrMsgs {
 		fmt.Fprintln(v.writer, "        Error: "+msg)
 	}
 }
 
+// printAction prints a note for changes violating the policy or append-only mode.
+func (v *viewer) printAction(action checkser.Action) {
+	if action == checkser.ActionFail {
+		fmt.Fprintln(v.writer, "        Violation, will not be recorded")
+	}
+}
+
 var (
 	lessBin           string
 	lessBinSearchOnce sync.Once
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dhaavi/checkser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchCmd = &cobra.Command{
+		Use:   "watch [dir]",
+		Short: "Watch the dir for changes and update the checksums of changed files continuously. Linux only.",
+		RunE:  watch,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	flagDebounce time.Duration
+	flagMaxDelay time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().DurationVar(&flagDebounce, "debounce", checkser.DefaultWatchDebounce, "time to wait for further changes before updating")
+	watchCmd.Flags().DurationVar(&flagMaxDelay, "max-delay", 0, "update at the latest after this time, even if changes keep coming in (default: 10 times the debounce)")
+	watchCmd.Flags().StringVar(&flagMetricsAddr, "metrics-addr", "", "serve Prometheus metrics on /metrics at this address, eg. localhost:9190")
+}
+
+func watch(_ *cobra.Command, args []string) error {
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+
+	// Load keys.
+	key, err := loadKey(flagKeyFile)
+	if err != nil {
+		return err
+	}
+	var signingKey *checkser.SigningKey
+	if flagSignKey != "" {
+		signingKey, err = loadSigningKey(flagSignKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Update from the tree root, if dir is part of a larger tree.
+	treeRoot := dir
+	if flagChain {
+		treeRoot, err = checkser.FindTreeRoot(dir)
+		if err != nil {
+			return fmt.Errorf("failed to find tree root: %w", err)
+		}
+	}
+
+	// Load policy, which is relative to the tree root.
+	policy, err := loadPolicy(flagPolicy, treeRoot)
+	if err != nil {
+		return err
+	}
+
+	// Export metrics of updates.
+	m, err := newMetrics(flagMetricsFile)
+	if err != nil {
+		return err
+	}
+	if flagMetricsAddr != "" {
+		srv := m.serve(flagMetricsAddr)
+		defer srv.Close() //nolint:errcheck
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	fmt.Printf("Watching %s for changes...\n", dir)
+	err = checkser.Watch(ctx, dir, checkser.WatchConfig{
+		ScanConfig: checkser.ScanConfig{
+			DefaultHash: checkser.Hash(flagDefaultHash),
+			Format:      checkser.Format(flagFormat),
+			Key:         key,
+			Canonical:   flagCanonical,
+			AppendOnly:  flagAppendOnly,
+			Archives:    flagArchives,
+			Policy:      policy,
+		},
+		TreeRoot: treeRoot,
+		Debounce: flagDebounce,
+		MaxDelay: flagMaxDelay,
+		OnUpdate: func(scan *checkser.Scan, took time.Duration, err error) {
+			printWatchUpdate(scan, err)
+			result := &jobResult{
+				Root:  dir,
+				Mode:  modeWatch,
+				Start: time.Now().Add(-took),
+			}
+			if err != nil {
+				result.Errors = append(result.Errors, err.Error())
+			}
+
+			// Sign the new root checksum file.
+			switch {
+			case signingKey == nil || err != nil || scan.Stats.WriteDone.Load() == 0:
+			case scan.Stats.WriteErrors.Load() > 0:
+				// Never sign a root whose chain of checksum files is incomplete.
+				msg := fmt.Sprintf("not signing root checksum file, as %d checksum files failed to write", scan.Stats.WriteErrors.Load())
+				logf("%s", msg)
+				result.Errors = append(result.Errors, msg)
+			default:
+				if err := checkser.SignRootChecksumFile(treeRoot, signingKey); err != nil {
+					logf("Failed to sign root checksum file: %s", err)
+					result.Errors = append(result.Errors, err.Error())
+				}
+			}
+
+			result.complete(scan)
+			if err := m.record(result); err != nil {
+				logf("%s", err)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch: %w", err)
+	}
+	return nil
+}
+
+func printWatchUpdate(scan *checkser.Scan, err error) {
+	now := time.Now().Format(time.DateTime)
+	if err != nil {
+		fmt.Printf("%s Update failed: %s\n", now, err)
+		return
+	}
+
+	stats := scan.Stats
+	fmt.Printf(
+		"%s Updated: %d added, %d changed, %d removed, %d timestamp changed, %d failed. Written %d checksum files.\n",
+		now,
+		stats.Total.Added.Load(),
+		stats.Total.Changed.Load(),
+		stats.Total.Removed.Load(),
+		stats.Total.TimestampChanged.Load(),
+		stats.Total.Failed.Load(),
+		stats.WriteDone.Load(),
+	)
+
+	// Print errors.
+	scan.Iterate(
+		func(file *checkser.File) {
+			for _, msg := range file.ErrMsgs {
+				fmt.Printf("%s Error: %s: %s\n", now, file.Path, msg)
+			}
+		},
+		func(dir *checkser.Directory) {
+			for _, msg := range dir.ErrMsgs {
+				fmt.Printf("%s Error: %s: %s\n", now, dir.Path, msg)
+			}
+		},
+		func(special *checkser.Special) {
+			for _, msg := range special.ErrMsgs {
+				fmt.Printf("%s Error: %s: %s\n", now, special.Path, msg)
+			}
+		},
+	)
+	for _, line := range scan.WriteErrors() {
+		fmt.Printf("%s Error: %s\n", now, line)
+	}
+}
@@ -0,0 +1,163 @@
+package checkser
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrIntegrityViolated is returned when a checksum file does not match the digest recorded in its parent.
+var ErrIntegrityViolated = errors.New("dir integrity violated")
+
+// ConvertChecksumFiles converts all checksum files of the tree at dir to the
+// given format. The digests of converted checksum files are updated in their
+// parents, so that the chain of checksum files stays consistent.
+// Conversion is aborted if the chain of checksum files is not intact, as it
+// would otherwise be re-signed by the conversion.
+// Keyed trees need the key, which is also used to update the root MAC.
+// Signatures are not updated: if anything was converted, the root checksum
+// file must be signed again.
+// Binary checksum files with unknown integer keys, written by a newer version,
+// are not converted to a text format, as the keys would be lost.
+func ConvertChecksumFiles(dir string, format Format, key []byte) (converted int, err error) {
+	if !format.IsValid() {
+		return 0, ErrUnsupportedFormat
+	}
+
+	// Check root MAC before converting.
+	switch {
+	case key == nil:
+		if _, err := os.Stat(filepath.Join(dir, RootMACFilename)); err == nil {
+			return 0, fmt.Errorf("%w: tree is in keyed mode", ErrKeyRequired)
+		}
+	default:
+		data, err := os.ReadFile(filepath.Join(dir, ChecksumFilename))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read root checksum file: %w", err)
+		}
+		if err := verifyRootMAC(dir, data, key); err != nil {
+			return 0, err
+		}
+	}
+
+	// Check that no data is lost, before anything is converted.
+	if !format.isBinary() {
+		if err := checkTextConvertible(dir); err != nil {
+			return 0, err
+		}
+	}
+
+	alg, sum, changed, err := convertChecksumFile(dir, format, key, nil, &converted)
+	if err != nil {
+		return converted, err
+	}
+
+	// Update root MAC.
+	if key != nil && changed {
+		err = writeRootMAC(dir, alg, sum)
+		if err != nil {
+			return converted, fmt.Errorf("failed to write root MAC: %w", err)
+		}
+	}
+	return converted, nil
+}
+
+// checkTextConvertible checks that the checksum files of the tree at dir can
+// be converted to a text format without losing unknown integer keys.
+func checkTextConvertible(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, ChecksumFilename))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to read checksum file in %s: %w", dir, err)
+	}
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		return fmt.Errorf("failed to load checksum file in %s: %w", dir, err)
+	}
+	if cs.hasBinUnknown() {
+		return fmt.Errorf("%w: %s", ErrUnknownBinaryKeys, dir)
+	}
+	for _, subDir := range cs.Directories {
+		if err := checkTextConvertible(filepath.Join(dir, subDir.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertChecksumFile(path string, format Format, key []byte, pathDir *Directory, converted *int) (alg, sum string, changed bool, err error) {
+	// Load checksum file.
+	data, err := os.ReadFile(filepath.Join(path, ChecksumFilename))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && pathDir != nil {
+			// Dir was not yet added to the tree.
+			return "", "", false, nil
+		}
+		return "", "", false, fmt.Errorf("failed to read checksum file in %s: %w", path, err)
+	}
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to load checksum file in %s: %w", path, err)
+	}
+
+	// Check if the checksum file matches its parent.
+	alg = string(DefaultHash)
+	if key != nil {
+		alg = string(DefaultHash.Keyed())
+	}
+	if pathDir != nil && pathDir.Algorithm != "" {
+		alg = pathDir.Algorithm
+		dirChecksum, err := Hash(alg).DigestWithKey(data, key)
+		if err != nil {
+			return "", "", false, fmt.Errorf("failed to digest checksum file in %s: %w", path, err)
+		}
+		if dirChecksum != pathDir.Digest {
+			return "", "", false, fmt.Errorf("%w: %s", ErrIntegrityViolated, path)
+		}
+	}
+
+	// Convert sub dirs first.
+	for _, dir := range cs.Directories {
+		dirAlg, dirSum, dirChanged, err := convertChecksumFile(filepath.Join(path, dir.Name), format, key, dir, converted)
+		if err != nil {
+			return "", "", false, err
+		}
+		if dirChanged {
+			dir.Algorithm = dirAlg
+			dir.Digest = dirSum
+			changed = true
+		}
+	}
+
+	// Return existing digest if nothing changed.
+	if !changed && cs.Format() == format {
+		if pathDir != nil && pathDir.Algorithm != "" {
+			return pathDir.Algorithm, pathDir.Digest, false, nil
+		}
+		sum, err := Hash(alg).DigestWithKey(data, key)
+		return alg, sum, false, err
+	}
+
+	// Write converted checksum file.
+	cs.SetFormat(format)
+	packed, err := PackChecksums(cs)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to serialize checksum file in %s: %w", path, err)
+	}
+	err = os.WriteFile(filepath.Join(path, ChecksumFilename), packed, 0o0755)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to write checksum file in %s: %w", path, err)
+	}
+	*converted++
+
+	// Digest for parent checksums.
+	sum, err = Hash(alg).DigestWithKey(packed, key)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to digest checksum file in %s: %w", path, err)
+	}
+	return alg, sum, true, nil
+}
@@ -0,0 +1,77 @@
+package checkser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertKeepsChain(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "sub\n")
+	updateTree(t, dir, ScanConfig{})
+
+	for _, format := range []Format{FormatCBORZstd, FormatJSON, FormatYAML} {
+		converted, err := ConvertChecksumFiles(dir, format, nil)
+		switch {
+		case err != nil:
+			t.Fatalf("failed to convert to %s: %s", format, err)
+		case converted != 2:
+			t.Fatalf("converted %d checksum files to %s, expected 2", converted, format)
+		}
+		assertUnchanged(t, scanTree(t, dir, ScanConfig{DigestAll: true}))
+	}
+
+	// Converting to the same format does nothing.
+	converted, err := ConvertChecksumFiles(dir, FormatYAML, nil)
+	if err != nil || converted != 0 {
+		t.Fatalf("converted %d checksum files with error %v, expected none", converted, err)
+	}
+}
+
+func TestConvertKeyed(t *testing.T) {
+	t.Parallel()
+
+	// Without sub dirs, only the root MAC needs the key.
+	key := []byte("0123456789abcdef")
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	updateTree(t, dir, ScanConfig{Key: key})
+
+	// Keyed trees cannot be converted without the key, as the MAC would be
+	// invalidated.
+	converted, err := ConvertChecksumFiles(dir, FormatJSON, nil)
+	switch {
+	case !errors.Is(err, ErrKeyRequired):
+		t.Fatalf("unexpected error without key: %v", err)
+	case converted != 0:
+		t.Fatalf("converted %d checksum files without key", converted)
+	}
+
+	// Conversion with the key updates the MAC.
+	if _, err := ConvertChecksumFiles(dir, FormatJSON, key); err != nil {
+		t.Fatalf("failed to convert: %s", err)
+	}
+	scan := scanTree(t, dir, ScanConfig{Key: key, DigestAll: true})
+	if err := scan.VerifyRootMAC(); err != nil {
+		t.Fatalf("root MAC invalid after conversion: %s", err)
+	}
+	assertUnchanged(t, scan)
+
+	// A wrong key is refused.
+	if _, err := ConvertChecksumFiles(dir, FormatYAML, []byte("fedcba9876543210")); !errors.Is(err, ErrInvalidRootMAC) {
+		t.Fatalf("unexpected error with wrong key: %v", err)
+	}
+
+	// A missing MAC is refused.
+	if err := os.Remove(filepath.Join(dir, RootMACFilename)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertChecksumFiles(dir, FormatYAML, key); !errors.Is(err, ErrMissingRootMAC) {
+		t.Fatalf("unexpected error with missing MAC: %v", err)
+	}
+}
@@ -0,0 +1,495 @@
+package checkser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Suffixes of files in the destination of a copy.
+const (
+	// CopyTempSuffix is used for files while they are copied and verified.
+	CopyTempSuffix = ".checkser-tmp"
+	// CopyFailedSuffix is used for files that failed verification.
+	CopyFailedSuffix = ".checkser-failed"
+)
+
+// Errors.
+var (
+	ErrDestinationNotEmpty = errors.New("destination is not empty")
+	ErrSourceMismatch      = errors.New("source does not match its checksum file")
+	ErrDestinationMismatch = errors.New("destination does not match the copied data")
+	ErrUnsupportedType     = errors.New("unsupported file type")
+	ErrDestinationInSource = errors.New("destination is inside the source")
+)
+
+// CopyConfig configures copying a tree.
+type CopyConfig struct {
+	// DefaultHash sets the hash for files that are not recorded in the source.
+	DefaultHash Hash
+
+	// Format sets the format of the written checksum files.
+	// Defaults to the format of the root checksum file of the source.
+	Format Format
+
+	// Key is needed for keyed trees.
+	Key []byte
+
+	// ReadRateLimit limits the rate at which source files are read, in bytes
+	// per second. Zero means no limit.
+	ReadRateLimit int64
+}
+
+// CopyResult holds the result of a copy.
+type CopyResult struct {
+	Files    uint64
+	Dirs     uint64
+	Specials uint64
+	Bytes    uint64
+
+	// Unverified holds the files that are not recorded in the checksum files
+	// of the source. Their copy is verified, but not their source.
+	Unverified []string
+	// Failures holds all entries that could not be copied or verified.
+	Failures []*CopyFailure
+
+	// WrittenChecksums is the number of checksum files written to the destination.
+	WrittenChecksums uint64
+}
+
+// CopyFailure describes an entry that could not be copied or verified.
+type CopyFailure struct {
+	Path string
+	Err  error
+}
+
+func (f *CopyFailure) Error() string {
+	return f.Path + ": " + f.Err.Error()
+}
+
+// CopyTree copies the tree at src to dst and verifies it on the fly: all files
+// are digested while copying and compared with the checksum files of src.
+// Then, the files are read again from dst to confirm what was written. On
+// Linux, their cached pages are dropped first, so that they are read from the
+// disk; elsewhere, the read may be served from the cache.
+// Files are copied to a temporary name and only get their final name once
+// verified. Files that fail verification are kept with the CopyFailedSuffix.
+// Finally, checksum files are written to dst. They only include verified
+// entries, so that failed files are reported when verifying dst.
+//
+// The destination must not exist or be an empty dir, and must not be inside
+// the source. The returned error is only set if the copy could not start, see
+// the result for failures.
+func CopyTree(src, dst string, cfg CopyConfig) (*CopyResult, error) {
+	// Check config.
+	switch {
+	case string(cfg.DefaultHash) == "":
+		cfg.DefaultHash = DefaultHash
+	case !cfg.DefaultHash.IsValid():
+		return nil, ErrInvalidHashAlg
+	}
+	if cfg.Key != nil {
+		cfg.DefaultHash = cfg.DefaultHash.Keyed()
+	}
+	if cfg.Format != "" && !cfg.Format.IsValid() {
+		return nil, ErrUnsupportedFormat
+	}
+
+	// Check source.
+	if cfg.Key == nil {
+		if _, err := os.Stat(filepath.Join(src, RootMACFilename)); err == nil {
+			return nil, fmt.Errorf("%w: tree is in keyed mode", ErrKeyRequired)
+		}
+	} else {
+		data, err := readChecksumFile(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root checksum file: %w", err)
+		}
+		if err := verifyRootMAC(src, data, cfg.Key); err != nil {
+			return nil, err
+		}
+	}
+	srcSum, err := loadVerifiedChecksums(src, nil, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check destination.
+	inSource, err := isInside(src, dst)
+	switch {
+	case err != nil:
+		return nil, err
+	case inSource:
+		return nil, fmt.Errorf("%w: %s", ErrDestinationInSource, dst)
+	}
+	entries, err := os.ReadDir(dst)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case len(entries) > 0:
+		return nil, fmt.Errorf("%w: %s", ErrDestinationNotEmpty, dst)
+	}
+
+	c := &copier{
+		cfg:       cfg,
+		result:    &CopyResult{},
+		readLimit: newRateLimiter(cfg.ReadRateLimit),
+		updatedAt: time.Now().Round(time.Second).UTC(),
+		format:    cfg.Format,
+	}
+	if c.format == "" {
+		c.format = srcSum.Format()
+	}
+	c.updatedBy, err = os.Hostname()
+	if err != nil {
+		c.updatedBy = "unknown"
+	}
+
+	alg, sum, err := c.dir("", src, dst, srcSum)
+	if err != nil {
+		return c.result, err
+	}
+
+	// Protect root checksum file in keyed mode.
+	if cfg.Key != nil && sum != "" {
+		if err := writeRootMAC(dst, alg, sum); err != nil {
+			c.fail("", fmt.Errorf("write root MAC: %w", err))
+		}
+	}
+
+	return c.result, nil
+}
+
+type copier struct {
+	cfg       CopyConfig
+	result    *CopyResult
+	readLimit *rateLimiter
+
+	format    Format
+	updatedAt time.Time
+	updatedBy string
+
+	// dstInfo is the root dir of the destination, which is never copied.
+	dstInfo fs.FileInfo
+}
+
+func (c *copier) fail(rel string, err error) {
+	c.result.Failures = append(c.result.Failures, &CopyFailure{Path: rel, Err: err})
+}
+
+// dir copies the dir and returns the digest of its new checksum file.
+// srcSum holds the checksums of the source dir and may be nil.
+func (c *copier) dir(rel, src, dst string, srcSum *Checksums) (alg, sum string, err error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", "", err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dst, info.Mode().Perm()|0o0700); err != nil {
+		return "", "", err
+	}
+	if rel == "" {
+		c.dstInfo, err = os.Stat(dst)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	dstSum := &Checksums{
+		Version:   SchemaVersion,
+		UpdatedAt: c.updatedAt,
+		UpdatedBy: c.updatedBy,
+		Generator: Generator,
+		format:    c.format,
+	}
+	if srcSum != nil {
+		dstSum.AppendOnly = srcSum.AppendOnly
+	} else {
+		srcSum = &Checksums{}
+	}
+
+	for _, entry := range entries {
+		name := norm.NFC.String(entry.Name())
+		entryRel := path.Join(rel, name)
+		entrySrc := filepath.Join(src, entry.Name())
+		entryDst := filepath.Join(dst, entry.Name())
+
+		switch {
+		case name == ChecksumFilename:
+			// Checksum files are written for the destination.
+
+		case rel == "" && (name == SignatureFilename || name == RootMACFilename):
+			// The signature does not match the new root checksum file and
+			// the MAC is written for it.
+
+		case entry.IsDir() && c.isDestination(entry):
+			// Never copy the destination into itself.
+
+		case entry.IsDir():
+			// Load and verify checksums of sub dir.
+			var subSum *Checksums
+			srcDir := srcSum.GetDir(name)
+			if srcDir != nil {
+				subSum, err = loadVerifiedChecksums(entrySrc, srcDir, c.cfg.Key)
+				if err != nil {
+					c.fail(entryRel, err)
+				}
+			}
+
+			dirAlg, dirSum, err := c.dir(entryRel, entrySrc, entryDst, subSum)
+			if err != nil {
+				c.fail(entryRel, err)
+				continue
+			}
+			c.result.Dirs++
+			dstSum.AddDir(&Directory{
+				Name:      name,
+				Algorithm: dirAlg,
+				Digest:    dirSum,
+			})
+
+		case entry.Type().IsRegular():
+			srcFile := srcSum.GetFile(name)
+			file, err := c.file(entrySrc, entryDst, srcFile)
+			if err != nil {
+				c.fail(entryRel, err)
+				continue
+			}
+			if srcFile == nil {
+				c.result.Unverified = append(c.result.Unverified, entryRel)
+			}
+			c.result.Files++
+			c.result.Bytes += uint64(file.Size) //nolint:gosec // Sizes are never negative.
+			if srcFile != nil && srcFile.Algorithm == file.Algorithm {
+				// Keep index of archive, the data is verified.
+				file.Members = srcFile.Members
+			}
+			file.Name = name
+			dstSum.AddFile(file)
+
+		case entry.Type()&fs.ModeSymlink != 0:
+			special, err := copySymlink(entrySrc, entryDst)
+			if err != nil {
+				c.fail(entryRel, err)
+				continue
+			}
+			c.result.Specials++
+			special.Name = name
+			dstSum.AddSpecialFile(special)
+
+		default:
+			c.fail(entryRel, fmt.Errorf("%w: %s", ErrUnsupportedType, entry.Type()))
+		}
+	}
+
+	// Report entries recorded in the source that are missing.
+	for _, file := range srcSum.Files {
+		if dstSum.GetFile(file.Name) == nil && !fileExists(filepath.Join(src, file.Name)) {
+			c.fail(path.Join(rel, file.Name), fmt.Errorf("%w: file is missing", ErrSourceMismatch))
+		}
+	}
+	for _, dir := range srcSum.Directories {
+		if dstSum.GetDir(dir.Name) == nil && !fileExists(filepath.Join(src, dir.Name)) {
+			c.fail(path.Join(rel, dir.Name), fmt.Errorf("%w: dir is missing", ErrSourceMismatch))
+		}
+	}
+
+	// Write checksum file.
+	dstSum.Sort()
+	packed, err := PackChecksums(dstSum)
+	if err != nil {
+		return "", "", err
+	}
+	err = os.WriteFile(filepath.Join(dst, ChecksumFilename), packed, 0o0755)
+	if err != nil {
+		return "", "", err
+	}
+	c.result.WrittenChecksums++
+
+	// Keep modification time of dir.
+	_ = os.Chtimes(dst, info.ModTime(), info.ModTime())
+
+	sum, err = c.cfg.DefaultHash.DigestWithKey(packed, c.cfg.Key)
+	if err != nil {
+		return "", "", err
+	}
+	return string(c.cfg.DefaultHash), sum, nil
+}
+
+// file copies a file and verifies it. It returns the entry for the checksum file.
+// srcFile holds the recorded state of the source file and may be nil.
+func (c *copier) file(src, dst string, srcFile *File) (*File, error) {
+	file := &File{}
+
+	// Use the recorded hash of the source, to compare the digests.
+	h := c.cfg.DefaultHash
+	if srcFile != nil && Hash(srcFile.Algorithm).IsValid() {
+		h = Hash(srcFile.Algorithm)
+	}
+	if c.cfg.Key != nil && !h.IsKeyed() {
+		// Recorded digest is not keyed, it cannot be compared.
+		h = h.Keyed()
+		srcFile = nil
+	}
+	file.Algorithm = string(h)
+
+	in, err := os.Open(src)
+	if err != nil {
+		return file, err
+	}
+	defer in.Close() //nolint:errcheck
+	info, err := in.Stat()
+	if err != nil {
+		return file, err
+	}
+	file.Size = info.Size()
+	file.Modified = info.ModTime().UTC()
+
+	// Copy to temporary file while digesting.
+	tmpName := dst + CopyTempSuffix
+	out, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm()|0o0600)
+	if err != nil {
+		return file, err
+	}
+	var r io.Reader = in
+	if c.readLimit != nil {
+		r = &rateLimitedReader{r: r, rl: c.readLimit}
+	}
+	file.Digest, err = h.DigestReaderWithKey(io.TeeReader(r, out), c.cfg.Key)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err == nil {
+		// Evict the written data from the cache, so that it is read back
+		// from the disk.
+		err = dropCache(out)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return file, err
+	}
+
+	// Compare with the recorded digest of the source.
+	if srcFile != nil && srcFile.Algorithm == file.Algorithm && srcFile.Digest != file.Digest {
+		return file, markFailed(tmpName, dst, ErrSourceMismatch)
+	}
+
+	// Read back destination, from the disk where the cache was dropped.
+	sum, err := h.DigestFileWithKey(tmpName, c.cfg.Key)
+	if err != nil {
+		return file, markFailed(tmpName, dst, err)
+	}
+	if sum != file.Digest {
+		return file, markFailed(tmpName, dst, ErrDestinationMismatch)
+	}
+
+	// Apply modification time and move to final name.
+	if err := os.Chtimes(tmpName, info.ModTime(), info.ModTime()); err != nil {
+		return file, markFailed(tmpName, dst, err)
+	}
+	if err := os.Rename(tmpName, dst); err != nil {
+		return file, markFailed(tmpName, dst, err)
+	}
+	return file, nil
+}
+
+// isDestination returns whether the entry is the root dir of the destination.
+func (c *copier) isDestination(entry fs.DirEntry) bool {
+	info, err := entry.Info()
+	return err == nil && os.SameFile(info, c.dstInfo)
+}
+
+// markFailed renames a temporary file to mark it as failed.
+func markFailed(tmpName, dst string, err error) error {
+	if renameErr := os.Rename(tmpName, dst+CopyFailedSuffix); renameErr != nil {
+		return fmt.Errorf("%w (failed to mark file as failed: %w)", err, renameErr)
+	}
+	return fmt.Errorf("%w, kept as %s", err, filepath.Base(dst+CopyFailedSuffix))
+}
+
+// copySymlink copies a symlink. It returns the entry for the checksum file.
+func copySymlink(src, dst string) (*Special, error) {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		return nil, err
+	}
+
+	// The modification time of symlinks cannot be set, record the new one.
+	info, err := os.Lstat(dst)
+	if err != nil {
+		return nil, err
+	}
+	return &Special{
+		Type:     "symlink",
+		Modified: info.ModTime().UTC(),
+	}, nil
+}
+
+// isInside returns whether the path p is dir or inside of it, after resolving
+// symlinks. The path p does not need to exist.
+func isInside(dir, p string) (bool, error) {
+	dir, err := resolvePath(dir)
+	if err != nil {
+		return false, err
+	}
+	p, err = resolvePath(p)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		// On different volumes.
+		return false, nil //nolint:nilerr
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+// resolvePath returns the absolute path of p with all symlinks resolved.
+// Parts of the path that do not exist are kept as they are.
+func resolvePath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	switch {
+	case err == nil:
+		return resolved, nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return "", err
+	}
+
+	// Resolve the existing parent.
+	parent := filepath.Dir(p)
+	if parent == p {
+		return p, nil
+	}
+	parent, err = resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(p)), nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
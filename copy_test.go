@@ -0,0 +1,89 @@
+package checkser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyVerifies(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(src, "sub", "b.txt"), "corrupt me\n")
+	updateTree(t, src, ScanConfig{})
+
+	// Copy and verify the copy.
+	dst := filepath.Join(t.TempDir(), "dst")
+	result, err := CopyTree(src, dst, CopyConfig{})
+	switch {
+	case err != nil:
+		t.Fatalf("failed to copy: %s", err)
+	case len(result.Failures) > 0:
+		t.Fatalf("unexpected failure: %s", result.Failures[0])
+	case result.Files != 2 || result.Dirs != 1:
+		t.Fatalf("copied %d files and %d dirs, expected 2 and 1", result.Files, result.Dirs)
+	}
+	assertUnchanged(t, scanTree(t, dst, ScanConfig{DigestAll: true}))
+
+	// Corrupt the source without changing its size or modification time.
+	name := filepath.Join(src, "sub", "b.txt")
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, name, "corrupt m3\n")
+	if err := os.Chtimes(name, time.Time{}, info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	dst = filepath.Join(t.TempDir(), "dst")
+	result, err = CopyTree(src, dst, CopyConfig{})
+	switch {
+	case err != nil:
+		t.Fatalf("failed to copy: %s", err)
+	case len(result.Failures) != 1:
+		t.Fatalf("%d failures, expected 1", len(result.Failures))
+	case result.Failures[0].Path != "sub/b.txt" || !errors.Is(result.Failures[0].Err, ErrSourceMismatch):
+		t.Fatalf("unexpected failure: %s", result.Failures[0])
+	case fileExists(filepath.Join(dst, "sub", "b.txt")):
+		t.Fatal("corrupted file got its final name")
+	case !fileExists(filepath.Join(dst, "sub", "b.txt"+CopyFailedSuffix)):
+		t.Fatal("corrupted file not kept as failed")
+	}
+}
+
+func TestCopyRejectsDestinationInSource(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "a", "a.txt"), "hello\n")
+	updateTree(t, src, ScanConfig{})
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(filepath.Join(src, "a"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dst := range []string{
+		src,
+		filepath.Join(src, "a", "b"),
+		filepath.Join(src, "new"),
+		filepath.Join(link, "b"),
+	} {
+		_, err := CopyTree(src, dst, CopyConfig{})
+		if !errors.Is(err, ErrDestinationInSource) {
+			t.Errorf("%s: error is %v, expected %s", dst, err, ErrDestinationInSource)
+		}
+	}
+	if fileExists(filepath.Join(src, "a", "b")) || fileExists(filepath.Join(src, "new")) {
+		t.Fatal("destination was created")
+	}
+
+	// A sibling with the same prefix is not inside the source.
+	_, err := CopyTree(src, src+"-copy", CopyConfig{})
+	if err != nil {
+		t.Fatalf("failed to copy to sibling: %s", err)
+	}
+}
@@ -0,0 +1,304 @@
+package checkser
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DiffKind describes how an entry differs between two trees.
+type DiffKind string
+
+// Diff kinds.
+const (
+	// DiffOnlyA is used for entries that only exist in tree A.
+	DiffOnlyA DiffKind = "only_a"
+	// DiffOnlyB is used for entries that only exist in tree B.
+	DiffOnlyB DiffKind = "only_b"
+	// DiffType is used for entries of different types, eg. a file and a dir.
+	DiffType DiffKind = "type"
+	// DiffSize is used for files of different sizes.
+	DiffSize DiffKind = "size"
+	// DiffDigest is used for files of the same size, but different digests.
+	DiffDigest DiffKind = "digest"
+	// DiffAlgorithm is used for files digested with different hash
+	// algorithms, which cannot be compared without digesting them again.
+	DiffAlgorithm DiffKind = "algorithm"
+	// DiffTimestamp is used for entries that only differ in their modification time.
+	DiffTimestamp DiffKind = "timestamp"
+	// DiffError is used for entries that could not be compared.
+	DiffError DiffKind = "error"
+)
+
+// DiffConfig configures comparing trees.
+type DiffConfig struct {
+	// Digest digests files whose recorded state is stale, because their size
+	// or modification time on disk differs from the checksum file. Files
+	// recorded with different hash algorithms are digested again in tree B
+	// with the algorithm of tree A.
+	// By default, only the checksum files are compared.
+	Digest bool
+
+	// Key is needed to digest files of keyed trees.
+	Key []byte
+}
+
+// Diff holds the differences between two trees.
+type Diff struct {
+	A string `json:"a"`
+	B string `json:"b"`
+
+	Entries []*DiffEntry `json:"entries"`
+
+	// Counts holds the number of entries per kind.
+	Counts map[DiffKind]int `json:"counts"`
+	// Digested is the number of files that were digested.
+	Digested int `json:"digested"`
+}
+
+// DiffEntry describes an entry that differs between two trees.
+type DiffEntry struct {
+	Type string   `json:"type"`
+	Path string   `json:"path"`
+	Kind DiffKind `json:"diff"`
+
+	// A and B hold the state of the entry in the trees.
+	A *ReportState `json:"a,omitempty"`
+	B *ReportState `json:"b,omitempty"`
+
+	ErrMsgs []string `json:"errors,omitempty"`
+}
+
+// DiffTrees compares two trees by their checksum files, without reading any
+// files, unless enabled in the config. Dirs with identical checksum files are
+// skipped. Paths of entries are relative to the trees, separated by slashes.
+func DiffTrees(dirA, dirB string, cfg DiffConfig) (*Diff, error) {
+	d := &differ{
+		cfg: cfg,
+		diff: &Diff{
+			A:      dirA,
+			B:      dirB,
+			Counts: make(map[DiffKind]int),
+		},
+	}
+
+	csA, err := loadVerifiedChecksums(dirA, nil, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	csB, err := loadVerifiedChecksums(dirB, nil, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	d.dir("", dirA, dirB, csA, csB)
+
+	return d.diff, nil
+}
+
+type differ struct {
+	cfg  DiffConfig
+	diff *Diff
+}
+
+func (d *differ) add(entry *DiffEntry) {
+	d.diff.Entries = append(d.diff.Entries, entry)
+	d.diff.Counts[entry.Kind]++
+}
+
+// dir compares the entries of a dir in both trees.
+func (d *differ) dir(rel, pathA, pathB string, csA, csB *Checksums) {
+	// Collect and sort names of both sides.
+	var names []string
+	for _, cs := range []*Checksums{csA, csB} {
+		for _, file := range cs.Files {
+			names = append(names, file.Name)
+		}
+		for _, dir := range cs.Directories {
+			names = append(names, dir.Name)
+		}
+		for _, special := range cs.Specials {
+			names = append(names, special.Name)
+		}
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	for _, name := range names {
+		entryRel := path.Join(rel, name)
+		entryA := pathA + string(filepath.Separator) + name
+		entryB := pathB + string(filepath.Separator) + name
+		typeA, stateA := diffEntryState(csA, name)
+		typeB, stateB := diffEntryState(csB, name)
+
+		switch {
+		case typeB == "":
+			d.add(&DiffEntry{Type: typeA, Path: entryRel, Kind: DiffOnlyA, A: stateA})
+		case typeA == "":
+			d.add(&DiffEntry{Type: typeB, Path: entryRel, Kind: DiffOnlyB, B: stateB})
+		case typeA != typeB:
+			// Show the types of files and dirs, other files already have one.
+			if stateA.Type == "" {
+				stateA.Type = typeA
+			}
+			if stateB.Type == "" {
+				stateB.Type = typeB
+			}
+			d.add(&DiffEntry{Type: typeA, Path: entryRel, Kind: DiffType, A: stateA, B: stateB})
+
+		case typeA == ReportTypeFile:
+			d.file(entryRel, entryA, entryB, csA.GetFile(name), csB.GetFile(name))
+
+		case typeA == ReportTypeDir:
+			dirA := csA.GetDir(name)
+			dirB := csB.GetDir(name)
+			if !d.cfg.Digest && dirA.Algorithm == dirB.Algorithm && strings.EqualFold(dirA.Digest, dirB.Digest) {
+				// Checksum files are identical.
+				continue
+			}
+			subA, errA := loadVerifiedChecksums(entryA, dirA, d.cfg.Key)
+			subB, errB := loadVerifiedChecksums(entryB, dirB, d.cfg.Key)
+			if errA != nil || errB != nil {
+				entry := &DiffEntry{Type: ReportTypeDir, Path: entryRel, Kind: DiffError}
+				for _, err := range []error{errA, errB} {
+					if err != nil {
+						entry.ErrMsgs = append(entry.ErrMsgs, err.Error())
+					}
+				}
+				d.add(entry)
+				continue
+			}
+			d.dir(entryRel, entryA, entryB, subA, subB)
+
+		default:
+			specialA := csA.GetSpecialFile(name)
+			specialB := csB.GetSpecialFile(name)
+			switch {
+			case specialA.Type != specialB.Type:
+				d.add(&DiffEntry{Type: ReportTypeSpecial, Path: entryRel, Kind: DiffType, A: stateA, B: stateB})
+			case !specialA.Modified.Equal(specialB.Modified):
+				d.add(&DiffEntry{Type: ReportTypeSpecial, Path: entryRel, Kind: DiffTimestamp, A: stateA, B: stateB})
+			}
+		}
+	}
+}
+
+// diffFileState is the state of a file for comparing.
+type diffFileState struct {
+	size      int64
+	modified  time.Time
+	algorithm string
+	digest    string
+}
+
+// file compares a file in both trees.
+func (d *differ) file(rel, pathA, pathB string, fileA, fileB *File) {
+	a := diffFileState{fileA.Size, fileA.Modified, fileA.Algorithm, fileA.Digest}
+	b := diffFileState{fileB.Size, fileB.Modified, fileB.Algorithm, fileB.Digest}
+	var errMsgs []string
+
+	if d.cfg.Digest {
+		// Digest stale files.
+		if err := d.refresh(pathA, &a, a.algorithm); err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("A: %s", err))
+		}
+		if err := d.refresh(pathB, &b, b.algorithm); err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("B: %s", err))
+		}
+
+		// Digest B with the algorithm of A to compare them.
+		if len(errMsgs) == 0 && a.algorithm != b.algorithm {
+			b.algorithm = "" // Force digesting.
+			if err := d.refresh(pathB, &b, a.algorithm); err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf("B: %s", err))
+			}
+		}
+	}
+
+	entry := &DiffEntry{
+		Type:    ReportTypeFile,
+		Path:    rel,
+		A:       a.reportState(),
+		B:       b.reportState(),
+		ErrMsgs: errMsgs,
+	}
+	switch {
+	case len(errMsgs) > 0:
+		entry.Kind = DiffError
+	case a.size != b.size:
+		entry.Kind = DiffSize
+	case a.algorithm != b.algorithm:
+		entry.Kind = DiffAlgorithm
+	case !strings.EqualFold(a.digest, b.digest):
+		entry.Kind = DiffDigest
+	case !a.modified.Equal(b.modified):
+		entry.Kind = DiffTimestamp
+	default:
+		return
+	}
+	d.add(entry)
+}
+
+// refresh digests the file with the given algorithm, if its state differs
+// from the state on disk or the algorithm differs.
+func (d *differ) refresh(name string, state *diffFileState, algorithm string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.Size() == state.size && info.ModTime().Equal(state.modified) && state.algorithm == algorithm {
+		// Recorded state is current.
+		return nil
+	}
+
+	h := Hash(algorithm)
+	if !h.IsValid() {
+		h = DefaultHash
+		if d.cfg.Key != nil {
+			h = h.Keyed()
+		}
+	}
+	sum, err := h.DigestFileWithKey(name, d.cfg.Key)
+	if err != nil {
+		return err
+	}
+	d.diff.Digested++
+
+	state.size = info.Size()
+	state.modified = info.ModTime().UTC()
+	state.algorithm = string(h)
+	state.digest = sum
+	return nil
+}
+
+func (state diffFileState) reportState() *ReportState {
+	return &ReportState{
+		Size:      &state.size,
+		Modified:  nonZeroTime(state.modified),
+		Algorithm: state.algorithm,
+		Digest:    state.digest,
+	}
+}
+
+// diffEntryState returns the type and recorded state of the entry with the given name.
+func diffEntryState(cs *Checksums, name string) (entryType string, state *ReportState) {
+	if file := cs.GetFile(name); file != nil {
+		return ReportTypeFile, diffFileState{file.Size, file.Modified, file.Algorithm, file.Digest}.reportState()
+	}
+	if dir := cs.GetDir(name); dir != nil {
+		return ReportTypeDir, &ReportState{
+			Algorithm: dir.Algorithm,
+			Digest:    dir.Digest,
+		}
+	}
+	if special := cs.GetSpecialFile(name); special != nil {
+		return ReportTypeSpecial, &ReportState{
+			Type:     special.Type,
+			Modified: nonZeroTime(special.Modified),
+		}
+	}
+	return "", nil
+}
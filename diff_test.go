@@ -0,0 +1,85 @@
+package checkser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeDiffFile writes a test file with a fixed modification time.
+func writeDiffFile(t *testing.T, name, data string, modified time.Time) {
+	t.Helper()
+
+	writeTestFile(t, name, data)
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dirA := t.TempDir()
+	dirB := t.TempDir()
+	for _, dir := range []string{dirA, dirB} {
+		writeDiffFile(t, filepath.Join(dir, "same.txt"), "same\n", modified)
+		writeDiffFile(t, filepath.Join(dir, "unchanged", "x.txt"), "x\n", modified)
+	}
+	writeDiffFile(t, filepath.Join(dirA, "size.txt"), "short\n", modified)
+	writeDiffFile(t, filepath.Join(dirB, "size.txt"), "longer\n", modified)
+	writeDiffFile(t, filepath.Join(dirA, "sub", "digest.txt"), "corrupt me\n", modified)
+	writeDiffFile(t, filepath.Join(dirB, "sub", "digest.txt"), "corrupt m3\n", modified)
+	writeDiffFile(t, filepath.Join(dirA, "time.txt"), "time\n", modified)
+	writeDiffFile(t, filepath.Join(dirB, "time.txt"), "time\n", modified.Add(time.Hour))
+	writeDiffFile(t, filepath.Join(dirA, "only-a.txt"), "a\n", modified)
+	writeDiffFile(t, filepath.Join(dirB, "only-b.txt"), "b\n", modified)
+	writeDiffFile(t, filepath.Join(dirA, "type"), "file\n", modified)
+	writeDiffFile(t, filepath.Join(dirB, "type", "file.txt"), "file\n", modified)
+	updateTree(t, dirA, ScanConfig{})
+	updateTree(t, dirB, ScanConfig{})
+
+	diff, err := DiffTrees(dirA, dirB, DiffConfig{})
+	if err != nil {
+		t.Fatalf("failed to diff: %s", err)
+	}
+	expected := map[string]DiffKind{
+		"only-a.txt":     DiffOnlyA,
+		"only-b.txt":     DiffOnlyB,
+		"size.txt":       DiffSize,
+		"sub/digest.txt": DiffDigest,
+		"time.txt":       DiffTimestamp,
+		"type":           DiffType,
+	}
+	found := make(map[string]DiffKind)
+	for _, entry := range diff.Entries {
+		found[entry.Path] = entry.Kind
+	}
+	for path, kind := range expected {
+		if found[path] != kind {
+			t.Errorf("%s differs by %q, expected %q", path, found[path], kind)
+		}
+	}
+	if len(diff.Entries) != len(expected) {
+		t.Errorf("%d differences, expected %d: %v", len(diff.Entries), len(expected), found)
+	}
+	if diff.Counts[DiffOnlyA] != 1 || diff.Digested != 0 {
+		t.Errorf("unexpected counts %v and %d digested files", diff.Counts, diff.Digested)
+	}
+
+	// Stale files are digested on request.
+	writeDiffFile(t, filepath.Join(dirB, "sub", "digest.txt"), "corrupt me\n", modified.Add(time.Minute))
+	diff, err = DiffTrees(dirA, dirB, DiffConfig{Digest: true})
+	if err != nil {
+		t.Fatalf("failed to diff: %s", err)
+	}
+	for _, entry := range diff.Entries {
+		if entry.Path == "sub/digest.txt" && entry.Kind != DiffTimestamp {
+			t.Errorf("stale file differs by %q, expected %q", entry.Kind, DiffTimestamp)
+		}
+	}
+	if diff.Digested != 1 {
+		t.Errorf("%d files digested, expected 1", diff.Digested)
+	}
+}
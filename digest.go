@@ -1,6 +1,10 @@
 package checkser
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
 
 func (scan *Scan) DigestFiles() {
 	scan.digest(scan.rootSum)
@@ -11,6 +15,11 @@ func (scan *Scan) digest(cs *Checksums) {
 
 files:
 	for _, file := range cs.Files {
+		// Skip files out of scope.
+		if !cs.inScope(file.Name) {
+			continue files
+		}
+
 		// Check if a digest is needed.
 		switch file.Change {
 		case Removed, Failed:
@@ -19,8 +28,9 @@ files:
 		case Added, Changed, TimestampChanged:
 			// Always digest.
 		case NoChange:
-			// Only digest if digest all is enabled.
-			if !scan.cfg.DigestAll {
+			// Only digest if digest all is enabled, the digest needs to be keyed
+			// or the archive needs to be indexed.
+			if !scan.cfg.DigestAll && (scan.cfg.Key == nil || Hash(file.Algorithm).IsKeyed()) && !scan.needsIndex(file) {
 				stats.DigestSkipped.Add(1)
 				stats.notify()
 				continue files
@@ -42,8 +52,19 @@ files:
 			h = scan.cfg.DefaultHash
 		}
 
+		// Always use keyed hashes in keyed mode.
+		if scan.cfg.Key != nil && !h.IsKeyed() {
+			h = h.Keyed()
+		}
+
 		// Digest file.
-		sum, err := h.DigestFile(file.Path)
+		var sum string
+		var err error
+		if scan.cfg.Archives && archiveFormat(file.Name) != "" {
+			sum, err = scan.digestArchive(h, file)
+		} else {
+			sum, err = scan.digestFile(h, file.Path)
+		}
 		if err != nil {
 			file.Change = Failed
 			file.ErrMsgs = append(file.ErrMsgs, fmt.Sprintf("digest failed: %s", err))
@@ -63,20 +84,61 @@ files:
 			switch {
 			case file.Algorithm != file.Changed.Algorithm:
 				file.Change = Changed
+				if scan.cfg.Key != nil && !Hash(file.Algorithm).IsKeyed() {
+					file.ErrMsgs = append(file.ErrMsgs, "file integrity not protected: checksum is not keyed")
+				}
 			case file.Digest != file.Changed.Digest:
 				file.Change = Changed
 			}
 		}
+		if file.membersIndexed() {
+			stats.ArchivesIndexed.Add(1)
+		}
 	}
 
 	for _, dir := range cs.Directories {
 		// Check if a digest is needed.
-		switch dir.Change {
-		case Removed, Failed:
+		switch {
+		case dir.Change == Removed, dir.Change == Failed:
 			// Never digest.
+		case dir.Checksums == nil:
+			// Out of scope.
 		default:
 			// Digest everything else.
 			scan.digest(dir.Checksums)
 		}
 	}
 }
+
+// digestFile digests the file, respecting the configured read rate limit.
+func (scan *Scan) digestFile(h Hash, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	var r io.Reader = &statsReader{r: file, stats: scan.Stats}
+	if scan.readLimit != nil {
+		r = &rateLimitedReader{r: r, rl: scan.readLimit}
+	}
+	return h.DigestReaderWithKey(r, scan.cfg.Key)
+}
+
+// statsReader counts the digested bytes in the stats.
+type statsReader struct {
+	r     io.Reader
+	stats *Stats
+}
+
+func (sr *statsReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if n > 0 {
+		// Notify about progress every MiB.
+		total := sr.stats.DigestBytes.Add(uint64(n))
+		if total>>20 != (total-uint64(n))>>20 {
+			sr.stats.notify()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package checkser
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropCache evicts the cached pages of a synced file, so that it is read
+// from the disk the next time.
+func dropCache(f *os.File) error {
+	return unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED) //nolint:gosec // Fd fits into int.
+}
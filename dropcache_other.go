@@ -0,0 +1,11 @@
+//go:build !linux
+
+package checkser
+
+import "os"
+
+// dropCache evicts the cached pages of a synced file. It is only supported
+// on Linux, elsewhere reads may still be served from the cache.
+func dropCache(_ *os.File) error {
+	return nil
+}
@@ -86,6 +86,15 @@ func (scan *Scan) FmtChangeStatus() []string {
 		scan.Stats.Dirs.Failed.Load(),
 		scan.Stats.Special.Failed.Load(),
 	)
+	if scan.cfg.Policy != nil || scan.Stats.PolicyViolations.Load() > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"Policy: %d ignored, %d accepted, %d warnings, %d violations",
+			scan.Stats.PolicyIgnored.Load(),
+			scan.Stats.PolicyAccepted.Load(),
+			scan.Stats.PolicyWarnings.Load(),
+			scan.Stats.PolicyViolations.Load(),
+		))
+	}
 	return lines
 }
 
@@ -123,12 +132,19 @@ func (scan *Scan) iter(
 	specialFunc func(*Special),
 ) {
 	for _, file := range cs.Files {
-		fileFunc(file)
+		if cs.inScope(file.Name) {
+			fileFunc(file)
+		}
 	}
 	for _, special := range cs.Specials {
-		specialFunc(special)
+		if cs.inScope(special.Name) {
+			specialFunc(special)
+		}
 	}
 	for _, dir := range cs.Directories {
+		if !cs.inScope(dir.Name) {
+			continue
+		}
 		dirFunc(dir)
 		if dir.Checksums != nil {
 			scan.iter(dir.Checksums, fileFunc, dirFunc, specialFunc)
@@ -0,0 +1,46 @@
+package checkser
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob matches a slash separated path against a glob pattern.
+// "**" matches any number of path segments. Patterns without a slash match
+// the last segment of the path only, eg. "*.jpg".
+func MatchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+// ValidGlob returns an error if the glob pattern is malformed.
+func ValidGlob(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Match any number of segments.
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,66 @@
+package checkser
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		// Patterns without a slash match the last segment only.
+		{"*.jpg", "a.jpg", true},
+		{"*.jpg", "photos/2024/a.jpg", true},
+		{"*.jpg", "a.jpg/b.txt", false},
+		{"photos", "photos", true},
+		{"photos", "photos/a.jpg", false},
+		{"photos", "old/photos", true},
+
+		// Patterns with a slash match the whole path.
+		{"photos/*.jpg", "photos/a.jpg", true},
+		{"photos/*.jpg", "photos/2024/a.jpg", false},
+		{"photos/*.jpg", "old/photos/a.jpg", false},
+		{"/photos/*.jpg", "photos/a.jpg", true},
+		{"photos/", "photos", true},
+
+		// "**" matches any number of segments, including none.
+		{"photos/**", "photos", true},
+		{"photos/**", "photos/a.jpg", true},
+		{"photos/**", "photos/2024/a.jpg", true},
+		{"photos/**", "videos/a.mp4", false},
+		{"**/*.jpg", "a.jpg", true},
+		{"**/*.jpg", "photos/2024/a.jpg", true},
+		{"**/*.jpg", "photos/2024/a.png", false},
+		{"photos/**/a.jpg", "photos/a.jpg", true},
+		{"photos/**/a.jpg", "photos/2023/12/a.jpg", true},
+		{"photos/**/a.jpg", "photos/2023/12/b.jpg", false},
+		{"**/cache/**", "home/user/cache/x/y", true},
+		{"**/cache/**", "home/user/caches/x", false},
+		{"**", "any/path", true},
+
+		// "**" within a segment is a plain "*".
+		{"photos/a**", "photos/ab", true},
+		{"photos/a**", "photos/ab/c", false},
+	} {
+		if got := MatchGlob(tc.pattern, tc.name); got != tc.match {
+			t.Errorf("MatchGlob(%q, %q) = %v, expected %v", tc.pattern, tc.name, got, tc.match)
+		}
+	}
+}
+
+func TestValidGlob(t *testing.T) {
+	t.Parallel()
+
+	for _, pattern := range []string{"*.jpg", "photos/**", "[a-z]?.txt"} {
+		if err := ValidGlob(pattern); err != nil {
+			t.Errorf("ValidGlob(%q) failed: %s", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"[a-", "photos/[", `\`} {
+		if err := ValidGlob(pattern); err == nil {
+			t.Errorf("ValidGlob(%q) accepted malformed pattern", pattern)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package checkser
 
 import (
 	"crypto"
+	"crypto/hmac"
 	_ "crypto/sha256" // Register algorithms.
 	_ "crypto/sha512" // Register algorithms.
 	"encoding/hex"
@@ -10,6 +11,7 @@ import (
 	"hash"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/zeebo/blake3"
 	_ "golang.org/x/crypto/blake2b" // Register algorithms.
@@ -51,9 +53,17 @@ const (
 	DefaultHash = BLAKE3
 )
 
+// Keyed hashes are named like their base hash with this prefix.
+// Keyed hashes are HMACs, except for BLAKE3, which has a native keyed mode.
+const keyedPrefix = "KEYED_"
+
+// blake3KeyContext is the context for deriving BLAKE3 keys from the user key.
+const blake3KeyContext = "checkser 2025-01-01 keyed digest"
+
 // Errors.
 var (
 	ErrInvalidHashAlg = errors.New("invalid hash algorithm")
+	ErrKeyRequired    = errors.New("key required for keyed hash algorithm")
 )
 
 // New returns a new hash.Hash.
@@ -104,14 +114,81 @@ func (h Hash) New() hash.Hash {
 
 // IsValid returns whether the hash is known.
 func (h Hash) IsValid() bool {
+	if h.IsKeyed() {
+		return h.Unkeyed().New() != nil
+	}
 	return h.New() != nil
 }
 
+// IsKeyed returns whether the hash is a keyed hash.
+func (h Hash) IsKeyed() bool {
+	return strings.HasPrefix(string(h), keyedPrefix)
+}
+
+// Keyed returns the keyed variant of the hash.
+func (h Hash) Keyed() Hash {
+	if h.IsKeyed() {
+		return h
+	}
+	return Hash(keyedPrefix + string(h))
+}
+
+// Unkeyed returns the base hash of a keyed hash.
+func (h Hash) Unkeyed() Hash {
+	return Hash(strings.TrimPrefix(string(h), keyedPrefix))
+}
+
+// NewWithKey returns a new hash.Hash using the given key for keyed hashes.
+// The key is ignored for hashes that are not keyed.
+func (h Hash) NewWithKey(key []byte) hash.Hash {
+	if !h.IsKeyed() {
+		return h.New()
+	}
+	if len(key) == 0 {
+		return nil
+	}
+
+	base := h.Unkeyed()
+	switch base {
+	case BLAKE3:
+		// BLAKE3 needs a key of exactly 32 bytes.
+		derived := make([]byte, 32)
+		blake3.DeriveKey(blake3KeyContext, key, derived)
+		hasher, err := blake3.NewKeyed(derived)
+		if err != nil {
+			return nil
+		}
+		return hasher
+	default:
+		if base.New() == nil {
+			return nil
+		}
+		return hmac.New(base.New, key)
+	}
+}
+
+func (h Hash) newHasher(key []byte) (hash.Hash, error) {
+	hasher := h.NewWithKey(key)
+	switch {
+	case hasher != nil:
+		return hasher, nil
+	case h.IsKeyed() && len(key) == 0 && h.IsValid():
+		return nil, ErrKeyRequired
+	default:
+		return nil, ErrInvalidHashAlg
+	}
+}
+
 // Digest returns the hash sum of the given data.
 func (h Hash) Digest(data []byte) (string, error) {
-	hasher := h.New()
-	if hasher == nil {
-		return "", ErrInvalidHashAlg
+	return h.DigestWithKey(data, nil)
+}
+
+// DigestWithKey returns the hash sum of the given data, using the key for keyed hashes.
+func (h Hash) DigestWithKey(data, key []byte) (string, error) {
+	hasher, err := h.newHasher(key)
+	if err != nil {
+		return "", err
 	}
 
 	// Calculate and return.
@@ -122,18 +199,29 @@ func (h Hash) Digest(data []byte) (string, error) {
 
 // DigestFile reads the given file and calculates its hash sum.
 func (h Hash) DigestFile(filename string) (string, error) {
-	hasher := h.New()
-	if hasher == nil {
-		// TODO: Find a better way to handle this.
-		panic("invalid hash algorithm")
-	}
+	return h.DigestFileWithKey(filename, nil)
+}
 
-	// Read file into hash.
+// DigestFileWithKey reads the given file and calculates its hash sum, using the key for keyed hashes.
+func (h Hash) DigestFileWithKey(filename string, key []byte) (string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return "", fmt.Errorf("open file: %w", err)
 	}
-	_, err = io.Copy(hasher, file)
+	defer file.Close() //nolint:errcheck
+
+	return h.DigestReaderWithKey(file, key)
+}
+
+// DigestReaderWithKey reads until EOF and calculates the hash sum, using the key for keyed hashes.
+func (h Hash) DigestReaderWithKey(r io.Reader, key []byte) (string, error) {
+	hasher, err := h.newHasher(key)
+	if err != nil {
+		return "", err
+	}
+
+	// Read data into hash.
+	_, err = io.Copy(hasher, r)
 	if err != nil {
 		return "", fmt.Errorf("read file: %w", err)
 	}
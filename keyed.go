@@ -0,0 +1,81 @@
+package checkser
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RootMACFilename is the name of the file holding the keyed digest of the
+// root checksum file in keyed mode. As the root checksum file has no parent,
+// this protects it the same way parents protect all other checksum files.
+var RootMACFilename = ".checkser.mac"
+
+// MinKeySize is the minimum size of keys for keyed mode.
+const MinKeySize = 16
+
+// Errors.
+var (
+	ErrMissingRootMAC = errors.New("missing root checksum file MAC")
+	ErrInvalidRootMAC = errors.New("root checksum file MAC does not match")
+)
+
+// VerifyRootMAC verifies the keyed digest of the root checksum file as it was
+// loaded by the scan. It may only be used in keyed mode.
+func (scan *Scan) VerifyRootMAC() error {
+	if scan.rootData == nil {
+		return fmt.Errorf("%w: no root checksum file", ErrMissingRootMAC)
+	}
+	return verifyRootMAC(scan.manifestDir, scan.rootData, scan.cfg.Key)
+}
+
+func verifyRootMAC(dir string, data, key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+
+	// Read MAC file.
+	macData, err := os.ReadFile(filepath.Join(dir, RootMACFilename))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrMissingRootMAC
+		}
+		return fmt.Errorf("failed to read root MAC file: %w", err)
+	}
+	return checkRootMAC(macData, data, key)
+}
+
+// checkRootMAC checks the MAC file data against the root checksum file data.
+func checkRootMAC(macData, data, key []byte) error {
+	alg, mac, ok := strings.Cut(strings.TrimSpace(string(macData)), " ")
+	if !ok || !Hash(alg).IsKeyed() {
+		return fmt.Errorf("%w: malformed MAC file", ErrInvalidRootMAC)
+	}
+
+	// Check MAC.
+	sum, err := Hash(alg).DigestWithKey(data, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sum), []byte(mac)) {
+		return ErrInvalidRootMAC
+	}
+	return nil
+}
+
+func writeRootMAC(dir, alg, sum string) error {
+	return os.WriteFile(filepath.Join(dir, RootMACFilename), []byte(alg+" "+sum+"\n"), 0o0644)
+}
+
+// VerifyRootMACFile verifies the keyed digest of the root checksum file in dir.
+func VerifyRootMACFile(dir string, key []byte) error {
+	data, err := readChecksumFile(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read root checksum file: %w", err)
+	}
+	return verifyRootMAC(dir, data, key)
+}
@@ -0,0 +1,61 @@
+package checkser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyedRootMAC(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("0123456789abcdef")
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "sub\n")
+	updateTree(t, dir, ScanConfig{Key: key})
+
+	// All digests are keyed.
+	data, err := os.ReadFile(filepath.Join(dir, ChecksumFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case !Hash(cs.GetFile("a.txt").Algorithm).IsKeyed():
+		t.Fatalf("file digest %s is not keyed", cs.GetFile("a.txt").Algorithm)
+	case !Hash(cs.GetDir("sub").Algorithm).IsKeyed():
+		t.Fatalf("dir digest %s is not keyed", cs.GetDir("sub").Algorithm)
+	}
+
+	// The root MAC is valid with the key only.
+	scan := scanTree(t, dir, ScanConfig{Key: key})
+	if err := scan.VerifyRootMAC(); err != nil {
+		t.Fatalf("root MAC invalid: %s", err)
+	}
+	assertUnchanged(t, scan)
+	if err := verifyRootMAC(dir, data, []byte("fedcba9876543210")); !errors.Is(err, ErrInvalidRootMAC) {
+		t.Fatalf("unexpected error with wrong key: %v", err)
+	}
+	if err := verifyRootMAC(dir, data, nil); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("unexpected error without key: %v", err)
+	}
+
+	// Tampering with the root checksum file invalidates the MAC.
+	tampered := append(append([]byte(nil), data...), '\n')
+	if err := verifyRootMAC(dir, tampered, key); !errors.Is(err, ErrInvalidRootMAC) {
+		t.Fatalf("unexpected error for tampered root checksum file: %v", err)
+	}
+
+	// A missing MAC is detected.
+	if err := os.Remove(filepath.Join(dir, RootMACFilename)); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRootMAC(dir, data, key); !errors.Is(err, ErrMissingRootMAC) {
+		t.Fatalf("unexpected error for missing MAC: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package checkser
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Minisign key and signature formats, see https://jedisct1.github.io/minisign/.
+
+const (
+	minisignAlgEd        = "Ed" // Legacy, signs the data directly.
+	minisignAlgEdPrehash = "ED" // Signs the BLAKE2b-512 hash of the data.
+	minisignKDFScrypt    = "Sc"
+	minisignKDFNone      = "\x00\x00"
+	minisignChecksumAlg  = "B2"
+
+	minisignUntrustedComment = "untrusted comment: "
+	minisignTrustedComment   = "trusted comment: "
+)
+
+// Errors.
+var (
+	ErrInvalidKey         = errors.New("invalid key")
+	ErrPasswordRequired   = errors.New("password required")
+	ErrInvalidPassword    = errors.New("invalid password")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrMissingSignature   = errors.New("missing signature")
+	ErrUntrustedSignature = errors.New("signature not made by a trusted key")
+)
+
+type minisignPublicKey struct {
+	keyID     [8]byte
+	publicKey ed25519.PublicKey
+}
+
+type minisignSecretKey struct {
+	keyID      [8]byte
+	privateKey ed25519.PrivateKey
+}
+
+// GenerateMinisignKey generates a new unencrypted minisign key pair.
+func GenerateMinisignKey() (secretKey, publicKey []byte, err error) {
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keyID [8]byte
+	_, err = rand.Read(keyID[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Pack public key.
+	pubData := make([]byte, 0, 42)
+	pubData = append(pubData, minisignAlgEd...)
+	pubData = append(pubData, keyID[:]...)
+	pubData = append(pubData, pk...)
+	publicKey = fmt.Appendf(nil,
+		"%sminisign public key %X\n%s\n",
+		minisignUntrustedComment, minisignKeyIDNumber(keyID), base64.StdEncoding.EncodeToString(pubData),
+	)
+
+	// Pack secret key.
+	secData := make([]byte, 0, 158)
+	secData = append(secData, minisignAlgEd...)
+	secData = append(secData, minisignKDFNone...)
+	secData = append(secData, minisignChecksumAlg...)
+	secData = append(secData, make([]byte, 32+8+8)...) // Salt and limits are unused without KDF.
+	secData = append(secData, keyID[:]...)
+	secData = append(secData, sk...)
+	checksum := minisignKeyChecksum(keyID, sk)
+	secData = append(secData, checksum[:]...)
+	secretKey = fmt.Appendf(nil,
+		"%sminisign secret key %X (unencrypted)\n%s\n",
+		minisignUntrustedComment, minisignKeyIDNumber(keyID), base64.StdEncoding.EncodeToString(secData),
+	)
+
+	return secretKey, publicKey, nil
+}
+
+func parseMinisignPublicKey(line string) (*minisignPublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(data) != 42 || string(data[:2]) != minisignAlgEd {
+		return nil, fmt.Errorf("%w: not a minisign public key", ErrInvalidKey)
+	}
+
+	key := &minisignPublicKey{
+		publicKey: ed25519.PublicKey(data[10:]),
+	}
+	copy(key.keyID[:], data[2:10])
+	return key, nil
+}
+
+func parseMinisignSecretKey(data []byte, password func() ([]byte, error)) (*minisignSecretKey, error) {
+	// Find key data, skipping the comment.
+	var encoded string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, minisignUntrustedComment) {
+			encoded = line
+			break
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 158 || string(raw[:2]) != minisignAlgEd || string(raw[4:6]) != minisignChecksumAlg {
+		return nil, fmt.Errorf("%w: not a minisign secret key", ErrInvalidKey)
+	}
+	keynumSK := raw[54:]
+
+	// Decrypt key, if encrypted.
+	switch string(raw[2:4]) {
+	case minisignKDFNone:
+	case minisignKDFScrypt:
+		if password == nil {
+			return nil, ErrPasswordRequired
+		}
+		pw, err := password()
+		if err != nil {
+			return nil, err
+		}
+		salt := raw[6:38]
+		opsLimit := binary.LittleEndian.Uint64(raw[38:46])
+		memLimit := binary.LittleEndian.Uint64(raw[46:54])
+		logN, r, p := minisignScryptParams(opsLimit, memLimit)
+		stream, err := scrypt.Key(pw, salt, 1<<logN, r, p, len(keynumSK))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		decrypted := make([]byte, len(keynumSK))
+		subtle.XORBytes(decrypted, keynumSK, stream)
+		keynumSK = decrypted
+	default:
+		return nil, fmt.Errorf("%w: unsupported key derivation", ErrInvalidKey)
+	}
+
+	// Check key.
+	key := &minisignSecretKey{
+		privateKey: ed25519.PrivateKey(keynumSK[8:72]),
+	}
+	copy(key.keyID[:], keynumSK[:8])
+	checksum := minisignKeyChecksum(key.keyID, key.privateKey)
+	if subtle.ConstantTimeCompare(checksum[:], keynumSK[72:]) != 1 {
+		if string(raw[2:4]) == minisignKDFScrypt {
+			return nil, ErrInvalidPassword
+		}
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidKey)
+	}
+
+	return key, nil
+}
+
+func (key *minisignSecretKey) sign(data []byte, trustedComment string) []byte {
+	// Sign prehashed data.
+	hashed := blake2b.Sum512(data)
+	signature := ed25519.Sign(key.privateKey, hashed[:])
+	sigData := make([]byte, 0, 74)
+	sigData = append(sigData, minisignAlgEdPrehash...)
+	sigData = append(sigData, key.keyID[:]...)
+	sigData = append(sigData, signature...)
+
+	// Sign signature and trusted comment.
+	globalSignature := ed25519.Sign(key.privateKey, append(signature, trustedComment...))
+
+	return fmt.Appendf(nil,
+		"%ssignature from checkser secret key\n%s\n%s%s\n%s\n",
+		minisignUntrustedComment, base64.StdEncoding.EncodeToString(sigData),
+		minisignTrustedComment, trustedComment,
+		base64.StdEncoding.EncodeToString(globalSignature),
+	)
+}
+
+func (key *minisignPublicKey) verify(data, sig []byte) error {
+	// Parse signature.
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) != 4 ||
+		!strings.HasPrefix(lines[0], minisignUntrustedComment) ||
+		!strings.HasPrefix(lines[2], minisignTrustedComment) {
+		return fmt.Errorf("%w: malformed minisign signature", ErrInvalidSignature)
+	}
+	sigData, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sigData) != 74 {
+		return fmt.Errorf("%w: malformed minisign signature", ErrInvalidSignature)
+	}
+	trustedComment := strings.TrimSuffix(strings.TrimPrefix(lines[2], minisignTrustedComment), "\r")
+	globalSignature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed minisign signature", ErrInvalidSignature)
+	}
+
+	// Check key.
+	if !bytes.Equal(sigData[2:10], key.keyID[:]) {
+		return ErrUntrustedSignature
+	}
+
+	// Verify signature.
+	signature := sigData[10:]
+	switch string(sigData[:2]) {
+	case minisignAlgEd:
+	case minisignAlgEdPrehash:
+		hashed := blake2b.Sum512(data)
+		data = hashed[:]
+	default:
+		return fmt.Errorf("%w: unsupported minisign signature algorithm", ErrInvalidSignature)
+	}
+	if !ed25519.Verify(key.publicKey, data, signature) {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(key.publicKey, append(bytes.Clone(signature), trustedComment...), globalSignature) {
+		return fmt.Errorf("%w: trusted comment was modified", ErrInvalidSignature)
+	}
+
+	return nil
+}
+
+func (key *minisignPublicKey) String() string {
+	return fmt.Sprintf("minisign key %X", minisignKeyIDNumber(key.keyID))
+}
+
+func minisignKeyChecksum(keyID [8]byte, sk []byte) [32]byte {
+	hasher, _ := blake2b.New256(nil) // Only fails with invalid key.
+	_, _ = hasher.Write([]byte(minisignAlgEd))
+	_, _ = hasher.Write(keyID[:])
+	_, _ = hasher.Write(sk)
+	var sum [32]byte
+	copy(sum[:], hasher.Sum(nil))
+	return sum
+}
+
+// minisignKeyIDNumber returns the key ID as displayed by minisign.
+func minisignKeyIDNumber(keyID [8]byte) uint64 {
+	return binary.LittleEndian.Uint64(keyID[:])
+}
+
+// minisignScryptParams derives the scrypt parameters from the ops and mem
+// limits, the same way libsodium does.
+func minisignScryptParams(opsLimit, memLimit uint64) (logN uint, r, p int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+	r = 8
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN := opsLimit / uint64(r*4)
+		for logN = 1; logN < 63; logN++ {
+			if uint64(1)<<logN > maxN/2 {
+				break
+			}
+		}
+		return logN, r, p
+	}
+
+	maxN := memLimit / uint64(r*128)
+	for logN = 1; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	maxRP := (opsLimit / 4) / (uint64(1) << logN)
+	if maxRP > 0x3fffffff {
+		maxRP = 0x3fffffff
+	}
+	p = int(maxRP) / r
+	return logN, r, p
+}
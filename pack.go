@@ -1,7 +1,10 @@
 package checkser
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 
 	"golang.org/x/text/unicode/norm"
 	"gopkg.in/yaml.v3"
@@ -11,22 +14,84 @@ import (
 var (
 	ErrInvalidChecksumFile = errors.New("invalid checksum file")
 	ErrUnsupportedVersion  = errors.New("unsupported version")
+	ErrUnsupportedFormat   = errors.New("unsupported format")
+	ErrUnknownBinaryKeys   = errors.New("checksum file has unknown binary fields, which cannot be converted to a text format")
 )
 
+// Format is a checksum file format.
+type Format string
+
+// Formats.
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+
+	// Compact binary formats for huge directories.
+	FormatCBOR     Format = "cbor"
+	FormatCBORZstd Format = "cbor+zstd"
+
+	// Default Format.
+	DefaultFormat = FormatYAML
+)
+
+// IsValid returns whether the format is known.
+func (f Format) IsValid() bool {
+	switch f {
+	case FormatYAML, FormatJSON, FormatCBOR, FormatCBORZstd:
+		return true
+	default:
+		return false
+	}
+}
+
+// isBinary returns whether the format is binary.
+func (f Format) isBinary() bool {
+	return f == FormatCBOR || f == FormatCBORZstd
+}
+
+// DetectFormat returns the format of the given checksum file data.
+func DetectFormat(data []byte) Format {
+	switch {
+	case bytes.HasPrefix(data, zstdMagic):
+		return FormatCBORZstd
+	case bytes.HasPrefix(data, cborMagic):
+		return FormatCBOR
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
 func LoadChecksums(data []byte) (*Checksums, error) {
 	// Load data into struct.
-	cs := &Checksums{}
-	err := yaml.Unmarshal(data, cs)
+	cs := &Checksums{
+		format: DetectFormat(data),
+	}
+	var err error
+	switch cs.format {
+	case FormatJSON:
+		err = json.Unmarshal(data, cs)
+	case FormatCBOR:
+		err = loadBinary(data, cs)
+	case FormatCBORZstd:
+		data, err = decompressZstd(data)
+		if err == nil {
+			err = loadBinary(data, cs)
+		}
+	default:
+		err = yaml.Unmarshal(data, cs)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Check if file is correct, as far as we can tell.
-	switch {
-	case cs.Version > 1:
-		return nil, ErrUnsupportedVersion
-	case cs.Version <= 0:
-		return nil, ErrInvalidChecksumFile
+	// Check if file is correct, as far as we can tell, and migrate to the current schema.
+	err = cs.migrate()
+	if err != nil {
+		return nil, err
 	}
 
 	// Normalize names.
@@ -43,6 +108,34 @@ func LoadChecksums(data []byte) (*Checksums, error) {
 	return cs, nil
 }
 
+// PackChecksums serializes the checksums in their format.
+// Checksums without a format are serialized in the default format.
+// Checksums with unknown integer keys of a binary checksum file cannot be
+// serialized in a text format, as they would be lost.
 func PackChecksums(cs *Checksums) ([]byte, error) {
-	return yaml.Marshal(cs)
+	if !cs.Format().isBinary() && cs.hasBinUnknown() {
+		return nil, ErrUnknownBinaryKeys
+	}
+	cs.updateCompat()
+
+	switch cs.Format() {
+	case FormatYAML:
+		return yaml.Marshal(cs)
+	case FormatJSON:
+		data, err := json.MarshalIndent(cs, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatCBOR:
+		return packBinary(cs)
+	case FormatCBORZstd:
+		data, err := packBinary(cs)
+		if err != nil {
+			return nil, err
+		}
+		return compressZstd(data), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, cs.format)
+	}
 }
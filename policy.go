@@ -0,0 +1,229 @@
+package checkser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Action defines how a change is handled.
+type Action string
+
+// Actions.
+const (
+	// ActionIgnore hides the change. It is recorded when updating.
+	ActionIgnore Action = "ignore"
+	// ActionAccept reports the change, but accepts it without review.
+	// It is recorded when updating.
+	ActionAccept Action = "accept"
+	// ActionWarn reports the change for review. It is recorded when updating.
+	// This is the default.
+	ActionWarn Action = "warn"
+	// ActionFail reports the change as policy violation.
+	// It is never recorded when updating.
+	ActionFail Action = "fail"
+)
+
+// Errors.
+var (
+	ErrInvalidPolicy = errors.New("invalid policy")
+)
+
+// IsValid returns whether the action is known.
+func (a Action) IsValid() bool {
+	switch a {
+	case ActionIgnore, ActionAccept, ActionWarn, ActionFail:
+		return true
+	default:
+		return false
+	}
+}
+
+// Flagged returns whether the change needs attention.
+func (a Action) Flagged() bool {
+	return a == ActionWarn || a == ActionFail
+}
+
+// Policy maps changes to actions.
+type Policy struct {
+	// Root is the dir the paths of the rules are relative to.
+	// Defaults to the scanned dir.
+	Root string `yaml:"-"`
+
+	// Default is the action for changes that match no rule.
+	// Defaults to warn.
+	Default Action `yaml:"default"`
+
+	// Rules are evaluated in order, the first matching rule applies.
+	Rules []*PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule maps changes of matching paths to an action.
+type PolicyRule struct {
+	// Paths are globs of the paths the rule applies to.
+	// "**" matches any number of dirs, patterns without "/" match file names.
+	// The rule applies to all paths if empty.
+	Paths []string `yaml:"paths"`
+
+	// Changes are the changes the rule applies to: added, removed, changed,
+	// timestamp_changed. The rule applies to all changes if empty.
+	Changes []string `yaml:"changes"`
+
+	Action Action `yaml:"action"`
+
+	changes []Change
+}
+
+// LoadPolicy loads a policy from a YAML file.
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	err = yaml.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+	}
+	err = policy.Check()
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Check checks the policy and sets defaults.
+func (p *Policy) Check() error {
+	switch {
+	case p.Default == "":
+		p.Default = ActionWarn
+	case !p.Default.IsValid():
+		return fmt.Errorf("%w: unknown default action %q", ErrInvalidPolicy, p.Default)
+	}
+
+	for i, rule := range p.Rules {
+		if !rule.Action.IsValid() {
+			return fmt.Errorf("%w: rule %d: unknown action %q", ErrInvalidPolicy, i+1, rule.Action)
+		}
+		for _, pattern := range rule.Paths {
+			if err := ValidGlob(pattern); err != nil {
+				return fmt.Errorf("%w: rule %d: invalid path %q: %w", ErrInvalidPolicy, i+1, pattern, err)
+			}
+		}
+		rule.changes = make([]Change, 0, len(rule.Changes))
+		for _, name := range rule.Changes {
+			c := ParseChange(name)
+			switch c {
+			case Added, Removed, Changed, TimestampChanged:
+				rule.changes = append(rule.changes, c)
+			default:
+				return fmt.Errorf("%w: rule %d: unknown change %q", ErrInvalidPolicy, i+1, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Action returns the action for the change of the entry at the given path.
+func (p *Policy) Action(path string, change Change) Action {
+	switch change {
+	case Added, Removed, Changed, TimestampChanged:
+	default:
+		// Only changes are subject to the policy.
+		return ""
+	}
+
+	// Get path relative to the policy root.
+	rel, err := filepath.Rel(p.Root, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return p.Default
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, rule := range p.Rules {
+		if len(rule.changes) > 0 && !slices.Contains(rule.changes, change) {
+			continue
+		}
+		if len(rule.Paths) > 0 && !slices.ContainsFunc(rule.Paths, func(pattern string) bool {
+			return MatchGlob(pattern, rel)
+		}) {
+			continue
+		}
+		return rule.Action
+	}
+	return p.Default
+}
+
+// applyPolicy sets the action of all changed entries from the policy and
+// append-only mode.
+func (scan *Scan) applyPolicy(cs *Checksums) {
+	for _, file := range cs.Files {
+		if cs.inScope(file.Name) {
+			file.Action = scan.action(cs, file.Path, file.Change)
+		}
+	}
+	for _, special := range cs.Specials {
+		if cs.inScope(special.Name) {
+			special.Action = scan.action(cs, special.Path, special.Change)
+		}
+	}
+	for _, dir := range cs.Directories {
+		if !cs.inScope(dir.Name) {
+			continue
+		}
+		dir.Action = scan.action(cs, dir.Path, dir.Change)
+		if dir.Checksums != nil {
+			scan.applyPolicy(dir.Checksums)
+		}
+	}
+}
+
+// action returns the action for the change of an entry of cs.
+// Append-only mode takes precedence over the policy.
+func (scan *Scan) action(cs *Checksums, path string, change Change) Action {
+	if cs.AppendOnly && violatesAppendOnly(change) {
+		return ActionFail
+	}
+	if scan.cfg.Policy != nil {
+		return scan.cfg.Policy.Action(path, change)
+	}
+	return ""
+}
+
+// refuseViolations reverts all changes violating the policy or append-only
+// mode, so that they are not recorded.
+func refuseViolations(cs *Checksums) {
+	for _, file := range cs.Files {
+		if file.Action == ActionFail {
+			file.Change = refusedChange(file.Change)
+		}
+	}
+	for _, special := range cs.Specials {
+		if special.Action == ActionFail {
+			special.Change = refusedChange(special.Change)
+		}
+	}
+	for _, dir := range cs.Directories {
+		if dir.Action == ActionFail {
+			dir.Change = refusedChange(dir.Change)
+			if dir.Change == Removed {
+				// Do not write checksum files of refused new dirs.
+				dir.Checksums = nil
+			}
+		}
+	}
+}
+
+// refusedChange returns the change that keeps the recorded state.
+func refusedChange(change Change) Change {
+	if change == Added {
+		// Not recorded before, purge entry.
+		return Removed
+	}
+	// Keep recorded state.
+	return NoChange
+}
@@ -0,0 +1,124 @@
+package checkser
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyAction(t *testing.T) {
+	t.Parallel()
+
+	policy := &Policy{
+		Root: "/data",
+		Rules: []*PolicyRule{
+			{Paths: []string{"*.tmp"}, Action: ActionIgnore},
+			{Paths: []string{"archive/**"}, Changes: []string{"changed", "removed"}, Action: ActionFail},
+			{Paths: []string{"archive/**"}, Action: ActionAccept},
+		},
+	}
+	if err := policy.Check(); err != nil {
+		t.Fatalf("invalid policy: %s", err)
+	}
+
+	for _, tc := range []struct {
+		path   string
+		change Change
+		action Action
+	}{
+		{"/data/a.tmp", Changed, ActionIgnore},
+		{"/data/archive/b.tmp", Changed, ActionIgnore},
+		{"/data/archive/2024/a.txt", Changed, ActionFail},
+		{"/data/archive/2024/a.txt", Removed, ActionFail},
+		{"/data/archive/2024/a.txt", Added, ActionAccept},
+		{"/data/archive/2024/a.txt", TimestampChanged, ActionAccept},
+		{"/data/other/a.txt", Changed, ActionWarn},
+		// Paths outside the root get the default action.
+		{"/other/archive/a.txt", Changed, ActionWarn},
+		// Only changes are subject to the policy.
+		{"/data/archive/a.txt", NoChange, ""},
+		{"/data/archive/a.txt", Failed, ""},
+	} {
+		if got := policy.Action(tc.path, tc.change); got != tc.action {
+			t.Errorf("action for %s of %s is %q, expected %q", tc.change.Name(), tc.path, got, tc.action)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []*Policy{
+		{Default: "explode"},
+		{Rules: []*PolicyRule{{Action: "explode"}}},
+		{Rules: []*PolicyRule{{Action: ActionFail, Paths: []string{"[a-"}}}},
+		{Rules: []*PolicyRule{{Action: ActionFail, Changes: []string{"no_change"}}}},
+	} {
+		if err := policy.Check(); err == nil {
+			t.Errorf("invalid policy %+v accepted", policy)
+		}
+	}
+}
+
+func TestRefuseViolations(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		change  Change
+		refused Change
+	}{
+		// Added entries were never recorded and are purged.
+		{Added, Removed},
+		// All other changes keep the recorded state.
+		{Removed, NoChange},
+		{Changed, NoChange},
+		{TimestampChanged, NoChange},
+	} {
+		cs := &Checksums{
+			Files:       []*File{{Change: tc.change, Action: ActionFail}, {Change: tc.change, Action: ActionWarn}},
+			Specials:    []*Special{{Change: tc.change, Action: ActionFail}},
+			Directories: []*Directory{{Change: tc.change, Action: ActionFail, Checksums: &Checksums{}}},
+		}
+		refuseViolations(cs)
+		switch {
+		case cs.Files[0].Change != tc.refused:
+			t.Errorf("refused %s file is %s, expected %s", tc.change.Name(), cs.Files[0].Change.Name(), tc.refused.Name())
+		case cs.Files[1].Change != tc.change:
+			t.Errorf("accepted %s file is %s", tc.change.Name(), cs.Files[1].Change.Name())
+		case cs.Specials[0].Change != tc.refused:
+			t.Errorf("refused %s special is %s, expected %s", tc.change.Name(), cs.Specials[0].Change.Name(), tc.refused.Name())
+		case cs.Directories[0].Change != tc.refused:
+			t.Errorf("refused %s dir is %s, expected %s", tc.change.Name(), cs.Directories[0].Change.Name(), tc.refused.Name())
+		case (cs.Directories[0].Checksums == nil) != (tc.refused == Removed):
+			t.Errorf("checksums of refused %s dir kept: %v", tc.change.Name(), cs.Directories[0].Checksums != nil)
+		}
+	}
+}
+
+func TestPolicyViolationsNotRecorded(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// Add a file violating the policy and an accepted one.
+	writeTestFile(t, filepath.Join(dir, "evil.exe"), "evil\n")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "fine\n")
+	policy := &Policy{Rules: []*PolicyRule{{Paths: []string{"*.exe"}, Action: ActionFail}}}
+	if err := policy.Check(); err != nil {
+		t.Fatalf("invalid policy: %s", err)
+	}
+	scan := updateTree(t, dir, ScanConfig{Policy: policy})
+	if violations := scan.Stats.PolicyViolations.Load(); violations != 1 {
+		t.Fatalf("%d policy violations, expected 1", violations)
+	}
+
+	// The violation is found again, the accepted file was recorded.
+	scan = scanTree(t, dir, ScanConfig{})
+	switch {
+	case scan.Stats.Files.Added.Load() != 1:
+		t.Fatalf("%d added files, expected 1", scan.Stats.Files.Added.Load())
+	case findFile(scan, "evil.exe").Change != Added:
+		t.Fatal("refused file was recorded")
+	}
+}
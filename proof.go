@@ -0,0 +1,249 @@
+package checkser
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ProofVersion is the version of the proof format.
+const ProofVersion = 1
+
+// Errors.
+var (
+	ErrInvalidProof   = errors.New("invalid proof")
+	ErrUntrustedProof = errors.New("proof root does not match trusted root digest")
+)
+
+// Proof is a self-contained inclusion proof of a file in a tree.
+// It holds the entry of the file and the chain of checksum files from the
+// dir of the file up to the tree root. As every checksum file is recorded
+// with its digest in its parent, the file can be verified with only the
+// proof and a trusted digest or signature of the root checksum file.
+type Proof struct {
+	Version int `json:"checkser_proof"`
+
+	// Path is the path of the file relative to the tree root, separated by slashes.
+	Path string `json:"path"`
+	// Entry is the entry of the file, as recorded in the checksum file of its dir.
+	Entry *File `json:"entry"`
+
+	// ChecksumFiles holds the raw checksum files from the dir of the file up
+	// to the tree root. The root checksum file is last.
+	ChecksumFiles [][]byte `json:"checksum_files"`
+
+	// RootAlgorithm is the hash algorithm used for the root digest.
+	RootAlgorithm string `json:"root_alg"`
+	// Signature holds the signature of the root checksum file, if it is signed.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Prove creates an inclusion proof for the file at path.
+// The file and the chain of checksum files up to the tree root are verified
+// before, so that only valid proofs are created.
+// The key is needed for keyed trees.
+func Prove(path string, key []byte) (*Proof, error) {
+	// Verify file and chain.
+	v, err := VerifyFile(path, key, true)
+	if err != nil {
+		return nil, err
+	}
+	relPath, err := filepath.Rel(v.Chain.Root, v.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create proof.
+	proof := &Proof{
+		Version:       ProofVersion,
+		Path:          norm.NFC.String(filepath.ToSlash(relPath)),
+		Entry:         v.Entry,
+		ChecksumFiles: [][]byte{v.Data},
+		RootAlgorithm: string(DefaultHash),
+	}
+	if key != nil {
+		proof.RootAlgorithm = string(DefaultHash.Keyed())
+	}
+
+	// Add chain of checksum files.
+	for _, link := range v.Chain.Links {
+		data, err := readChecksumFile(link.Parent)
+		if err != nil {
+			return nil, err
+		}
+		proof.ChecksumFiles = append(proof.ChecksumFiles, data)
+	}
+
+	// Add signature, if available.
+	proof.Signature, err = os.ReadFile(filepath.Join(v.Chain.Root, SignatureFilename))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read signature file: %w", err)
+	}
+
+	// Check that nothing changed in between.
+	_, err = proof.VerifyChain(key)
+	if err != nil {
+		return nil, fmt.Errorf("tree changed while creating proof: %w", err)
+	}
+
+	return proof, nil
+}
+
+// LoadProof loads a proof.
+func LoadProof(data []byte) (*Proof, error) {
+	proof := &Proof{}
+	err := json.Unmarshal(data, proof)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+	if proof.Version != ProofVersion {
+		return nil, fmt.Errorf("%w: unsupported proof version %d", ErrInvalidProof, proof.Version)
+	}
+	return proof, nil
+}
+
+// Pack serializes the proof.
+func (proof *Proof) Pack() ([]byte, error) {
+	data, err := json.MarshalIndent(proof, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// RootDigest returns the digest of the root checksum file of the proof.
+// The key is needed for proofs of keyed trees.
+func (proof *Proof) RootDigest(key []byte) (string, error) {
+	if len(proof.ChecksumFiles) == 0 {
+		return "", fmt.Errorf("%w: no checksum files", ErrInvalidProof)
+	}
+	return Hash(proof.RootAlgorithm).DigestWithKey(proof.ChecksumFiles[len(proof.ChecksumFiles)-1], key)
+}
+
+// VerifyChain verifies that the entry of the file is recorded in the chain of
+// checksum files up to the root checksum file. It returns the digest of the
+// root checksum file, which must be checked against a trusted root digest or
+// signature for the proof to be trusted.
+// The key is needed for proofs of keyed trees.
+func (proof *Proof) VerifyChain(key []byte) (rootDigest string, err error) {
+	names := proofPathNames(proof.Path)
+	switch {
+	case len(names) == 0:
+		return "", fmt.Errorf("%w: empty path", ErrInvalidProof)
+	case len(names) != len(proof.ChecksumFiles):
+		return "", fmt.Errorf("%w: path does not match chain of checksum files", ErrInvalidProof)
+	case proof.Entry == nil:
+		return "", fmt.Errorf("%w: missing entry", ErrInvalidProof)
+	}
+
+	// Check that the entry is recorded in the checksum file of its dir.
+	cs, err := LoadChecksums(proof.ChecksumFiles[0])
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrInvalidProof, proof.Path, err)
+	}
+	file := cs.GetFile(names[len(names)-1])
+	switch {
+	case file == nil:
+		return "", fmt.Errorf("%w: %s: %w", ErrInvalidProof, proof.Path, ErrNotRecorded)
+	case file.Size != proof.Entry.Size,
+		file.Algorithm != proof.Entry.Algorithm,
+		file.Digest != proof.Entry.Digest:
+		return "", fmt.Errorf("%w: entry does not match checksum file", ErrInvalidProof)
+	}
+
+	// Check that every checksum file is recorded in its parent.
+	for i := 1; i < len(proof.ChecksumFiles); i++ {
+		dirPath := strings.Join(names[:len(names)-i], "/")
+		cs, err := LoadChecksums(proof.ChecksumFiles[i])
+		if err != nil {
+			return "", fmt.Errorf("%w: parent of %s: %w", ErrInvalidProof, dirPath, err)
+		}
+		dir := cs.GetDir(names[len(names)-i-1])
+		if dir == nil || dir.Algorithm == "" {
+			return "", fmt.Errorf("%w: %s: %w", ErrInvalidProof, dirPath, ErrNotRecorded)
+		}
+		sum, err := Hash(dir.Algorithm).DigestWithKey(proof.ChecksumFiles[i-1], key)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %w", ErrInvalidProof, dirPath, err)
+		}
+		if sum != dir.Digest {
+			return "", fmt.Errorf("%w: %s: %w: checksum did not match", ErrInvalidProof, dirPath, ErrIntegrityViolated)
+		}
+	}
+
+	return proof.RootDigest(key)
+}
+
+// VerifyRoot verifies the chain of the proof against a trusted root digest.
+func (proof *Proof) VerifyRoot(trustedRootDigest string, key []byte) error {
+	rootDigest, err := proof.VerifyChain(key)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(rootDigest), []byte(strings.ToLower(trustedRootDigest))) != 1 {
+		return ErrUntrustedProof
+	}
+	return nil
+}
+
+// VerifySignature verifies the chain of the proof and the signature of the
+// root checksum file.
+func (proof *Proof) VerifySignature(tk *TrustedKeys, key []byte) (signedBy string, err error) {
+	_, err = proof.VerifyChain(key)
+	if err != nil {
+		return "", err
+	}
+	if len(proof.Signature) == 0 {
+		return "", ErrMissingSignature
+	}
+	return tk.Verify(proof.ChecksumFiles[len(proof.ChecksumFiles)-1], proof.Signature)
+}
+
+// VerifyFile verifies the content of the file against the entry of the proof.
+// The proof itself must be verified with VerifyRoot or VerifySignature.
+func (proof *Proof) VerifyFile(r io.Reader, key []byte) error {
+	if proof.Entry == nil {
+		return fmt.Errorf("%w: missing entry", ErrInvalidProof)
+	}
+
+	// Digest content, counting the size.
+	counter := &countingReader{r: r}
+	sum, err := Hash(proof.Entry.Algorithm).DigestReaderWithKey(counter, key)
+	if err != nil {
+		return err
+	}
+	switch {
+	case counter.n != proof.Entry.Size:
+		return fmt.Errorf("%w: size did not match", ErrFileIntegrityViolated)
+	case sum != proof.Entry.Digest:
+		return fmt.Errorf("%w: checksum did not match", ErrFileIntegrityViolated)
+	}
+	return nil
+}
+
+// proofPathNames splits the slash separated path into its names.
+func proofPathNames(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,136 @@
+package checkser
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProof(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "deeper", "b.txt"), "prove me\n")
+	updateTree(t, dir, ScanConfig{})
+	rootData, err := os.ReadFile(filepath.Join(dir, ChecksumFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootDigest, err := DefaultHash.Digest(rootData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create proof and load it again.
+	proof, err := Prove(filepath.Join(dir, "sub", "deeper", "b.txt"), nil)
+	if err != nil {
+		t.Fatalf("failed to create proof: %s", err)
+	}
+	packed, err := proof.Pack()
+	if err != nil {
+		t.Fatalf("failed to pack proof: %s", err)
+	}
+	proof, err = LoadProof(packed)
+	switch {
+	case err != nil:
+		t.Fatalf("failed to load proof: %s", err)
+	case proof.Path != "sub/deeper/b.txt":
+		t.Fatalf("proof path is %s", proof.Path)
+	case len(proof.ChecksumFiles) != 3:
+		t.Fatalf("proof has %d checksum files, expected 3", len(proof.ChecksumFiles))
+	}
+
+	// Verify against the root digest.
+	if err := proof.VerifyRoot(strings.ToUpper(rootDigest), nil); err != nil {
+		t.Fatalf("failed to verify proof: %s", err)
+	}
+	if err := proof.VerifyFile(strings.NewReader("prove me\n"), nil); err != nil {
+		t.Fatalf("failed to verify file: %s", err)
+	}
+	if err := proof.VerifyFile(strings.NewReader("prove m3\n"), nil); !errors.Is(err, ErrFileIntegrityViolated) {
+		t.Fatalf("unexpected error for changed file: %v", err)
+	}
+	if err := proof.VerifyFile(strings.NewReader("prove me\n\n"), nil); !errors.Is(err, ErrFileIntegrityViolated) {
+		t.Fatalf("unexpected error for changed size: %v", err)
+	}
+	if err := proof.VerifyRoot(strings.Repeat("0", len(rootDigest)), nil); !errors.Is(err, ErrUntrustedProof) {
+		t.Fatalf("unexpected error for wrong root digest: %v", err)
+	}
+
+	// Signed proofs.
+	if _, err := proof.VerifySignature(&TrustedKeys{}, nil); !errors.Is(err, ErrMissingSignature) {
+		t.Fatalf("unexpected error for missing signature: %v", err)
+	}
+	secretKey, publicKey, err := GenerateMinisignKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := ParseSigningKey(secretKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk, err := LoadTrustedKeys(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignRootChecksumFile(dir, sk); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := Prove(filepath.Join(dir, "sub", "deeper", "b.txt"), nil)
+	if err != nil {
+		t.Fatalf("failed to create signed proof: %s", err)
+	}
+	if _, err := signed.VerifySignature(tk, nil); err != nil {
+		t.Fatalf("failed to verify signed proof: %s", err)
+	}
+
+	// Tampered proofs fail.
+	for _, tc := range []struct {
+		name   string
+		tamper func(p *Proof)
+		err    error
+	}{
+		{"entry digest", func(p *Proof) {
+			p.Entry.Digest = strings.Repeat("0", len(p.Entry.Digest))
+		}, ErrInvalidProof},
+		{"entry size", func(p *Proof) {
+			p.Entry.Size++
+		}, ErrInvalidProof},
+		{"path", func(p *Proof) {
+			p.Path = "sub/deeper/a.txt"
+		}, ErrNotRecorded},
+		{"short path", func(p *Proof) {
+			p.Path = "b.txt"
+		}, ErrInvalidProof},
+		{"checksum file", func(p *Proof) {
+			p.ChecksumFiles[1] = append(bytes.Clone(p.ChecksumFiles[1]), '\n')
+		}, ErrIntegrityViolated},
+		{"root checksum file", func(p *Proof) {
+			p.ChecksumFiles[2] = append(bytes.Clone(p.ChecksumFiles[2]), '\n')
+		}, ErrUntrustedProof},
+	} {
+		tampered, err := LoadProof(packed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.tamper(tampered)
+		if err := tampered.VerifyRoot(rootDigest, nil); !errors.Is(err, tc.err) {
+			t.Errorf("unexpected error for tampered %s: %v", tc.name, err)
+		}
+	}
+
+	// A tampered root checksum file fails the signature.
+	signed.ChecksumFiles[2] = append(bytes.Clone(signed.ChecksumFiles[2]), '\n')
+	if _, err := signed.VerifySignature(tk, nil); err == nil {
+		t.Fatal("tampered signed proof passed verification")
+	}
+
+	// Unknown versions are refused.
+	if _, err := LoadProof(bytes.Replace(packed, []byte(`"checkser_proof": 1`), []byte(`"checkser_proof": 2`), 1)); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("unexpected error for unknown version: %v", err)
+	}
+}
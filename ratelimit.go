@@ -0,0 +1,70 @@
+package checkser
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the read rate of all readers sharing it.
+type rateLimiter struct {
+	lock sync.Mutex
+
+	// rate is the allowed rate in bytes per second.
+	rate int64
+
+	// start and total track the bytes read since start.
+	start time.Time
+	total int64
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate: rate,
+	}
+}
+
+// wait registers n read bytes and waits until they are within the rate limit.
+func (rl *rateLimiter) wait(n int) {
+	rl.lock.Lock()
+	now := time.Now()
+
+	// Reset after idling, so that unused rate does not accumulate.
+	if rl.start.IsZero() || now.Sub(rl.expected()) > time.Second {
+		rl.start = now
+		rl.total = 0
+	}
+
+	rl.total += int64(n)
+	delay := time.Until(rl.expected())
+	rl.lock.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// expected returns the time at which the bytes read so far are within the rate limit.
+func (rl *rateLimiter) expected() time.Time {
+	return rl.start.Add(time.Duration(float64(rl.total) / float64(rl.rate) * float64(time.Second)))
+}
+
+type rateLimitedReader struct {
+	r  io.Reader
+	rl *rateLimiter
+}
+
+func (rlr *rateLimitedReader) Read(p []byte) (int, error) {
+	// Read in small chunks to keep the rate smooth.
+	if chunk := rlr.rl.rate / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := rlr.r.Read(p)
+	if n > 0 {
+		rlr.rl.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,266 @@
+package checkser
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"time"
+)
+
+// ReportVersion is the version of the report schema.
+// It is increased whenever fields are removed or change their meaning.
+// New fields may be added without increasing the version.
+const ReportVersion = 1
+
+// Report is a machine-readable representation of a scan.
+type Report struct {
+	Version int    `json:"checkser_report"`
+	Root    string `json:"root"`
+
+	Entries []*ReportEntry `json:"entries"`
+
+	Summary     *StatsSnapshot `json:"summary"`
+	WriteErrors []string       `json:"write_errors,omitempty"`
+}
+
+// ReportEntry describes a single file, directory or other file of a scan.
+type ReportEntry struct {
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	// Action is the policy action for the change, if a policy is set.
+	Action string `json:"action,omitempty"`
+
+	// Old holds the state recorded in the checksum file.
+	Old *ReportState `json:"old,omitempty"`
+	// New holds the state found on disk.
+	New *ReportState `json:"new,omitempty"`
+
+	Verified bool     `json:"verified,omitempty"`
+	ErrMsgs  []string `json:"errors,omitempty"`
+}
+
+// ReportState describes the state of an entry at one point in time.
+type ReportState struct {
+	Size      *int64     `json:"size,omitempty"`
+	Modified  *time.Time `json:"mod,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	Algorithm string     `json:"alg,omitempty"`
+	Digest    string     `json:"sum,omitempty"`
+}
+
+// Report entry types.
+const (
+	ReportTypeFile    = "file"
+	ReportTypeDir     = "dir"
+	ReportTypeSpecial = "other"
+	// ReportTypeMember is used for members of archives, which are reported
+	// after their archive if it changed.
+	ReportTypeMember = "member"
+)
+
+// Report returns a report of all entries of the scan.
+// It must be called before writing checksum files, as writing applies all
+// changes and thereby removes the information of the previous state.
+func (scan *Scan) Report() *Report {
+	report := &Report{
+		Version: ReportVersion,
+		Root:    scan.rootDir,
+		Summary: scan.Stats.Snapshot(),
+	}
+	if scan.rootSum == nil {
+		return report
+	}
+
+	scan.Iterate(
+		func(file *File) {
+			report.Entries = append(report.Entries, file.reportEntry())
+			if file.Change == Changed {
+				for _, member := range file.MemberChanges() {
+					report.Entries = append(report.Entries, file.memberReportEntry(member))
+				}
+			}
+		},
+		func(dir *Directory) {
+			report.Entries = append(report.Entries, dir.reportEntry())
+		},
+		func(special *Special) {
+			report.Entries = append(report.Entries, special.reportEntry())
+		},
+	)
+
+	return report
+}
+
+// Finalize updates the summary and write errors of the report from the scan.
+func (report *Report) Finalize(scan *Scan) {
+	report.Summary = scan.Stats.Snapshot()
+	report.WriteErrors = scan.WriteErrors()
+}
+
+func (file *File) reportEntry() *ReportEntry {
+	entry := &ReportEntry{
+		Type:    ReportTypeFile,
+		Path:    file.Path,
+		Change:  file.Change.Name(),
+		Action:  string(file.Action),
+		ErrMsgs: slices.Clone(file.ErrMsgs),
+	}
+
+	if file.Change != Added {
+		entry.Old = &ReportState{
+			Size:      int64Ptr(file.Size),
+			Modified:  nonZeroTime(file.Modified),
+			Algorithm: file.Algorithm,
+			Digest:    file.Digest,
+		}
+	}
+	switch file.Change {
+	case Added, Changed, TimestampChanged, NoChange:
+		entry.New = &ReportState{
+			Size:      int64Ptr(file.Changed.Size),
+			Modified:  nonZeroTime(file.Changed.Modified),
+			Algorithm: file.Changed.Algorithm,
+			Digest:    file.Changed.Digest,
+		}
+	}
+
+	return entry
+}
+
+func (file *File) memberReportEntry(member *Member) *ReportEntry {
+	entry := &ReportEntry{
+		Type:   ReportTypeMember,
+		Path:   file.MemberPath(member),
+		Change: member.Change.Name(),
+	}
+
+	if recorded := file.GetMember(member.Name); recorded != nil {
+		entry.Old = &ReportState{
+			Size:      int64Ptr(recorded.Size),
+			Algorithm: file.Algorithm,
+			Digest:    recorded.Digest,
+		}
+	}
+	if member.Change != Removed {
+		entry.New = &ReportState{
+			Size:      int64Ptr(member.Size),
+			Algorithm: file.Changed.Algorithm,
+			Digest:    member.Digest,
+		}
+	}
+
+	return entry
+}
+
+func (dir *Directory) reportEntry() *ReportEntry {
+	entry := &ReportEntry{
+		Type:     ReportTypeDir,
+		Path:     dir.Path,
+		Change:   dir.Change.Name(),
+		Action:   string(dir.Action),
+		Verified: dir.Verified,
+		ErrMsgs:  slices.Clone(dir.ErrMsgs),
+	}
+
+	if dir.Change != Added {
+		entry.Old = &ReportState{
+			Algorithm: dir.Algorithm,
+			Digest:    dir.Digest,
+		}
+	}
+
+	return entry
+}
+
+func (special *Special) reportEntry() *ReportEntry {
+	entry := &ReportEntry{
+		Type:    ReportTypeSpecial,
+		Path:    special.Path,
+		Change:  special.Change.Name(),
+		Action:  string(special.Action),
+		ErrMsgs: slices.Clone(special.ErrMsgs),
+	}
+
+	if special.Change != Added {
+		entry.Old = &ReportState{
+			Type:     special.Type,
+			Modified: nonZeroTime(special.Modified),
+		}
+	}
+	switch special.Change {
+	case Added, Changed, TimestampChanged, NoChange:
+		entry.New = &ReportState{
+			Type:     special.Changed.Type,
+			Modified: nonZeroTime(special.Changed.Modified),
+		}
+	}
+
+	return entry
+}
+
+// int64Ptr returns a pointer to a copy of v, so that the report keeps the
+// values at the time of reporting.
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// WriteJSON writes the report as a single JSON object.
+func (report *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// WriteNDJSON writes the report as newline delimited JSON.
+// The first line is a header, followed by one line per entry and a summary
+// line at the end. Every line has a "kind" field to distinguish them.
+func (report *Report) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	// Write header.
+	err := enc.Encode(struct {
+		Kind    string `json:"kind"`
+		Version int    `json:"checkser_report"`
+		Root    string `json:"root"`
+	}{
+		Kind:    "header",
+		Version: report.Version,
+		Root:    report.Root,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write entries.
+	for _, entry := range report.Entries {
+		err := enc.Encode(struct {
+			Kind string `json:"kind"`
+			*ReportEntry
+		}{
+			Kind:        "entry",
+			ReportEntry: entry,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write summary.
+	return enc.Encode(struct {
+		Kind        string         `json:"kind"`
+		Summary     *StatsSnapshot `json:"summary"`
+		WriteErrors []string       `json:"write_errors,omitempty"`
+	}{
+		Kind:        "summary",
+		Summary:     report.Summary,
+		WriteErrors: report.WriteErrors,
+	})
+}
@@ -0,0 +1,104 @@
+package checkser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// scanTree scans and digests the tree at dir and calculates its changes.
+func scanTree(t *testing.T, dir string, cfg ScanConfig) *Scan {
+	t.Helper()
+
+	scan, err := New(dir, cfg)
+	if err != nil {
+		t.Fatalf("failed to create scan: %s", err)
+	}
+	if err := scan.Scan(); err != nil {
+		t.Fatalf("failed to scan: %s", err)
+	}
+	scan.DigestFiles()
+	scan.CalculateChangeStats()
+	return scan
+}
+
+// updateTree scans the tree at dir and writes its checksum files.
+func updateTree(t *testing.T, dir string, cfg ScanConfig) *Scan {
+	t.Helper()
+
+	scan := scanTree(t, dir, cfg)
+	scan.WriteChecksumFiles()
+	if errs := scan.WriteErrors(); len(errs) > 0 {
+		t.Fatalf("failed to write checksum files: %v", errs)
+	}
+	return scan
+}
+
+// assertUnchanged fails if the scan found changes or errors.
+func assertUnchanged(t *testing.T, scan *Scan) {
+	t.Helper()
+
+	total := &scan.Stats.Total
+	changes := total.Removed.Load() + total.Added.Load() + total.Changed.Load() +
+		total.TimestampChanged.Load() + total.Failed.Load()
+	errs := scan.Stats.FindingErrors.Load() + scan.Stats.DigestErrors.Load()
+	if changes > 0 || errs > 0 {
+		t.Fatalf("found %d changes and %d errors, expected none", changes, errs)
+	}
+}
+
+// findFile returns the file with the given name from the scan.
+func findFile(scan *Scan, name string) *File {
+	var found *File
+	scan.Iterate(func(file *File) {
+		if file.Name == name {
+			found = file
+		}
+	}, func(*Directory) {}, func(*Special) {})
+	return found
+}
+
+func writeTestFile(t *testing.T, name, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(data), 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+}
+
+func TestReportKeepsOldValues(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// Grow file and report before writing, as the CLI does.
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello, new world\n")
+	scan := scanTree(t, dir, ScanConfig{})
+	report := scan.Report()
+	scan.WriteChecksumFiles()
+	report.Finalize(scan)
+
+	var entry *ReportEntry
+	for _, e := range report.Entries {
+		if e.Type == ReportTypeFile && filepath.Base(e.Path) == "a.txt" {
+			entry = e
+		}
+	}
+	switch {
+	case entry == nil:
+		t.Fatal("file missing in report")
+	case entry.Change != Changed.Name():
+		t.Fatalf("change is %s, expected %s", entry.Change, Changed.Name())
+	case entry.Old == nil || entry.Old.Size == nil || *entry.Old.Size != 6:
+		t.Fatalf("old size is %v, expected 6", entry.Old)
+	case entry.New == nil || entry.New.Size == nil || *entry.New.Size != 17:
+		t.Fatalf("new size is %v, expected 17", entry.New)
+	case entry.Old.Digest == entry.New.Digest:
+		t.Fatal("old and new digest are equal")
+	}
+}
@@ -1,6 +1,7 @@
 package checkser
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -13,11 +14,21 @@ import (
 
 var ChecksumFilename = ".checkser.yml"
 
+// ErrManifestReadOnly is returned when writing checksum files with a manifest dir.
+var ErrManifestReadOnly = errors.New("checksum files cannot be written with a manifest dir")
+
 type Scan struct {
 	cfg ScanConfig
 
 	rootDir string
-	rootSum *Checksums
+	// manifestDir is where checksum files are loaded from, usually rootDir.
+	manifestDir string
+	rootSum     *Checksums
+	rootData    []byte
+	format      Format
+	scope       *pathScope
+
+	readLimit *rateLimiter
 
 	updatedAt time.Time
 	updatedBy string
@@ -39,6 +50,55 @@ type ScanConfig struct {
 	// By default only files that have changed in size or modification time are digested.
 	DigestAll bool
 
+	// Format sets the format checksum files are written in.
+	// Checksum files in other formats are converted when written.
+	// By default the format of the root checksum file is used for the whole tree.
+	Format Format
+
+	// Key enables keyed mode, if set.
+	// All file and checksum file digests are keyed with it, so that they
+	// cannot be forged without the key. The key should be kept outside of
+	// the tree. Digests that are not keyed are treated as changes.
+	Key []byte
+
+	// Canonical writes checksum files in a canonical form: entries are sorted
+	// by name and checksum files are only written if their content changed,
+	// so that identical state always produces byte-identical checksum files.
+	// The update time and host are only changed together with the content.
+	Canonical bool
+
+	// Paths limits the scan to the given paths, relative to the scanned dir.
+	// All other entries are kept as they are. The checksum files of the
+	// parent dirs of the given paths are updated up to the scanned dir.
+	Paths []string
+
+	// AppendOnly enables append-only mode for the scanned dir, or for the dirs
+	// given in Paths. Removed and changed entries are violations and never
+	// recorded. The mode is recorded in the checksum files and inherited by
+	// all sub dirs.
+	AppendOnly bool
+
+	// Archives indexes the members of zip and tar archives, so that changed
+	// members can be pinpointed. Members are recorded with their archive.
+	// Archives without recorded members are always digested.
+	Archives bool
+
+	// ManifestDir loads the checksum files from this dir instead of the
+	// scanned dir, eg. to verify a copy against the checksum files of its
+	// source. Checksum files cannot be written with a manifest dir.
+	ManifestDir string
+
+	// IgnoreModTime ignores modification times when detecting changes.
+	IgnoreModTime bool
+
+	// Policy defines how changes are handled, if set.
+	// Changes that violate the policy are not recorded when writing.
+	Policy *Policy
+
+	// ReadRateLimit limits the rate at which files are read for digesting, in
+	// bytes per second. Zero means no limit.
+	ReadRateLimit int64
+
 	// LiveUpdates enabled live update signalling using LiveUpdateSignal().
 	// As stats are atomic there might inconsistencies during operation.
 	LiveUpdates bool
@@ -58,16 +118,48 @@ func New(dir string, cfg ScanConfig) (*Scan, error) {
 	case !cfg.DefaultHash.IsValid():
 		return nil, ErrInvalidHashAlg
 	}
+	if cfg.Format != "" && !cfg.Format.IsValid() {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Key != nil {
+		if len(cfg.Key) < MinKeySize {
+			return nil, fmt.Errorf("%w: key must be at least %d bytes", ErrInvalidKey, MinKeySize)
+		}
+		cfg.DefaultHash = cfg.DefaultHash.Keyed()
+	}
 	if cfg.Rebuild {
 		cfg.DigestAll = true
 	}
+	scope, err := newPathScope(cfg.Paths)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Policy != nil {
+		if err := cfg.Policy.Check(); err != nil {
+			return nil, err
+		}
+		if cfg.Policy.Root == "" {
+			policy := *cfg.Policy
+			policy.Root = dir
+			cfg.Policy = &policy
+		}
+	}
+
+	manifestDir := dir
+	if cfg.ManifestDir != "" {
+		manifestDir = cfg.ManifestDir
+	}
 
 	// Create new scan.
 	scan := &Scan{
-		cfg:       cfg,
-		rootDir:   dir,
-		updatedAt: time.Now().Round(time.Second),
-		updatedBy: hostname,
+		cfg:         cfg,
+		rootDir:     dir,
+		manifestDir: manifestDir,
+		format:      cfg.Format,
+		scope:       scope,
+		readLimit:   newRateLimiter(cfg.ReadRateLimit),
+		updatedAt:   time.Now().Round(time.Second).UTC(),
+		updatedBy:   hostname,
 		Stats: &Stats{
 			live: cfg.LiveUpdates,
 		},
@@ -82,13 +174,30 @@ func New(dir string, cfg ScanConfig) (*Scan, error) {
 }
 
 func (scan *Scan) Scan() error {
+	// Refuse to handle keyed trees without key.
+	if scan.cfg.Key == nil {
+		if _, err := os.Stat(filepath.Join(scan.manifestDir, RootMACFilename)); err == nil {
+			return fmt.Errorf("%w: tree is in keyed mode", ErrKeyRequired)
+		}
+	}
+
 	// Scan root dir.
-	cs, err := scan.dir(scan.rootDir, nil)
+	cs, err := scan.dir(scan.rootDir, nil, scan.scope)
 	if err != nil {
 		return err
 	}
 	scan.rootSum = cs
 
+	// Enable append-only mode for the whole dir.
+	if scan.cfg.AppendOnly && scan.scope == nil {
+		scan.enableAppendOnly(cs)
+	}
+
+	// Use format of root checksum file for tree, if not set.
+	if scan.format == "" {
+		scan.format = cs.Format()
+	}
+
 	// Scan iteratively from here.
 	scan.dirs(cs)
 
@@ -97,22 +206,36 @@ func (scan *Scan) Scan() error {
 
 func (scan *Scan) dirs(cs *Checksums) {
 	for _, dir := range cs.Directories {
-		cs, err := scan.dir(dir.Path, dir)
+		// Skip dirs out of scope.
+		if !cs.inScope(dir.Name) {
+			continue
+		}
+		// With a manifest dir, dirs missing in the scanned dir are removed.
+		if scan.cfg.ManifestDir != "" && dir.Change == Removed {
+			continue
+		}
+
+		sub, err := scan.dir(dir.Path, dir, cs.scope.sub(dir.Name))
 		if err != nil {
 			dir.Change = Failed
 			dir.ErrMsgs = append(dir.ErrMsgs, fmt.Sprintf("failed to scan dir: %s", err))
 			scan.Stats.FindingErrors.Add(1)
 			scan.Stats.notify()
 		} else {
-			dir.Checksums = cs
+			dir.Checksums = sub
+
+			// Inherit append-only mode, or enable it for dirs given in paths.
+			if cs.AppendOnly || (scan.cfg.AppendOnly && cs.scope.sub(dir.Name) == nil) {
+				scan.enableAppendOnly(sub)
+			}
 
 			// Scan next level.
-			scan.dirs(cs)
+			scan.dirs(sub)
 		}
 	}
 }
 
-func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
+func (scan *Scan) dir(path string, pathDir *Directory, scope *pathScope) (*Checksums, error) {
 	stats := scan.Stats
 
 	// Read dir.
@@ -123,31 +246,45 @@ func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
 
 	// Load or create checksum file.
 	var cs *Checksums
-	idx := slices.IndexFunc(entries, func(entry os.DirEntry) bool {
-		return entry.Name() == ChecksumFilename
-	})
-	if idx >= 0 {
+	checksumData, err := scan.readChecksumFile(path, entries)
+	if err != nil {
+		return nil, err
+	}
+	if checksumData != nil {
 		stats.FoundChecksums.Add(1)
 
 		// Load checksum file from dir.
-		checksumData, err := os.ReadFile(filepath.Join(path, entries[idx].Name()))
-		if err != nil {
-			return nil, fmt.Errorf("failed to read checksum file %s: %w", entries[idx].Name(), err)
-		}
 		cs, err = LoadChecksums(checksumData)
 		if err != nil {
 			return nil, err
 		}
 
+		// Keep the data of the root checksum file for verifying signatures.
+		if pathDir == nil {
+			scan.rootData = checksumData
+		}
+		// Keep the data of all checksum files for comparing in canonical mode.
+		if scan.cfg.Canonical {
+			cs.data = checksumData
+		}
+
 		// If we have a path dir, check if the checksum matches.
 		if pathDir != nil && pathDir.Algorithm != "" {
-			dirChecksum, err := Hash(pathDir.Algorithm).Digest(checksumData)
+			dirHash := Hash(pathDir.Algorithm)
+			dirChecksum, err := dirHash.DigestWithKey(checksumData, scan.cfg.Key)
 			if err != nil {
 				return nil, err
 			}
-			if dirChecksum == pathDir.Digest {
+			switch {
+			case scan.cfg.Key != nil && !dirHash.IsKeyed():
+				pathDir.ErrMsgs = append(pathDir.ErrMsgs, "dir integrity not protected: checksum is not keyed")
+				pathDir.writeChecksums = true // Force re-write.
+
+				stats.FindingErrors.Add(1)
+				stats.notify()
+			case dirChecksum == pathDir.Digest:
 				pathDir.Verified = true
-			} else {
+			default:
 				pathDir.ErrMsgs = append(pathDir.ErrMsgs, "dir integrity violated: checksum did not match, possibly checkser was used only on subset of data")
 				pathDir.writeChecksums = true // Force re-write.
 
@@ -159,9 +296,10 @@ func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
 	} else {
 		// Create new checksum for this dir.
 		cs = &Checksums{
-			Version: 1,
+			Version: SchemaVersion,
 		}
 	}
+	cs.scope = scope
 
 	// Go through die entries and collect info.
 	for _, entry := range entries {
@@ -169,9 +307,16 @@ func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
 		cleanName := norm.NFC.String(entry.Name())
 
 		switch {
+		case !scope.includes(cleanName):
+			// Ignore entries out of scope.
+
 		case cleanName == ChecksumFilename:
 			// Ignore checksum file itself.
 
+		case cleanName == SignatureFilename && pathDir == nil,
+			cleanName == RootMACFilename && pathDir == nil:
+			// Ignore signature and MAC of root checksum file.
+
 		case entry.IsDir():
 			stats.FoundDirs.Add(1)
 			stats.notify()
@@ -217,6 +362,7 @@ func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
 							Modified  time.Time
 							Algorithm string
 							Digest    string
+							Members   []*Member
 						}{
 							Size:     info.Size(),
 							Modified: info.ModTime(),
@@ -236,6 +382,9 @@ func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
 				} else {
 					file.Path = filepath.Join(path, entry.Name())
 					file.AddChanges(info.Size(), info.ModTime())
+					if scan.cfg.IgnoreModTime && file.Change == TimestampChanged {
+						file.Change = NoChange
+					}
 				}
 			}
 
@@ -244,21 +393,7 @@ func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
 			stats.notify()
 
 			// Get special type.
-			var specialType string
-			switch {
-			case entry.Type()&fs.ModeSymlink != 0:
-				specialType = "symlink"
-			case entry.Type()&fs.ModeNamedPipe != 0:
-				specialType = "pipe"
-			case entry.Type()&fs.ModeSocket != 0:
-				specialType = "socket"
-			case entry.Type()&fs.ModeDevice != 0:
-				specialType = "device"
-			case entry.Type()&fs.ModeCharDevice != 0:
-				specialType = "chardevice"
-			default:
-				specialType = "other"
-			}
+			specialType := specialTypeOf(entry.Type())
 
 			specialFile := cs.GetSpecialFile(cleanName)
 			if specialFile == nil {
@@ -301,15 +436,71 @@ func (scan *Scan) dir(path string, pathDir *Directory) (*Checksums, error) {
 				} else {
 					specialFile.Path = filepath.Join(path, entry.Name())
 					specialFile.AddChanges(specialType, info.ModTime())
+					if scan.cfg.IgnoreModTime && specialFile.Change == TimestampChanged {
+						specialFile.Change = NoChange
+					}
 				}
 			}
 		}
 	}
 
+	cs.keepOutOfScope()
 	cs.CheckMissing(path)
 	return cs, nil
 }
 
+// readChecksumFile reads the checksum file of the dir at path, or from the
+// manifest dir, if set. Returns nil if there is no checksum file.
+func (scan *Scan) readChecksumFile(path string, entries []os.DirEntry) ([]byte, error) {
+	// Read from manifest dir.
+	if scan.manifestDir != scan.rootDir {
+		rel, err := filepath.Rel(scan.rootDir, path)
+		if err != nil {
+			return nil, err
+		}
+		manifestPath := filepath.Join(scan.manifestDir, rel, ChecksumFilename)
+		data, err := os.ReadFile(manifestPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, nil
+		case err != nil:
+			return nil, fmt.Errorf("failed to read checksum file %s: %w", manifestPath, err)
+		}
+		return data, nil
+	}
+
+	// Read from dir.
+	idx := slices.IndexFunc(entries, func(entry os.DirEntry) bool {
+		return entry.Name() == ChecksumFilename
+	})
+	if idx < 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(path, entries[idx].Name()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum file %s: %w", entries[idx].Name(), err)
+	}
+	return data, nil
+}
+
 func (scan *Scan) WriteErrors() []string {
 	return scan.writeErrs
 }
+
+// specialTypeOf returns the type name of a special file.
+func specialTypeOf(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode&fs.ModeCharDevice != 0:
+		return "chardevice"
+	default:
+		return "other"
+	}
+}
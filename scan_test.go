@@ -0,0 +1,85 @@
+package checkser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemovedDirFails(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "sub\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// A removed dir cannot be scanned and fails.
+	if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	scan := scanTree(t, dir, ScanConfig{})
+	switch {
+	case scan.Stats.Dirs.Failed.Load() != 1:
+		t.Fatalf("%d failed dirs, expected 1", scan.Stats.Dirs.Failed.Load())
+	case scan.Stats.FindingErrors.Load() != 1:
+		t.Fatalf("%d finding errors, expected 1", scan.Stats.FindingErrors.Load())
+	}
+}
+
+func TestManifestDir(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(src, "sub", "b.txt"), "corrupt me\n")
+	writeTestFile(t, filepath.Join(src, "gone", "c.txt"), "gone\n")
+	updateTree(t, src, ScanConfig{})
+
+	// Create a copy with a missing dir, an extra file and a corrupted file.
+	// Modification times are not preserved.
+	dst := t.TempDir()
+	writeTestFile(t, filepath.Join(dst, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dst, "sub", "b.txt"), "corrupt m3\n")
+	writeTestFile(t, filepath.Join(dst, "sub", "extra.txt"), "extra\n")
+
+	scan := scanTree(t, dst, ScanConfig{
+		DigestAll:     true,
+		ManifestDir:   src,
+		IgnoreModTime: true,
+	})
+	stats := scan.Stats
+	switch {
+	case stats.Files.Changed.Load() != 1:
+		t.Fatalf("%d changed files, expected 1", stats.Files.Changed.Load())
+	case stats.Files.Added.Load() != 1:
+		t.Fatalf("%d added files, expected 1", stats.Files.Added.Load())
+	case stats.Files.NoChange.Load() != 1:
+		t.Fatalf("%d unchanged files, expected 1", stats.Files.NoChange.Load())
+	case stats.Dirs.Removed.Load() != 1:
+		t.Fatalf("%d removed dirs, expected 1", stats.Dirs.Removed.Load())
+	case stats.FindingErrors.Load() != 0 || stats.DigestErrors.Load() != 0:
+		t.Fatalf("unexpected errors: %+v", stats.Snapshot())
+	}
+
+	// Checksum files must not be written to the copy.
+	scan.WriteChecksumFiles()
+	if len(scan.WriteErrors()) == 0 {
+		t.Fatal("checksum files written with manifest dir")
+	}
+	if fileExists(filepath.Join(dst, ChecksumFilename)) {
+		t.Fatal("checksum file written to the copy")
+	}
+
+	// An identical copy has no changes.
+	if err := os.RemoveAll(filepath.Join(dst, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dst, "sub", "b.txt"), "corrupt me\n")
+	writeTestFile(t, filepath.Join(dst, "gone", "c.txt"), "gone\n")
+	assertUnchanged(t, scanTree(t, dst, ScanConfig{
+		DigestAll:     true,
+		ManifestDir:   src,
+		IgnoreModTime: true,
+	}))
+}
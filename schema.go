@@ -0,0 +1,324 @@
+package checkser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SchemaVersion is the checksum file schema version written by this package.
+//
+// Schema versions:
+//
+//  1. Initial version.
+//  2. Adds the compat and generator fields. Timestamps are stored in UTC.
+//     Unknown fields are preserved when rewriting.
+//  3. Adds the append_only field. Checksum files are written with compat 2,
+//     unless in append-only mode, which older versions would not enforce.
+//  4. Adds the members field of archives. Checksum files with members are
+//     written with compat 4, as older versions would not update them.
+//
+// Files may declare in their compat field that they can safely be modified
+// by any reader supporting at least that schema version. This allows newer
+// versions to add fields that older versions preserve without understanding.
+// In binary checksum files, new fields get a new integer key, which is
+// preserved like unknown fields of the text formats when rewriting binary
+// files. Binary files with unknown integer keys are never converted to a text
+// format, as it cannot hold them.
+const SchemaVersion = 4
+
+// Compat versions written, depending on the features used.
+const (
+	baseCompat       = 2
+	appendOnlyCompat = 3
+	membersCompat    = 4
+)
+
+// Generator identifies the program that writes checksum files.
+// It is recorded in checksum files, so that users can be told which version
+// is needed to read a file in a newer schema.
+var Generator = "checkser"
+
+// VersionError is returned when a checksum file uses a schema version that is not supported.
+type VersionError struct {
+	Version   int
+	Compat    int
+	Generator string
+}
+
+func (e *VersionError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: checksum file uses schema version %d", ErrUnsupportedVersion, e.Version)
+	if e.Compat != e.Version {
+		fmt.Fprintf(&b, " (compatible with %d)", e.Compat)
+	}
+	fmt.Fprintf(&b, ", but this checkser only supports up to schema version %d", SchemaVersion)
+	if e.Generator != "" {
+		fmt.Fprintf(&b, " - it was written by %s, please upgrade to at least that version", e.Generator)
+	} else {
+		b.WriteString(" - please upgrade checkser")
+	}
+	return b.String()
+}
+
+func (e *VersionError) Unwrap() error {
+	return ErrUnsupportedVersion
+}
+
+// migrations holds the migrations from the schema version of the index to the next one.
+var migrations = map[int]func(cs *Checksums){
+	1: migrateV1,
+	2: migrateV2,
+	3: migrateV3,
+}
+
+// migrate migrates the checksums to the current schema version.
+func (cs *Checksums) migrate() error {
+	// Check if the checksums can be handled.
+	compat := cs.Compat
+	if compat <= 0 || compat > cs.Version {
+		compat = cs.Version
+	}
+	switch {
+	case cs.Version <= 0:
+		return ErrInvalidChecksumFile
+	case compat > SchemaVersion:
+		return &VersionError{
+			Version:   cs.Version,
+			Compat:    compat,
+			Generator: cs.Generator,
+		}
+	}
+
+	// Apply migrations.
+	for cs.Version < SchemaVersion {
+		migration, ok := migrations[cs.Version]
+		if !ok {
+			return fmt.Errorf("%w: no migration from schema version %d", ErrUnsupportedVersion, cs.Version)
+		}
+		migration(cs)
+		cs.Version++
+	}
+
+	return nil
+}
+
+// migrateV1 migrates from schema version 1 to 2.
+func migrateV1(cs *Checksums) {
+	// Store all timestamps in UTC.
+	cs.UpdatedAt = cs.UpdatedAt.UTC()
+	for _, file := range cs.Files {
+		file.Modified = file.Modified.UTC()
+	}
+	for _, special := range cs.Specials {
+		special.Modified = special.Modified.UTC()
+	}
+}
+
+// migrateV2 migrates from schema version 2 to 3.
+func migrateV2(cs *Checksums) {
+	// Nothing to migrate, append-only mode is opt-in.
+}
+
+// migrateV3 migrates from schema version 3 to 4.
+func migrateV3(cs *Checksums) {
+	// Nothing to migrate, indexing archives is opt-in.
+}
+
+// updateCompat raises the compat version to the schema version needed to
+// safely modify the checksums.
+func (cs *Checksums) updateCompat() {
+	compat := baseCompat
+	if cs.AppendOnly {
+		compat = appendOnlyCompat
+	}
+	if slices.ContainsFunc(cs.Files, func(file *File) bool {
+		return len(file.Members) > 0
+	}) {
+		compat = membersCompat
+	}
+	if cs.Compat < compat {
+		cs.Compat = compat
+	}
+}
+
+// Unknown fields are preserved in the Extra field of the checksum types.
+// YAML supports this natively with inline maps, JSON needs some help.
+
+type (
+	checksumsJSON Checksums
+	fileJSON      File
+	directoryJSON Directory
+	specialJSON   Special
+	memberJSON    Member
+)
+
+// MarshalJSON implements json.Marshaler.
+func (cs *Checksums) MarshalJSON() ([]byte, error) {
+	return marshalJSONWithExtra((*checksumsJSON)(cs), cs.Extra)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (cs *Checksums) UnmarshalJSON(data []byte) error {
+	return unmarshalJSONWithExtra(data, (*checksumsJSON)(cs), &cs.Extra)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (file *File) MarshalJSON() ([]byte, error) {
+	return marshalJSONWithExtra((*fileJSON)(file), file.Extra)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (file *File) UnmarshalJSON(data []byte) error {
+	return unmarshalJSONWithExtra(data, (*fileJSON)(file), &file.Extra)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (member *Member) MarshalJSON() ([]byte, error) {
+	return marshalJSONWithExtra((*memberJSON)(member), member.Extra)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (member *Member) UnmarshalJSON(data []byte) error {
+	return unmarshalJSONWithExtra(data, (*memberJSON)(member), &member.Extra)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (dir *Directory) MarshalJSON() ([]byte, error) {
+	return marshalJSONWithExtra((*directoryJSON)(dir), dir.Extra)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (dir *Directory) UnmarshalJSON(data []byte) error {
+	return unmarshalJSONWithExtra(data, (*directoryJSON)(dir), &dir.Extra)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (special *Special) MarshalJSON() ([]byte, error) {
+	return marshalJSONWithExtra((*specialJSON)(special), special.Extra)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (special *Special) UnmarshalJSON(data []byte) error {
+	return unmarshalJSONWithExtra(data, (*specialJSON)(special), &special.Extra)
+}
+
+func marshalJSONWithExtra(v any, extra map[string]any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	// Append extra fields in a stable order.
+	buf := bytes.NewBuffer(bytes.TrimSuffix(data, []byte("}")))
+	known := jsonFieldNames(reflect.TypeOf(v).Elem())
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		keyData, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		valueData, err := json.Marshal(extra[key])
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(keyData)
+		buf.WriteByte(':')
+		buf.Write(valueData)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func unmarshalJSONWithExtra(data []byte, v any, extra *map[string]any) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+
+	// Collect unknown fields.
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	known := jsonFieldNames(reflect.TypeOf(v).Elem())
+	for key, raw := range fields {
+		if known[key] {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if *extra == nil {
+			*extra = make(map[string]any)
+		}
+		(*extra)[key] = normalizeJSONValue(value)
+	}
+
+	return nil
+}
+
+// normalizeJSONValue converts json.Number values to int64 or float64, so
+// that they are correctly represented in other formats.
+func normalizeJSONValue(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]any:
+		for key, entry := range v {
+			v[key] = normalizeJSONValue(entry)
+		}
+		return v
+	case []any:
+		for i, entry := range v {
+			v[i] = normalizeJSONValue(entry)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+var jsonFieldNamesCache sync.Map
+
+// jsonFieldNames returns the names of all JSON fields of the given struct type.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	if names, ok := jsonFieldNamesCache.Load(t); ok {
+		return names.(map[string]bool) //nolint:forcetypeassert
+	}
+
+	names := make(map[string]bool)
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	jsonFieldNamesCache.Store(t, names)
+	return names
+}
@@ -0,0 +1,116 @@
+package checkser
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidPath is returned for paths outside of the scanned dir.
+var ErrInvalidPath = errors.New("invalid path")
+
+// pathScope limits a scan to some entries of a dir.
+// A nil scope includes everything.
+type pathScope struct {
+	// entries holds the included entries by name.
+	// The scope of an entry is nil if it is fully included.
+	entries map[string]*pathScope
+}
+
+// newPathScope creates a scope from the given paths, relative to the scanned dir.
+// Returns nil if all paths are included.
+func newPathScope(paths []string) (*pathScope, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	root := &pathScope{}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		switch {
+		case filepath.IsAbs(path),
+			path == "..",
+			strings.HasPrefix(path, ".."+string(filepath.Separator)):
+			return nil, fmt.Errorf("%w: %s is not within the scanned dir", ErrInvalidPath, path)
+		case path == ".":
+			// Everything is included.
+			return nil, nil
+		}
+
+		// Add path to scope.
+		scope := root
+		names := strings.Split(path, string(filepath.Separator))
+		for i, name := range names {
+			name = norm.NFC.String(name)
+			if scope.entries == nil {
+				scope.entries = make(map[string]*pathScope)
+			}
+			sub, ok := scope.entries[name]
+			if ok && sub == nil {
+				// Already fully included.
+				break
+			}
+			if i == len(names)-1 {
+				// Fully include last element.
+				scope.entries[name] = nil
+				break
+			}
+			if !ok {
+				sub = &pathScope{}
+				scope.entries[name] = sub
+			}
+			scope = sub
+		}
+	}
+
+	return root, nil
+}
+
+// includes returns whether the entry with the given name is in scope.
+func (scope *pathScope) includes(name string) bool {
+	if scope == nil {
+		return true
+	}
+	_, ok := scope.entries[name]
+	return ok
+}
+
+// sub returns the scope of the entry with the given name.
+func (scope *pathScope) sub(name string) *pathScope {
+	if scope == nil {
+		return nil
+	}
+	return scope.entries[name]
+}
+
+// inScope returns whether the entry with the given name was scanned.
+// Entries out of scope are kept as they are.
+func (cs *Checksums) inScope(name string) bool {
+	return cs.scope.includes(name)
+}
+
+// keepOutOfScope marks all entries out of scope as unchanged.
+func (cs *Checksums) keepOutOfScope() {
+	if cs.scope == nil {
+		return
+	}
+
+	for _, file := range cs.Files {
+		if !cs.inScope(file.Name) {
+			file.Change = NoChange
+		}
+	}
+	for _, dir := range cs.Directories {
+		if !cs.inScope(dir.Name) {
+			dir.Change = NoChange
+		}
+	}
+	for _, special := range cs.Specials {
+		if !cs.inScope(special.Name) {
+			special.Change = NoChange
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package checkser
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetedUpdate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "deeper", "b.txt"), "deeper\n")
+	writeTestFile(t, filepath.Join(dir, "other", "c.txt"), "other\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// Change everything, but only update some paths.
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello again\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "deeper", "b.txt"), "deeper again\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "deeper", "new.txt"), "new\n")
+	writeTestFile(t, filepath.Join(dir, "other", "c.txt"), "other again\n")
+	scan := updateTree(t, dir, ScanConfig{Paths: []string{filepath.Join("sub", "deeper", "b.txt"), "sub/deeper/new.txt"}})
+	switch {
+	case scan.Stats.Files.Changed.Load() != 1:
+		t.Fatalf("%d changed files in scope, expected 1", scan.Stats.Files.Changed.Load())
+	case scan.Stats.Files.Added.Load() != 1:
+		t.Fatalf("%d added files in scope, expected 1", scan.Stats.Files.Added.Load())
+	}
+
+	// Changes out of scope are still found and the chain is intact.
+	scan = scanTree(t, dir, ScanConfig{})
+	switch {
+	case scan.Stats.Files.Changed.Load() != 2:
+		t.Fatalf("%d changed files, expected 2", scan.Stats.Files.Changed.Load())
+	case scan.Stats.Files.Added.Load() != 0:
+		t.Fatalf("%d added files, expected none", scan.Stats.Files.Added.Load())
+	case findFile(scan, "b.txt").Change != NoChange:
+		t.Fatalf("updated file is %s", findFile(scan, "b.txt").Change.Name())
+	case scan.Stats.FindingErrors.Load() != 0 || scan.Stats.Dirs.Failed.Load() != 0:
+		t.Fatalf("unexpected errors: %+v", scan.Stats.Snapshot())
+	}
+	chain, err := FindChain(filepath.Join(dir, "sub", "deeper"), nil, nil)
+	if err != nil || chain.Err() != nil {
+		t.Fatalf("chain broken after targeted update: %v %v", err, chain.Err())
+	}
+
+	// The updated subtree belongs to the tree.
+	if root, err := FindTreeRoot(filepath.Join(dir, "sub", "deeper")); err != nil || root != dir {
+		t.Fatalf("tree root is %s (%v), expected %s", root, err, dir)
+	}
+}
+
+func TestTargetedUpdateInvalidPath(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, path := range []string{"..", filepath.Join("..", "x"), filepath.Join(dir, "a.txt")} {
+		if _, err := New(dir, ScanConfig{Paths: []string{path}}); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("unexpected error for path %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package checkser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignatureFilename is the name of the signature file of the root checksum file.
+var SignatureFilename = ".checkser.sig"
+
+// SigningKey is a secret key for signing root checksum files.
+type SigningKey struct {
+	minisign *minisignSecretKey
+	ssh      *sshSecretKey
+}
+
+// ParseSigningKey parses a minisign or OpenSSH secret key.
+// If the key is encrypted, password is called to get the password.
+func ParseSigningKey(data []byte, password func() ([]byte, error)) (*SigningKey, error) {
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		key, err := parseSSHSecretKey(data, password)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{ssh: key}, nil
+	}
+
+	key, err := parseMinisignSecretKey(data, password)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{minisign: key}, nil
+}
+
+// Sign signs the given data and returns the signature in the format of the key.
+func (key *SigningKey) Sign(data []byte) ([]byte, error) {
+	switch {
+	case key.minisign != nil:
+		return key.minisign.sign(data, fmt.Sprintf("timestamp:%d\tfile:%s", time.Now().Unix(), ChecksumFilename)), nil
+	case key.ssh != nil:
+		return key.ssh.sign(data)
+	default:
+		return nil, ErrInvalidKey
+	}
+}
+
+// TrustedKeys holds the public keys trusted to sign root checksum files.
+type TrustedKeys struct {
+	keys []trustedKey
+}
+
+type trustedKey interface {
+	verify(data, sig []byte) error
+	String() string
+}
+
+// LoadTrustedKeys parses a trusted keys file.
+// Every line holds either a minisign public key or an SSH public key in the
+// authorized_keys format. Empty lines, comments starting with "#" and
+// minisign "untrusted comment:" lines are ignored.
+func LoadTrustedKeys(data []byte) (*TrustedKeys, error) {
+	tk := &TrustedKeys{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "",
+			strings.HasPrefix(line, "#"),
+			strings.HasPrefix(line, minisignUntrustedComment):
+			continue
+		}
+
+		// Try minisign key first, as they are a single base64 string.
+		if key, err := parseMinisignPublicKey(line); err == nil {
+			tk.keys = append(tk.keys, key)
+			continue
+		}
+		key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%w on line %d: %w", ErrInvalidKey, i+1, err)
+		}
+		tk.keys = append(tk.keys, &sshPublicKey{key: key, comment: comment})
+	}
+
+	if len(tk.keys) == 0 {
+		return nil, fmt.Errorf("%w: no trusted keys found", ErrInvalidKey)
+	}
+	return tk, nil
+}
+
+// Verify checks if the signature over data was made by any of the trusted keys.
+// It returns a description of the key that made the signature.
+func (tk *TrustedKeys) Verify(data, sig []byte) (signedBy string, err error) {
+	sshSig := bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN "+sshsigPEMType))
+	for _, key := range tk.keys {
+		// Only check keys matching the signature format.
+		if _, sshKey := key.(*sshPublicKey); sshKey != sshSig {
+			continue
+		}
+
+		err := key.verify(data, sig)
+		switch {
+		case err == nil:
+			return key.String(), nil
+		case errors.Is(err, ErrUntrustedSignature):
+			// Try next key.
+		default:
+			return "", err
+		}
+	}
+	return "", ErrUntrustedSignature
+}
+
+// SignRootChecksumFile signs the root checksum file in dir and writes the signature file.
+func SignRootChecksumFile(dir string, key *SigningKey) error {
+	data, err := os.ReadFile(filepath.Join(dir, ChecksumFilename))
+	if err != nil {
+		return fmt.Errorf("failed to read root checksum file: %w", err)
+	}
+	sig, err := key.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, SignatureFilename), sig, 0o0644)
+	if err != nil {
+		return fmt.Errorf("failed to write signature file: %w", err)
+	}
+	return nil
+}
+
+// VerifyRootChecksumFile verifies the signature of the root checksum file in dir.
+func VerifyRootChecksumFile(dir string, tk *TrustedKeys) (signedBy string, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, ChecksumFilename))
+	if err != nil {
+		return "", fmt.Errorf("failed to read root checksum file: %w", err)
+	}
+	return verifySignatureFile(dir, data, tk)
+}
+
+// VerifySignature verifies the signature of the root checksum file as it was
+// loaded by the scan.
+func (scan *Scan) VerifySignature(tk *TrustedKeys) (signedBy string, err error) {
+	if scan.rootData == nil {
+		return "", fmt.Errorf("%w: no root checksum file", ErrMissingSignature)
+	}
+	return verifySignatureFile(scan.manifestDir, scan.rootData, tk)
+}
+
+func verifySignatureFile(dir string, data []byte, tk *TrustedKeys) (signedBy string, err error) {
+	sig, err := os.ReadFile(filepath.Join(dir, SignatureFilename))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", ErrMissingSignature
+		}
+		return "", fmt.Errorf("failed to read signature file: %w", err)
+	}
+	return tk.Verify(data, sig)
+}
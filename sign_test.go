@@ -0,0 +1,88 @@
+package checkser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignatureDetectsTampering(t *testing.T) {
+	t.Parallel()
+
+	// Create and sign tree.
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	updateTree(t, dir, ScanConfig{})
+
+	secretKey, publicKey, err := GenerateMinisignKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := ParseSigningKey(secretKey, func() ([]byte, error) {
+		return nil, errors.New("unexpected password request")
+	})
+	if err != nil {
+		t.Fatalf("failed to parse signing key: %s", err)
+	}
+	tk, err := LoadTrustedKeys(publicKey)
+	if err != nil {
+		t.Fatalf("failed to load trusted keys: %s", err)
+	}
+	if err := SignRootChecksumFile(dir, sk); err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+	if _, err := VerifyRootChecksumFile(dir, tk); err != nil {
+		t.Fatalf("valid signature failed to verify: %s", err)
+	}
+
+	// Tamper with the root checksum file.
+	checksumFile := filepath.Join(dir, ChecksumFilename)
+	data, err := os.ReadFile(checksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-2] ^= 1
+	if err := os.WriteFile(checksumFile, tampered, 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	if _, err := VerifyRootChecksumFile(dir, tk); err == nil {
+		t.Fatal("tampered checksum file passed verification")
+	}
+
+	// Tamper with the signature.
+	if err := os.WriteFile(checksumFile, data, 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	sigFile := filepath.Join(dir, SignatureFilename)
+	sig, err := os.ReadFile(sigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tk.Verify(data, sig); err != nil {
+		t.Fatalf("restored checksum file failed to verify: %s", err)
+	}
+	if _, err := tk.Verify(data[:len(data)-1], sig); err == nil {
+		t.Fatal("truncated checksum file passed verification")
+	}
+	if err := os.Remove(sigFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyRootChecksumFile(dir, tk); err == nil {
+		t.Fatal("missing signature passed verification")
+	}
+
+	// Keys that are not trusted must fail.
+	_, otherPublicKey, err := GenerateMinisignKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTK, err := LoadTrustedKeys(otherPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherTK.Verify(data, sig); err == nil {
+		t.Fatal("signature passed verification with untrusted key")
+	}
+}
@@ -0,0 +1,194 @@
+package checkser
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH signature format, see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig.
+// Signatures are compatible with `ssh-keygen -Y sign -n file`.
+
+const (
+	sshsigMagic     = "SSHSIG"
+	sshsigVersion   = 1
+	sshsigNamespace = "file"
+	sshsigPEMType   = "SSH SIGNATURE"
+)
+
+type sshsigBlob struct {
+	Magic     [6]byte
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+type sshsigSignedData struct {
+	Magic     [6]byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Hash      []byte
+}
+
+type sshPublicKey struct {
+	key     ssh.PublicKey
+	comment string
+}
+
+type sshSecretKey struct {
+	signer ssh.Signer
+}
+
+func parseSSHSecretKey(data []byte, password func() ([]byte, error)) (*sshSecretKey, error) {
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		var missingErr *ssh.PassphraseMissingError
+		if !errors.As(err, &missingErr) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+		}
+		if password == nil {
+			return nil, ErrPasswordRequired
+		}
+		pw, err := password()
+		if err != nil {
+			return nil, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, pw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPassword, err)
+		}
+	}
+
+	return &sshSecretKey{signer: signer}, nil
+}
+
+func (key *sshSecretKey) sign(data []byte) ([]byte, error) {
+	hashed := sha512.Sum512(data)
+	signedData := sshsigPack(sshsigSignedData{
+		Namespace: sshsigNamespace,
+		HashAlg:   "sha512",
+		Hash:      hashed[:],
+	})
+
+	// Sign, using SHA512 for RSA keys.
+	var signature *ssh.Signature
+	var err error
+	if key.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		algSigner, ok := key.signer.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, fmt.Errorf("%w: rsa key cannot sign with sha512", ErrInvalidKey)
+		}
+		signature, err = algSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = key.signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := sshsigBlob{
+		Version:   sshsigVersion,
+		PublicKey: key.signer.PublicKey().Marshal(),
+		Namespace: sshsigNamespace,
+		HashAlg:   "sha512",
+		Signature: ssh.Marshal(signature),
+	}
+	copy(blob.Magic[:], sshsigMagic)
+
+	return sshsigArmor(ssh.Marshal(blob)), nil
+}
+
+func (key *sshPublicKey) verify(data, sig []byte) error {
+	// Parse signature.
+	block, _ := pem.Decode(sig)
+	if block == nil || block.Type != sshsigPEMType {
+		return fmt.Errorf("%w: malformed ssh signature", ErrInvalidSignature)
+	}
+	var blob sshsigBlob
+	if err := ssh.Unmarshal(block.Bytes, &blob); err != nil {
+		return fmt.Errorf("%w: malformed ssh signature: %w", ErrInvalidSignature, err)
+	}
+	switch {
+	case string(blob.Magic[:]) != sshsigMagic:
+		return fmt.Errorf("%w: malformed ssh signature", ErrInvalidSignature)
+	case blob.Version != sshsigVersion:
+		return fmt.Errorf("%w: unsupported ssh signature version %d", ErrInvalidSignature, blob.Version)
+	case blob.Namespace != sshsigNamespace:
+		return fmt.Errorf("%w: unexpected ssh signature namespace %q", ErrInvalidSignature, blob.Namespace)
+	}
+
+	// Check key.
+	if !bytes.Equal(blob.PublicKey, key.key.Marshal()) {
+		return ErrUntrustedSignature
+	}
+
+	// Verify signature.
+	var hashed []byte
+	switch blob.HashAlg {
+	case "sha256":
+		sum := sha256.Sum256(data)
+		hashed = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(data)
+		hashed = sum[:]
+	default:
+		return fmt.Errorf("%w: unsupported ssh signature hash %q", ErrInvalidSignature, blob.HashAlg)
+	}
+	signedData := sshsigPack(sshsigSignedData{
+		Namespace: blob.Namespace,
+		Reserved:  blob.Reserved,
+		HashAlg:   blob.HashAlg,
+		Hash:      hashed,
+	})
+	signature := new(ssh.Signature)
+	if err := ssh.Unmarshal(blob.Signature, signature); err != nil {
+		return fmt.Errorf("%w: malformed ssh signature: %w", ErrInvalidSignature, err)
+	}
+	// Like ssh-keygen, refuse RSA signatures with SHA1.
+	if key.key.Type() == ssh.KeyAlgoRSA &&
+		signature.Format != ssh.KeyAlgoRSASHA256 && signature.Format != ssh.KeyAlgoRSASHA512 {
+		return fmt.Errorf("%w: unsupported ssh signature algorithm %q", ErrInvalidSignature, signature.Format)
+	}
+	if err := key.key.Verify(signedData, signature); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
+func (key *sshPublicKey) String() string {
+	if key.comment != "" {
+		return fmt.Sprintf("ssh key %s (%s)", ssh.FingerprintSHA256(key.key), key.comment)
+	}
+	return "ssh key " + ssh.FingerprintSHA256(key.key)
+}
+
+func sshsigPack(signedData sshsigSignedData) []byte {
+	copy(signedData.Magic[:], sshsigMagic)
+	return ssh.Marshal(signedData)
+}
+
+func sshsigArmor(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("-----BEGIN " + sshsigPEMType + "-----\n")
+	for len(encoded) > 70 {
+		buf.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	buf.WriteString(encoded + "\n")
+	buf.WriteString("-----END " + sshsigPEMType + "-----\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,90 @@
+package checkser
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHSignature(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("checksums\n")
+	for _, privateKey := range []any{edKey, rsaKey} {
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk := &sshSecretKey{signer: signer}
+		pk := &sshPublicKey{key: signer.PublicKey()}
+
+		sig, err := sk.sign(data)
+		if err != nil {
+			t.Fatalf("%s: failed to sign: %s", pk, err)
+		}
+		if err := pk.verify(data, sig); err != nil {
+			t.Fatalf("%s: valid signature failed to verify: %s", pk, err)
+		}
+		if err := pk.verify([]byte("checksumz\n"), sig); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: tampered data: error is %v, expected %s", pk, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestSSHSignatureRefusesSHA1(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := &sshPublicKey{key: signer.PublicKey()}
+
+	// Create a valid signature with the legacy ssh-rsa (SHA1) algorithm.
+	data := []byte("checksums\n")
+	hashed := sha512.Sum512(data)
+	signedData := sshsigPack(sshsigSignedData{
+		Namespace: sshsigNamespace,
+		HashAlg:   "sha512",
+		Hash:      hashed[:],
+	})
+	signature, err := signer.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.PublicKey().Verify(signedData, signature); err != nil {
+		t.Fatalf("legacy signature is not valid: %s", err)
+	}
+	blob := sshsigBlob{
+		Version:   sshsigVersion,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: sshsigNamespace,
+		HashAlg:   "sha512",
+		Signature: ssh.Marshal(signature),
+	}
+	copy(blob.Magic[:], sshsigMagic)
+	sig := pem.EncodeToMemory(&pem.Block{Type: sshsigPEMType, Bytes: ssh.Marshal(blob)})
+
+	if err := pk.verify(data, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("error is %v, expected %s", err, ErrInvalidSignature)
+	}
+}
@@ -12,6 +12,7 @@ type Stats struct {
 	DigestFiles   atomic.Uint64
 	DigestSkipped atomic.Uint64
 	DigestErrors  atomic.Uint64
+	DigestBytes   atomic.Uint64
 
 	// Changes
 	Files   ChangeSet
@@ -19,6 +20,23 @@ type Stats struct {
 	Special ChangeSet
 	Total   ChangeSet
 
+	// Flagged holds the changes that need attention: all changes without a
+	// policy, or the changes with the warn or fail action.
+	Flagged ChangeSet
+
+	// Policy
+	PolicyIgnored  atomic.Uint64
+	PolicyAccepted atomic.Uint64
+	PolicyWarnings atomic.Uint64
+	// PolicyViolations includes the violations of append-only mode.
+	PolicyViolations atomic.Uint64
+
+	// AppendOnlyEnabled counts the dirs append-only mode is newly enabled for.
+	AppendOnlyEnabled atomic.Uint64
+
+	// ArchivesIndexed counts the unchanged archives whose members are newly indexed.
+	ArchivesIndexed atomic.Uint64
+
 	WriteToDo   atomic.Uint64
 	WriteDone   atomic.Uint64
 	WriteErrors atomic.Uint64
@@ -52,13 +70,52 @@ func (scan *Scan) LiveUpdateSignal() <-chan struct{} {
 }
 
 func (scan *Scan) CalculateChangeStats() {
+	scan.applyPolicy(scan.rootSum)
 	scan.calcStats(scan.rootSum)
 }
 
+// add counts the change.
+func (cs *ChangeSet) add(change Change) {
+	switch change {
+	case Removed:
+		cs.Removed.Add(1)
+	case Added:
+		cs.Added.Add(1)
+	case Changed:
+		cs.Changed.Add(1)
+	case TimestampChanged:
+		cs.TimestampChanged.Add(1)
+	case NoChange:
+		cs.NoChange.Add(1)
+	case Failed:
+		cs.Failed.Add(1)
+	}
+}
+
+// countPolicy counts the change by its policy action.
+func (s *Stats) countPolicy(change Change, action Action) {
+	switch action {
+	case ActionIgnore:
+		s.PolicyIgnored.Add(1)
+	case ActionAccept:
+		s.PolicyAccepted.Add(1)
+	case ActionWarn:
+		s.PolicyWarnings.Add(1)
+	case ActionFail:
+		s.PolicyViolations.Add(1)
+	}
+	if action == "" || action.Flagged() {
+		s.Flagged.add(change)
+	}
+}
+
 func (scan *Scan) calcStats(cs *Checksums) {
 	stats := scan.Stats
 
 	for _, file := range cs.Files {
+		if !cs.inScope(file.Name) {
+			continue
+		}
 		switch file.Change {
 		case Removed:
 			stats.Files.Removed.Add(1)
@@ -79,9 +136,13 @@ func (scan *Scan) calcStats(cs *Checksums) {
 			stats.Files.Failed.Add(1)
 			stats.Total.Failed.Add(1)
 		}
+		stats.countPolicy(file.Change, file.Action)
 	}
 
 	for _, dir := range cs.Directories {
+		if !cs.inScope(dir.Name) {
+			continue
+		}
 		switch dir.Change {
 		case Removed:
 			stats.Dirs.Removed.Add(1)
@@ -102,9 +163,13 @@ func (scan *Scan) calcStats(cs *Checksums) {
 			stats.Dirs.Failed.Add(1)
 			stats.Total.Failed.Add(1)
 		}
+		stats.countPolicy(dir.Change, dir.Action)
 	}
 
 	for _, special := range cs.Specials {
+		if !cs.inScope(special.Name) {
+			continue
+		}
 		switch special.Change {
 		case Removed:
 			stats.Special.Removed.Add(1)
@@ -125,6 +190,7 @@ func (scan *Scan) calcStats(cs *Checksums) {
 			stats.Special.Failed.Add(1)
 			stats.Total.Failed.Add(1)
 		}
+		stats.countPolicy(special.Change, special.Action)
 	}
 
 	for _, dir := range cs.Directories {
@@ -133,3 +199,91 @@ func (scan *Scan) calcStats(cs *Checksums) {
 		}
 	}
 }
+
+// StatsSnapshot is a point-in-time copy of Stats.
+type StatsSnapshot struct {
+	FoundDirs      uint64 `json:"found_dirs"`
+	FoundFiles     uint64 `json:"found_files"`
+	FoundSpecial   uint64 `json:"found_special"`
+	FoundChecksums uint64 `json:"found_checksums"`
+	FindingErrors  uint64 `json:"finding_errors"`
+
+	DigestFiles   uint64 `json:"digest_files"`
+	DigestSkipped uint64 `json:"digest_skipped"`
+	DigestErrors  uint64 `json:"digest_errors"`
+	DigestBytes   uint64 `json:"digest_bytes"`
+
+	Files   ChangeSetSnapshot `json:"files"`
+	Dirs    ChangeSetSnapshot `json:"dirs"`
+	Special ChangeSetSnapshot `json:"special"`
+	Total   ChangeSetSnapshot `json:"total"`
+	Flagged ChangeSetSnapshot `json:"flagged"`
+
+	PolicyIgnored    uint64 `json:"policy_ignored,omitempty"`
+	PolicyAccepted   uint64 `json:"policy_accepted,omitempty"`
+	PolicyWarnings   uint64 `json:"policy_warnings,omitempty"`
+	PolicyViolations uint64 `json:"policy_violations,omitempty"`
+
+	AppendOnlyEnabled uint64 `json:"append_only_enabled,omitempty"`
+	ArchivesIndexed   uint64 `json:"archives_indexed,omitempty"`
+
+	WriteToDo   uint64 `json:"write_todo"`
+	WriteDone   uint64 `json:"write_done"`
+	WriteErrors uint64 `json:"write_errors"`
+}
+
+// ChangeSetSnapshot is a point-in-time copy of ChangeSet.
+type ChangeSetSnapshot struct {
+	Removed          uint64 `json:"removed"`
+	Added            uint64 `json:"added"`
+	Changed          uint64 `json:"changed"`
+	TimestampChanged uint64 `json:"timestamp_changed"`
+	NoChange         uint64 `json:"no_change"`
+	Failed           uint64 `json:"failed"`
+}
+
+// Snapshot returns a copy of the current stats.
+func (s *Stats) Snapshot() *StatsSnapshot {
+	return &StatsSnapshot{
+		FoundDirs:      s.FoundDirs.Load(),
+		FoundFiles:     s.FoundFiles.Load(),
+		FoundSpecial:   s.FoundSpecial.Load(),
+		FoundChecksums: s.FoundChecksums.Load(),
+		FindingErrors:  s.FindingErrors.Load(),
+
+		DigestFiles:   s.DigestFiles.Load(),
+		DigestSkipped: s.DigestSkipped.Load(),
+		DigestErrors:  s.DigestErrors.Load(),
+		DigestBytes:   s.DigestBytes.Load(),
+
+		Files:   s.Files.Snapshot(),
+		Dirs:    s.Dirs.Snapshot(),
+		Special: s.Special.Snapshot(),
+		Total:   s.Total.Snapshot(),
+		Flagged: s.Flagged.Snapshot(),
+
+		PolicyIgnored:    s.PolicyIgnored.Load(),
+		PolicyAccepted:   s.PolicyAccepted.Load(),
+		PolicyWarnings:   s.PolicyWarnings.Load(),
+		PolicyViolations: s.PolicyViolations.Load(),
+
+		AppendOnlyEnabled: s.AppendOnlyEnabled.Load(),
+		ArchivesIndexed:   s.ArchivesIndexed.Load(),
+
+		WriteToDo:   s.WriteToDo.Load(),
+		WriteDone:   s.WriteDone.Load(),
+		WriteErrors: s.WriteErrors.Load(),
+	}
+}
+
+// Snapshot returns a copy of the current change set.
+func (cs *ChangeSet) Snapshot() ChangeSetSnapshot {
+	return ChangeSetSnapshot{
+		Removed:          cs.Removed.Load(),
+		Added:            cs.Added.Load(),
+		Changed:          cs.Changed.Load(),
+		TimestampChanged: cs.TimestampChanged.Load(),
+		NoChange:         cs.NoChange.Load(),
+		Failed:           cs.Failed.Load(),
+	}
+}
@@ -0,0 +1,798 @@
+package checkser
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Tar streams hold a tree together with its checksum files. The checksum file
+// of every dir is placed directly after the dir and before all its members,
+// so that a tar stream can be verified in a single pass without extracting
+// it. The checksum files are verified against their parents, so that the
+// chain of checksum files is intact within the stream.
+
+// Errors.
+var (
+	ErrNoRootChecksumFile       = errors.New("no root checksum file")
+	ErrMemberBeforeChecksumFile = errors.New("member precedes the checksum file of its dir in the tar stream")
+)
+
+// TarConfig configures writing and verifying tar streams.
+type TarConfig struct {
+	// DefaultHash sets the hash for the digest of the tar stream and for
+	// files that are not recorded.
+	DefaultHash Hash
+
+	// Key is needed for keyed trees.
+	Key []byte
+
+	// TrustedKeys verifies the signature of the root checksum file, if set.
+	// Only used when verifying.
+	TrustedKeys *TrustedKeys
+
+	// ManifestDir loads the checksum files from this dir instead of the
+	// checksum files embedded in the tar stream. Only used when verifying.
+	ManifestDir string
+
+	// IgnoreModTime ignores modification times. Only used when verifying.
+	IgnoreModTime bool
+}
+
+// TarResult holds the result of writing or verifying a tar stream.
+type TarResult struct {
+	Files         uint64
+	Dirs          uint64
+	Specials      uint64
+	ChecksumFiles uint64
+	// Bytes is the size of all files.
+	Bytes uint64
+
+	// Algorithm and Digest are the digest of the whole tar stream.
+	// Only set when writing.
+	Algorithm string
+	Digest    string
+
+	// Signed is set if the root checksum file has a signature, even if it
+	// was not verified. Only set when verifying.
+	Signed bool
+
+	// Entries holds all entries that differ from the checksum files.
+	Entries []*TarEntry
+	// Errors holds the errors of the root checksum file, eg. if its MAC or
+	// signature could not be verified.
+	Errors []error
+}
+
+// TarEntry describes an entry that differs from the checksum files.
+// Paths are relative to the tree root, separated by slashes.
+type TarEntry struct {
+	Type    string
+	Path    string
+	Change  Change
+	ErrMsgs []string
+}
+
+// Count returns the number of entries with the given change.
+func (result *TarResult) Count(change Change) (n int) {
+	for _, entry := range result.Entries {
+		if entry.Change == change {
+			n++
+		}
+	}
+	return n
+}
+
+func (result *TarResult) add(entryType, rel string, change Change, err error) {
+	entry := &TarEntry{
+		Type:   entryType,
+		Path:   rel,
+		Change: change,
+	}
+	if err != nil {
+		entry.ErrMsgs = []string{err.Error()}
+	}
+	result.Entries = append(result.Entries, entry)
+}
+
+func (result *TarResult) sortEntries() {
+	slices.SortStableFunc(result.Entries, func(a, b *TarEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+}
+
+func (cfg *TarConfig) hash() (Hash, error) {
+	h := cfg.DefaultHash
+	switch {
+	case string(h) == "":
+		h = DefaultHash
+	case !h.IsValid():
+		return "", ErrInvalidHashAlg
+	}
+	if cfg.Key != nil {
+		h = h.Keyed()
+	}
+	return h, nil
+}
+
+// WriteTar writes the tree at dir with all its checksum files as tar stream
+// to w. All files are digested while they are written and compared with the
+// checksum files; entries that differ are listed in the result, but still
+// written. The whole stream is digested too.
+// The returned error is set if the stream could not be written completely.
+func WriteTar(w io.Writer, dir string, cfg TarConfig) (*TarResult, error) {
+	h, err := cfg.hash()
+	if err != nil {
+		return nil, err
+	}
+
+	// Load and verify root checksum file.
+	if cfg.Key == nil {
+		if _, err := os.Stat(filepath.Join(dir, RootMACFilename)); err == nil {
+			return nil, fmt.Errorf("%w: tree is in keyed mode", ErrKeyRequired)
+		}
+	}
+	data, err := readChecksumFile(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root checksum file: %w", err)
+	}
+	if cfg.Key != nil {
+		if err := verifyRootMAC(dir, data, cfg.Key); err != nil {
+			return nil, err
+		}
+	}
+	cs, err := verifiedChecksums(dir, data, nil, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Digest the whole stream while writing.
+	hasher, err := h.newHasher(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer hasher.Reset() // Internal state may leak data if kept in memory.
+
+	t := &tarWriter{
+		cfg:    cfg,
+		tw:     tar.NewWriter(io.MultiWriter(w, hasher)),
+		result: &TarResult{},
+	}
+	if err := t.dir("", dir, cs, data); err != nil {
+		return t.result, err
+	}
+	if err := t.tw.Close(); err != nil {
+		return t.result, err
+	}
+
+	t.result.sortEntries()
+	t.result.Algorithm = string(h)
+	t.result.Digest = hex.EncodeToString(hasher.Sum(nil))
+	return t.result, nil
+}
+
+type tarWriter struct {
+	cfg    TarConfig
+	tw     *tar.Writer
+	result *TarResult
+}
+
+// writeHeader writes the header for the entry at rel.
+func (t *tarWriter) writeHeader(hdr *tar.Header, rel string) error {
+	hdr.Name = rel
+	if hdr.Typeflag == tar.TypeDir {
+		hdr.Name += "/"
+	}
+	// Keep precise modification times, but no access and change times.
+	hdr.Format = tar.FormatPAX
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	return t.tw.WriteHeader(hdr)
+}
+
+// writeData writes a file with the given data, eg. a checksum file.
+func (t *tarWriter) writeData(rel, name string, data []byte) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Size = int64(len(data))
+	if err := t.writeHeader(hdr, rel); err != nil {
+		return err
+	}
+	_, err = t.tw.Write(data)
+	return err
+}
+
+// dir writes the dir with its checksum file first, followed by all its
+// members. Differences are only reported if the dir has checksums.
+func (t *tarWriter) dir(rel, name string, cs *Checksums, data []byte) error {
+	// Write dir.
+	if rel != "" {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if err := t.writeHeader(hdr, rel); err != nil {
+			return err
+		}
+	}
+
+	// Write checksum file before all members.
+	if data != nil {
+		if err := t.writeData(path.Join(rel, ChecksumFilename), filepath.Join(name, ChecksumFilename), data); err != nil {
+			return err
+		}
+		t.result.ChecksumFiles++
+	}
+	if rel == "" {
+		for _, filename := range []string{RootMACFilename, SignatureFilename} {
+			fileData, err := os.ReadFile(filepath.Join(name, filename))
+			if err != nil {
+				continue
+			}
+			if err := t.writeData(filename, filepath.Join(name, filename), fileData); err != nil {
+				return err
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		t.result.add(ReportTypeDir, rel, Failed, err)
+		return nil
+	}
+	report := cs != nil
+	if cs == nil {
+		cs = &Checksums{}
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		entryName := norm.NFC.String(entry.Name())
+		entryRel := path.Join(rel, entryName)
+		entryPath := filepath.Join(name, entry.Name())
+
+		switch {
+		case entryName == ChecksumFilename:
+			// Already written.
+			continue
+		case rel == "" && (entryName == RootMACFilename || entryName == SignatureFilename):
+			// Already written.
+			continue
+		}
+		seen[entryName] = true
+
+		switch {
+		case entry.IsDir():
+			// Load and verify checksums of sub dir.
+			var sub *Checksums
+			var subData []byte
+			recorded := cs.GetDir(entryName)
+			switch {
+			case !report:
+			case recorded == nil:
+				t.result.add(ReportTypeDir, entryRel, Added, nil)
+			default:
+				subData, err = readChecksumFile(entryPath)
+				if err == nil {
+					sub, err = verifiedChecksums(entryRel, subData, recorded, t.cfg.Key)
+				}
+				if err != nil {
+					t.result.add(ReportTypeDir, entryRel, Failed, err)
+					subData = nil
+				}
+			}
+
+			if err := t.dir(entryRel, entryPath, sub, subData); err != nil {
+				return err
+			}
+			t.result.Dirs++
+
+		case entry.Type().IsRegular():
+			if err := t.file(entryRel, entryPath, cs.GetFile(entryName), report); err != nil {
+				return err
+			}
+
+		default:
+			if err := t.special(entryRel, entryPath, cs.GetSpecialFile(entryName), report); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Report recorded entries that are missing.
+	if report {
+		reportMissing(t.result, rel, cs, func(name string) bool {
+			return seen[name]
+		}, nil)
+	}
+	return nil
+}
+
+// file writes a file and compares it with its recorded state.
+func (t *tarWriter) file(rel, name string, recorded *File, report bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		t.result.add(ReportTypeFile, rel, Failed, err)
+		return nil
+	}
+	defer f.Close() //nolint:errcheck
+	info, err := f.Stat()
+	if err != nil {
+		t.result.add(ReportTypeFile, rel, Failed, err)
+		return nil
+	}
+
+	// Use the recorded hash, to compare the digests.
+	h, _ := t.cfg.hash()
+	if recorded != nil && Hash(recorded.Algorithm).IsValid() {
+		h = Hash(recorded.Algorithm)
+		if t.cfg.Key != nil {
+			h = h.Keyed()
+		}
+	}
+	hasher, err := h.newHasher(t.cfg.Key)
+	if err != nil {
+		t.result.add(ReportTypeFile, rel, Failed, err)
+		return nil
+	}
+	defer hasher.Reset() // Internal state may leak data if kept in memory.
+
+	// Write file while digesting.
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		t.result.add(ReportTypeFile, rel, Failed, err)
+		return nil
+	}
+	if err := t.writeHeader(hdr, rel); err != nil {
+		return err
+	}
+	n, err := io.CopyN(t.tw, io.TeeReader(f, hasher), hdr.Size)
+	if err != nil {
+		// The stream is broken, if the file could not be written completely.
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	t.result.Files++
+	t.result.Bytes += uint64(n) //nolint:gosec // Never negative.
+
+	if !report {
+		return nil
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	switch {
+	case recorded == nil:
+		t.result.add(ReportTypeFile, rel, Added, nil)
+	case recorded.Size != n, recorded.Algorithm != string(h), !strings.EqualFold(recorded.Digest, sum):
+		t.result.add(ReportTypeFile, rel, Changed, nil)
+	case !recorded.Modified.Equal(info.ModTime()):
+		t.result.add(ReportTypeFile, rel, TimestampChanged, nil)
+	}
+	return nil
+}
+
+// special writes a special file and compares it with its recorded state.
+func (t *tarWriter) special(rel, name string, recorded *Special, report bool) error {
+	info, err := os.Lstat(name)
+	if err != nil {
+		t.result.add(ReportTypeSpecial, rel, Failed, err)
+		return nil
+	}
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(name)
+		if err != nil {
+			t.result.add(ReportTypeSpecial, rel, Failed, err)
+			return nil
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		// Eg. sockets cannot be written to tar streams.
+		t.result.add(ReportTypeSpecial, rel, Failed, err)
+		return nil
+	}
+	if err := t.writeHeader(hdr, rel); err != nil {
+		return err
+	}
+	t.result.Specials++
+
+	if report {
+		compareSpecial(t.result, rel, recorded, specialTypeOf(info.Mode().Type()), info.ModTime(), false)
+	}
+	return nil
+}
+
+func compareSpecial(result *TarResult, rel string, recorded *Special, specialType string, modified time.Time, ignoreModTime bool) {
+	switch {
+	case recorded == nil:
+		result.add(ReportTypeSpecial, rel, Added, nil)
+	case recorded.Type != specialType:
+		result.add(ReportTypeSpecial, rel, Changed, nil)
+	case !ignoreModTime && !recorded.Modified.Equal(modified):
+		result.add(ReportTypeSpecial, rel, TimestampChanged, nil)
+	}
+}
+
+// reportMissing adds all recorded entries of cs that were not seen as removed.
+// Entries that were early, see tarVerifier.early, are added as failed instead.
+func reportMissing(result *TarResult, rel string, cs *Checksums, seen func(name string) bool, early map[string]bool) {
+	add := func(entryType, name string) {
+		entryRel := path.Join(rel, name)
+		if early[entryRel] {
+			result.add(entryType, entryRel, Failed, ErrMemberBeforeChecksumFile)
+		} else {
+			result.add(entryType, entryRel, Removed, nil)
+		}
+	}
+
+	for _, file := range cs.Files {
+		if !seen(file.Name) {
+			add(ReportTypeFile, file.Name)
+		}
+	}
+	for _, dir := range cs.Directories {
+		if !seen(dir.Name) {
+			add(ReportTypeDir, dir.Name)
+		}
+	}
+	for _, special := range cs.Specials {
+		if !seen(special.Name) {
+			add(ReportTypeSpecial, special.Name)
+		}
+	}
+}
+
+// VerifyTar reads a tar stream and verifies all members against the checksum
+// files embedded in the stream, or against the checksum files in the manifest
+// dir, if set. Nothing is extracted. Embedded checksum files must precede the
+// members of their dir, as written by WriteTar.
+// The returned error is set if the stream could not be read completely.
+func VerifyTar(r io.Reader, cfg TarConfig) (*TarResult, error) {
+	v := &tarVerifier{
+		cfg:       cfg,
+		result:    &TarResult{},
+		manifests: make(map[string]*tarManifest),
+		early:     make(map[string]bool),
+	}
+
+	// Load root checksum file from manifest dir.
+	if cfg.ManifestDir != "" {
+		data, err := readChecksumFile(cfg.ManifestDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root checksum file: %w", err)
+		}
+		cs, err := verifiedChecksums(cfg.ManifestDir, data, nil, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		v.rootData = data
+		v.manifests[""] = newTarManifest(cs)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			v.finish()
+			return v.result, nil
+		case err != nil:
+			return v.result, err
+		}
+
+		rel := memberName(hdr.Name)
+		if rel == "" {
+			// Root dir.
+			continue
+		}
+		parent, name := path.Split(rel)
+		parent = strings.TrimSuffix(parent, "/")
+
+		switch {
+		case name == ChecksumFilename:
+			err = v.checksumFile(parent, tr)
+		case parent == "" && name == RootMACFilename:
+			v.macData, err = io.ReadAll(tr)
+		case parent == "" && name == SignatureFilename:
+			v.sigData, err = io.ReadAll(tr)
+		case hdr.Typeflag == tar.TypeDir:
+			v.dir(parent, name)
+		case hdr.Typeflag == tar.TypeReg:
+			err = v.file(parent, name, hdr, tr)
+		default:
+			v.special(parent, name, hdr)
+		}
+		if err != nil {
+			return v.result, fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+}
+
+type tarVerifier struct {
+	cfg    TarConfig
+	result *TarResult
+
+	// manifests holds the checksums of all dirs by their path.
+	// It holds nil for dirs whose checksums could not be loaded.
+	manifests map[string]*tarManifest
+	// early holds the members that came before the checksum file of their
+	// dir, so that they could not be verified.
+	early map[string]bool
+
+	rootData []byte
+	macData  []byte
+	sigData  []byte
+}
+
+type tarManifest struct {
+	cs   *Checksums
+	seen map[string]bool
+}
+
+func newTarManifest(cs *Checksums) *tarManifest {
+	return &tarManifest{
+		cs:   cs,
+		seen: make(map[string]bool),
+	}
+}
+
+// manifest returns the checksums of the dir, or nil if it has none.
+// Checksum files in the manifest dir are loaded on first use.
+func (v *tarVerifier) manifest(dir string) *tarManifest {
+	m, ok := v.manifests[dir]
+	if ok || v.cfg.ManifestDir == "" || dir == "" {
+		return m
+	}
+
+	// Load from manifest dir, if recorded in parent.
+	parent, name := path.Split(dir)
+	parentManifest := v.manifest(strings.TrimSuffix(parent, "/"))
+	if parentManifest == nil {
+		return nil
+	}
+	recorded := parentManifest.cs.GetDir(name)
+	if recorded == nil {
+		return nil
+	}
+	parentManifest.seen[name] = true
+	cs, err := loadVerifiedChecksums(filepath.Join(v.cfg.ManifestDir, filepath.FromSlash(dir)), recorded, v.cfg.Key)
+	if err != nil {
+		v.result.add(ReportTypeDir, dir, Failed, err)
+		v.manifests[dir] = nil
+		return nil
+	}
+	m = newTarManifest(cs)
+	v.manifests[dir] = m
+	return m
+}
+
+// checksumFile loads an embedded checksum file of the given dir and verifies
+// it against its parent.
+func (v *tarVerifier) checksumFile(dir string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	v.result.ChecksumFiles++
+	if v.cfg.ManifestDir != "" {
+		// Use checksum files from manifest dir.
+		return nil
+	}
+
+	// Load root checksum file.
+	if dir == "" {
+		v.rootData = data
+		cs, err := verifiedChecksums(".", data, nil, v.cfg.Key)
+		if err != nil {
+			v.result.Errors = append(v.result.Errors, err)
+			v.manifests[""] = nil
+			return nil
+		}
+		v.manifests[""] = newTarManifest(cs)
+		return nil
+	}
+
+	// Verify against parent.
+	parent, name := path.Split(dir)
+	parentManifest := v.manifest(strings.TrimSuffix(parent, "/"))
+	if parentManifest == nil {
+		return nil
+	}
+	recorded := parentManifest.cs.GetDir(name)
+	if recorded == nil {
+		// Reported as added dir.
+		return nil
+	}
+	parentManifest.seen[name] = true
+	cs, err := verifiedChecksums(dir, data, recorded, v.cfg.Key)
+	if err != nil {
+		v.result.add(ReportTypeDir, dir, Failed, err)
+		v.manifests[dir] = nil
+		return nil
+	}
+	v.manifests[dir] = newTarManifest(cs)
+	return nil
+}
+
+// markEarly marks a member that came before the checksum file of its dir, if
+// the checksum file may still follow.
+func (v *tarVerifier) markEarly(parent, name string) {
+	if _, ok := v.manifests[parent]; !ok && v.cfg.ManifestDir == "" {
+		v.early[path.Join(parent, name)] = true
+	}
+}
+
+func (v *tarVerifier) dir(parent, name string) {
+	v.result.Dirs++
+	m := v.manifest(parent)
+	if m == nil {
+		v.markEarly(parent, name)
+		return
+	}
+	m.seen[name] = true
+	if m.cs.GetDir(name) == nil {
+		v.result.add(ReportTypeDir, path.Join(parent, name), Added, nil)
+	}
+}
+
+func (v *tarVerifier) file(parent, name string, hdr *tar.Header, r io.Reader) error {
+	v.result.Files++
+	v.result.Bytes += uint64(hdr.Size) //nolint:gosec // Checked by tar reader.
+	rel := path.Join(parent, name)
+
+	m := v.manifest(parent)
+	if m == nil {
+		v.markEarly(parent, name)
+		return nil
+	}
+	m.seen[name] = true
+	recorded := m.cs.GetFile(name)
+	if recorded == nil {
+		v.result.add(ReportTypeFile, rel, Added, nil)
+		return nil
+	}
+
+	// Digest with recorded hash.
+	hasher, err := Hash(recorded.Algorithm).newHasher(v.cfg.Key)
+	if err != nil {
+		v.result.add(ReportTypeFile, rel, Failed, err)
+		return nil
+	}
+	defer hasher.Reset() // Internal state may leak data if kept in memory.
+	if _, err := io.Copy(hasher, r); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	switch {
+	case recorded.Size != hdr.Size, !strings.EqualFold(recorded.Digest, sum):
+		v.result.add(ReportTypeFile, rel, Changed, nil)
+	case !v.cfg.IgnoreModTime && !recorded.Modified.Equal(hdr.ModTime):
+		v.result.add(ReportTypeFile, rel, TimestampChanged, nil)
+	}
+	return nil
+}
+
+func (v *tarVerifier) special(parent, name string, hdr *tar.Header) {
+	v.result.Specials++
+	m := v.manifest(parent)
+	if m == nil {
+		v.markEarly(parent, name)
+		return
+	}
+	m.seen[name] = true
+	compareSpecial(
+		v.result,
+		path.Join(parent, name),
+		m.cs.GetSpecialFile(name),
+		specialTypeOf(hdr.FileInfo().Mode().Type()),
+		hdr.ModTime,
+		v.cfg.IgnoreModTime,
+	)
+}
+
+// finish verifies the root checksum file and reports all missing entries.
+func (v *tarVerifier) finish() {
+	v.verifyRoot()
+
+	// Report missing entries of all dirs, starting at the root.
+	queue := []string{""}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		m := v.manifest(dir)
+		if m == nil {
+			continue
+		}
+
+		reportMissing(v.result, dir, &Checksums{Files: m.cs.Files, Specials: m.cs.Specials}, func(name string) bool {
+			return m.seen[name]
+		}, v.early)
+		for _, recorded := range m.cs.Directories {
+			child := path.Join(dir, recorded.Name)
+			if v.cfg.ManifestDir != "" && m.seen[recorded.Name] {
+				// Load checksums of dirs without files.
+				v.manifest(child)
+			}
+			_, loaded := v.manifests[child]
+			switch {
+			case loaded:
+				queue = append(queue, child)
+			case v.early[child]:
+				v.result.add(ReportTypeDir, child, Failed, ErrMemberBeforeChecksumFile)
+			case m.seen[recorded.Name]:
+				v.result.add(ReportTypeDir, child, Failed, errors.New("checksum file missing from tar stream"))
+			default:
+				v.result.add(ReportTypeDir, child, Removed, nil)
+			}
+		}
+	}
+
+	v.result.sortEntries()
+}
+
+// verifyRoot verifies the MAC and signature of the root checksum file.
+func (v *tarVerifier) verifyRoot() {
+	addErr := func(err error) {
+		if err != nil {
+			v.result.Errors = append(v.result.Errors, err)
+		}
+	}
+
+	if v.rootData == nil {
+		addErr(ErrNoRootChecksumFile)
+		return
+	}
+
+	// Verify with checksum files from manifest dir.
+	if v.cfg.ManifestDir != "" {
+		_, err := os.Stat(filepath.Join(v.cfg.ManifestDir, SignatureFilename))
+		v.result.Signed = err == nil
+		if v.cfg.Key != nil {
+			addErr(VerifyRootMACFile(v.cfg.ManifestDir, v.cfg.Key))
+		}
+		if v.cfg.TrustedKeys != nil {
+			_, err := VerifyRootChecksumFile(v.cfg.ManifestDir, v.cfg.TrustedKeys)
+			addErr(err)
+		}
+		return
+	}
+
+	// Verify with embedded files.
+	v.result.Signed = v.sigData != nil
+	switch {
+	case v.cfg.Key != nil && v.macData == nil:
+		addErr(ErrMissingRootMAC)
+	case v.cfg.Key != nil:
+		addErr(checkRootMAC(v.macData, v.rootData, v.cfg.Key))
+	case v.macData != nil:
+		addErr(fmt.Errorf("%w: tree is in keyed mode", ErrKeyRequired))
+	}
+	switch {
+	case v.cfg.TrustedKeys == nil:
+	case v.sigData == nil:
+		addErr(ErrMissingSignature)
+	default:
+		_, err := v.cfg.TrustedKeys.Verify(v.rootData, v.sigData)
+		addErr(err)
+	}
+}
@@ -0,0 +1,62 @@
+package checkser
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "corrupt me\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// Write tar stream.
+	var buf bytes.Buffer
+	result, err := WriteTar(&buf, dir, TarConfig{})
+	switch {
+	case err != nil:
+		t.Fatalf("failed to write tar: %s", err)
+	case len(result.Entries) > 0:
+		t.Fatalf("unexpected entries when writing: %+v", result.Entries[0])
+	case result.Files != 2:
+		t.Fatalf("wrote %d files, expected 2", result.Files)
+	case result.Digest == "":
+		t.Fatal("tar stream digest missing")
+	}
+
+	// Verify against embedded and external checksum files.
+	for _, cfg := range []TarConfig{{}, {ManifestDir: dir}} {
+		result, err = VerifyTar(bytes.NewReader(buf.Bytes()), cfg)
+		switch {
+		case err != nil:
+			t.Fatalf("failed to verify tar: %s", err)
+		case len(result.Entries) > 0:
+			t.Fatalf("unexpected entries when verifying: %+v", result.Entries[0])
+		case len(result.Errors) > 0:
+			t.Fatalf("unexpected errors when verifying: %v", result.Errors)
+		case result.Files != 2:
+			t.Fatalf("verified %d files, expected 2", result.Files)
+		}
+	}
+
+	// Corrupt a file in the tar stream.
+	corrupted := bytes.Clone(buf.Bytes())
+	i := bytes.Index(corrupted, []byte("corrupt me\n"))
+	if i < 0 {
+		t.Fatal("file data not found in tar stream")
+	}
+	corrupted[i] ^= 1
+	result, err = VerifyTar(bytes.NewReader(corrupted), TarConfig{})
+	switch {
+	case err != nil:
+		t.Fatalf("failed to verify tar: %s", err)
+	case len(result.Entries) != 1:
+		t.Fatalf("%d entries, expected 1", len(result.Entries))
+	case result.Entries[0].Path != "sub/b.txt" || result.Entries[0].Change != Changed:
+		t.Fatalf("unexpected entry %+v", result.Entries[0])
+	}
+}
@@ -0,0 +1,143 @@
+package checkser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Tree hashes are calculated from the recorded checksum files only.
+// Every dir is hashed from its entries, sorted by name:
+//
+//	type byte, name, then for files: size, algorithm, digest;
+//	for dirs: tree hash of the dir; for others: special type.
+//
+// All variable length fields are prefixed with their length as uvarint.
+// Timestamps, hosts and the format of checksum files are not included.
+const treeHashDomain = "checkser tree hash v1"
+
+// Errors.
+var (
+	ErrIncompleteTree = errors.New("tree has entries without checksums, update it first")
+)
+
+// TreeHash calculates a content-only hash of the tree at dir from its
+// checksum files. It is independent of timestamps, hosts, entry order and
+// checksum file formats, so that copies of a tree on different hosts have the
+// same tree hash, as long as the same hash algorithms were used for files.
+// Files are not digested: the tree hash reflects the state recorded in the
+// checksum files, which is verified to be consistent. Verify the tree first
+// to ensure that the files match.
+// The key is needed for keyed trees.
+func TreeHash(dir string, h Hash, key []byte) (string, error) {
+	if !h.IsValid() || h.IsKeyed() {
+		return "", ErrInvalidHashAlg
+	}
+
+	sum, err := treeHash(dir, h, key, nil)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := h.New()
+	writeTreeHashField(hasher, []byte(treeHashDomain))
+	writeTreeHashField(hasher, sum)
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+func treeHash(path string, h Hash, key []byte, pathDir *Directory) ([]byte, error) {
+	// Load checksum file.
+	data, err := os.ReadFile(filepath.Join(path, ChecksumFilename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum file in %s: %w", path, err)
+	}
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checksum file in %s: %w", path, err)
+	}
+
+	// Check if the checksum file matches its parent.
+	if pathDir != nil {
+		dirChecksum, err := Hash(pathDir.Algorithm).DigestWithKey(data, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to digest checksum file in %s: %w", path, err)
+		}
+		if dirChecksum != pathDir.Digest {
+			return nil, fmt.Errorf("%w: %s", ErrIntegrityViolated, path)
+		}
+	}
+
+	// Collect entries.
+	type treeEntry struct {
+		name   string
+		fields func(hash.Hash) error
+	}
+	entries := make([]treeEntry, 0, len(cs.Files)+len(cs.Directories)+len(cs.Specials))
+	for _, file := range cs.Files {
+		entries = append(entries, treeEntry{
+			name: file.Name,
+			fields: func(hasher hash.Hash) error {
+				if file.Algorithm == "" {
+					return fmt.Errorf("%w: %s", ErrIncompleteTree, filepath.Join(path, file.Name))
+				}
+				writeTreeHashField(hasher, []byte{'f'})
+				writeTreeHashField(hasher, []byte(file.Name))
+				writeTreeHashField(hasher, binary.AppendUvarint(nil, uint64(file.Size))) //nolint:gosec // Sizes are never negative.
+				writeTreeHashField(hasher, []byte(file.Algorithm))
+				writeTreeHashField(hasher, []byte(strings.ToLower(file.Digest)))
+				return nil
+			},
+		})
+	}
+	for _, dir := range cs.Directories {
+		entries = append(entries, treeEntry{
+			name: dir.Name,
+			fields: func(hasher hash.Hash) error {
+				if dir.Algorithm == "" {
+					return fmt.Errorf("%w: %s", ErrIncompleteTree, filepath.Join(path, dir.Name))
+				}
+				sum, err := treeHash(filepath.Join(path, dir.Name), h, key, dir)
+				if err != nil {
+					return err
+				}
+				writeTreeHashField(hasher, []byte{'d'})
+				writeTreeHashField(hasher, []byte(dir.Name))
+				writeTreeHashField(hasher, sum)
+				return nil
+			},
+		})
+	}
+	for _, special := range cs.Specials {
+		entries = append(entries, treeEntry{
+			name: special.Name,
+			fields: func(hasher hash.Hash) error {
+				writeTreeHashField(hasher, []byte{'s'})
+				writeTreeHashField(hasher, []byte(special.Name))
+				writeTreeHashField(hasher, []byte(special.Type))
+				return nil
+			},
+		})
+	}
+	slices.SortFunc(entries, func(a, b treeEntry) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	// Hash entries.
+	hasher := h.New()
+	for _, entry := range entries {
+		if err := entry.fields(hasher); err != nil {
+			return nil, err
+		}
+	}
+	return hasher.Sum(nil), nil
+}
+
+func writeTreeHashField(hasher hash.Hash, data []byte) {
+	_, _ = hasher.Write(binary.AppendUvarint(nil, uint64(len(data)))) // Never returns an error.
+	_, _ = hasher.Write(data)
+}
@@ -0,0 +1,88 @@
+package checkser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTreeHash is the tree hash of the tree written by writeTreeHashTree.
+// It must never change, as tree hashes are compared across versions.
+const testTreeHash = "8da2eb60d79843936d5f0e8bb3fc0e284dfb49d193797a5f8f7416f1fe70cf64"
+
+func writeTreeHashTree(t *testing.T, dir string) {
+	t.Helper()
+
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "sub\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "deeper", "c.txt"), "deeper\n")
+}
+
+func TestTreeHash(t *testing.T) {
+	t.Parallel()
+
+	// Copies have the same tree hash, regardless of timestamps and formats.
+	dirA := t.TempDir()
+	writeTreeHashTree(t, dirA)
+	updateTree(t, dirA, ScanConfig{})
+	dirB := t.TempDir()
+	writeTreeHashTree(t, dirB)
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dirB, "a.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	updateTree(t, dirB, ScanConfig{Format: FormatCBORZstd})
+
+	hashA, err := TreeHash(dirA, SHA2_256, nil)
+	if err != nil {
+		t.Fatalf("failed to calculate tree hash: %s", err)
+	}
+	hashB, err := TreeHash(dirB, SHA2_256, nil)
+	switch {
+	case err != nil:
+		t.Fatalf("failed to calculate tree hash: %s", err)
+	case hashA != hashB:
+		t.Fatalf("tree hashes of copies differ: %s != %s", hashA, hashB)
+	case hashA != testTreeHash:
+		t.Fatalf("tree hash changed: %s", hashA)
+	}
+
+	// Content changes and renames change the tree hash.
+	writeTestFile(t, filepath.Join(dirB, "sub", "deeper", "c.txt"), "deeper!\n")
+	updateTree(t, dirB, ScanConfig{})
+	if hashB, _ = TreeHash(dirB, SHA2_256, nil); hashB == hashA {
+		t.Fatal("tree hash did not change with content")
+	}
+	writeTestFile(t, filepath.Join(dirB, "sub", "deeper", "c.txt"), "deeper\n")
+	updateTree(t, dirB, ScanConfig{})
+	if hashB, _ = TreeHash(dirB, SHA2_256, nil); hashB != hashA {
+		t.Fatal("tree hash differs after reverting content")
+	}
+	if err := os.Rename(filepath.Join(dirB, "a.txt"), filepath.Join(dirB, "renamed.txt")); err != nil {
+		t.Fatal(err)
+	}
+	updateTree(t, dirB, ScanConfig{})
+	if hashB, _ = TreeHash(dirB, SHA2_256, nil); hashB == hashA {
+		t.Fatal("tree hash did not change with rename")
+	}
+
+	// Tampered checksum files are detected.
+	checksumFile := filepath.Join(dirA, "sub", ChecksumFilename)
+	data, err := os.ReadFile(checksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(checksumFile, append(data, '\n'), 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	if _, err := TreeHash(dirA, SHA2_256, nil); !errors.Is(err, ErrIntegrityViolated) {
+		t.Fatalf("unexpected error for tampered checksum file: %v", err)
+	}
+
+	// Keyed algorithms are not supported.
+	if _, err := TreeHash(dirB, SHA2_256.Keyed(), nil); !errors.Is(err, ErrInvalidHashAlg) {
+		t.Fatalf("unexpected error for keyed algorithm: %v", err)
+	}
+}
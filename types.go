@@ -3,18 +3,73 @@ package checkser
 import (
 	"path/filepath"
 	"slices"
+	"strings"
 	"time"
 )
 
 type Checksums struct {
 	Version int `json:"checkser,omitempty" yaml:"checkser,omitempty"`
+	Compat  int `json:"compat,omitempty" yaml:"compat,omitempty"`
 
 	UpdatedAt time.Time `json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
 	UpdatedBy string    `json:"updated_by,omitempty" yaml:"updated_by,omitempty"`
+	Generator string    `json:"generator,omitempty" yaml:"generator,omitempty"`
+
+	// AppendOnly refuses to record removed and changed entries of this dir
+	// and all sub dirs. It is inherited by sub dirs and cannot be disabled.
+	AppendOnly bool `json:"append_only,omitempty" yaml:"append_only,omitempty"`
 
 	Files       []*File      `json:"files,omitempty" yaml:"files,omitempty"`
 	Directories []*Directory `json:"dirs,omitempty" yaml:"dirs,omitempty"`
 	Specials    []*Special   `json:"other,omitempty" yaml:"other,omitempty"`
+
+	// Extra holds unknown fields, which are preserved when rewriting.
+	Extra map[string]any `json:"-" yaml:",inline"`
+	// binUnknown holds unknown integer keys of binary checksum files.
+	binUnknown binUnknown
+
+	format Format
+	scope  *pathScope
+	data   []byte
+
+	// appendOnlyEnabled is set when append-only mode was newly enabled.
+	appendOnlyEnabled bool
+}
+
+// Format returns the format the checksums were loaded from or will be written in.
+func (cs *Checksums) Format() Format {
+	if cs.format == "" {
+		return DefaultFormat
+	}
+	return cs.format
+}
+
+// SetFormat sets the format the checksums will be written in.
+func (cs *Checksums) SetFormat(format Format) {
+	cs.format = format
+}
+
+// Sort sorts all entries by name.
+func (cs *Checksums) Sort() {
+	slices.SortFunc(cs.Files, func(a, b *File) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(cs.Directories, func(a, b *Directory) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(cs.Specials, func(a, b *Special) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+func (cs *Checksums) isSorted() bool {
+	return slices.IsSortedFunc(cs.Files, func(a, b *File) int {
+		return strings.Compare(a.Name, b.Name)
+	}) && slices.IsSortedFunc(cs.Directories, func(a, b *Directory) int {
+		return strings.Compare(a.Name, b.Name)
+	}) && slices.IsSortedFunc(cs.Specials, func(a, b *Special) int {
+		return strings.Compare(a.Name, b.Name)
+	})
 }
 
 type File struct {
@@ -25,13 +80,25 @@ type File struct {
 	Algorithm string    `json:"alg,omitempty" yaml:"alg,omitempty"`
 	Digest    string    `json:"sum,omitempty" yaml:"sum,omitempty"`
 
+	// Members holds the members of archives, if indexed.
+	Members []*Member `json:"members,omitempty" yaml:"members,omitempty"`
+
+	// Extra holds unknown fields, which are preserved when rewriting.
+	Extra map[string]any `json:"-" yaml:",inline"`
+	// binUnknown holds unknown integer keys of binary checksum files.
+	binUnknown binUnknown
+
 	Change  Change   `json:"-" yaml:"-"`
+	Action  Action   `json:"-" yaml:"-"`
 	ErrMsgs []string `json:"-" yaml:"-"`
 	Changed struct {
 		Size      int64
 		Modified  time.Time
 		Algorithm string
 		Digest    string
+		// Members holds the members found in the archive, with their
+		// change, and the removed members. It is nil if not indexed.
+		Members []*Member
 	} `json:"-" yaml:"-"`
 }
 
@@ -71,9 +138,15 @@ type Directory struct {
 	Algorithm string `json:"alg,omitempty" yaml:"alg,omitempty"`
 	Digest    string `json:"sum,omitempty" yaml:"sum,omitempty"`
 
+	// Extra holds unknown fields, which are preserved when rewriting.
+	Extra map[string]any `json:"-" yaml:",inline"`
+	// binUnknown holds unknown integer keys of binary checksum files.
+	binUnknown binUnknown
+
 	Verified bool `json:"-" yaml:"-"`
 
 	Change  Change   `json:"-" yaml:"-"`
+	Action  Action   `json:"-" yaml:"-"`
 	ErrMsgs []string `json:"-" yaml:"-"`
 	Changed struct {
 		ChangedAlgorithm string
@@ -104,7 +177,13 @@ type Special struct {
 	Type     string    `json:"type,omitempty" yaml:"type,omitempty"`
 	Modified time.Time `json:"mod,omitempty" yaml:"mod,omitempty"`
 
+	// Extra holds unknown fields, which are preserved when rewriting.
+	Extra map[string]any `json:"-" yaml:",inline"`
+	// binUnknown holds unknown integer keys of binary checksum files.
+	binUnknown binUnknown
+
 	Change  Change   `json:"-" yaml:"-"`
+	Action  Action   `json:"-" yaml:"-"`
 	ErrMsgs []string `json:"-" yaml:"-"`
 	Changed struct {
 		Type     string
@@ -192,3 +271,34 @@ func (c Change) String() string {
 		return "unknown"
 	}
 }
+
+// Name returns a stable, machine-readable name of the change.
+func (c Change) Name() string {
+	switch c {
+	case Removed:
+		return "removed"
+	case Added:
+		return "added"
+	case Changed:
+		return "changed"
+	case TimestampChanged:
+		return "timestamp_changed"
+	case NoChange:
+		return "no_change"
+	case Failed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseChange returns the change with the given machine-readable name, as
+// returned by Name. It returns Invalid if the name is unknown.
+func ParseChange(name string) Change {
+	for _, c := range []Change{Removed, Added, Changed, TimestampChanged, NoChange, Failed} {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return Invalid
+}
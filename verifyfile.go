@@ -0,0 +1,136 @@
+package checkser
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Errors.
+var (
+	ErrNotRecorded           = errors.New("not recorded in checksum file")
+	ErrFileIntegrityViolated = errors.New("file integrity violated")
+)
+
+// FileVerification is the result of verifying a single file.
+type FileVerification struct {
+	// Path is the absolute path of the file.
+	Path string
+
+	// Entry is the entry of the file in the checksum file of its dir.
+	Entry *File
+	// Data holds the raw checksum file of the dir of the file.
+	Data []byte
+
+	// Chain holds the chain of checksum files from the dir of the file up to
+	// the tree root, if requested.
+	Chain *Chain
+
+	// Change is the change of the file compared to its entry: NoChange,
+	// TimestampChanged, Changed, Added if not recorded, Removed if missing,
+	// or Failed if it could not be digested. It is Invalid if the file could
+	// not be compared, eg. because of an invalid checksum file.
+	Change Change
+}
+
+// VerifyFile verifies a single file against its entry in the checksum file of
+// its dir, without scanning anything else. If verifyChain is set, the chain of
+// checksum files up to the tree root is verified too.
+// The key is needed for keyed trees and enforces keyed digests if set.
+// The returned verification holds all information gathered, even on error.
+func VerifyFile(path string, key []byte, verifyChain bool) (*FileVerification, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	v := &FileVerification{
+		Path:   path,
+		Change: Invalid,
+	}
+
+	// Find entry in checksum file.
+	dir := filepath.Dir(path)
+	v.Data, err = readChecksumFile(dir)
+	if err != nil {
+		return v, fmt.Errorf("failed to read checksum file: %w", err)
+	}
+	cs, err := LoadChecksums(v.Data)
+	if err != nil {
+		return v, fmt.Errorf("failed to load checksum file: %w", err)
+	}
+	v.Entry = cs.GetFile(norm.NFC.String(filepath.Base(path)))
+	if v.Entry == nil {
+		v.Change = Added
+		return v, ErrNotRecorded
+	}
+
+	// Verify file.
+	err = v.verifyFile(key)
+	if err != nil {
+		return v, err
+	}
+
+	// Verify chain.
+	if verifyChain {
+		v.Chain, err = FindChain(dir, v.Data, key)
+		if err != nil {
+			return v, fmt.Errorf("failed to verify chain: %w", err)
+		}
+		if err := v.Chain.Err(); err != nil {
+			return v, err
+		}
+	}
+
+	return v, nil
+}
+
+func (v *FileVerification) verifyFile(key []byte) error {
+	h := Hash(v.Entry.Algorithm)
+	switch {
+	case !h.IsValid():
+		return fmt.Errorf("%w: %s", ErrInvalidHashAlg, v.Entry.Algorithm)
+	case key != nil && !h.IsKeyed():
+		return errors.New("file integrity not protected: checksum is not keyed")
+	}
+
+	// Check size first to avoid digesting.
+	info, err := os.Stat(v.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		v.Change = Removed
+		return err
+	case err != nil:
+		v.Change = Failed
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		v.Change = Changed
+		return fmt.Errorf("%w: not a regular file", ErrFileIntegrityViolated)
+	}
+	if info.Size() != v.Entry.Size {
+		v.Change = Changed
+		return fmt.Errorf("%w: size did not match", ErrFileIntegrityViolated)
+	}
+
+	// Digest file.
+	sum, err := h.DigestFileWithKey(v.Path, key)
+	if err != nil {
+		v.Change = Failed
+		return fmt.Errorf("digest failed: %w", err)
+	}
+	switch {
+	case sum != v.Entry.Digest:
+		v.Change = Changed
+		return fmt.Errorf("%w: checksum did not match", ErrFileIntegrityViolated)
+	case !info.ModTime().Equal(v.Entry.Modified):
+		// The content is intact.
+		v.Change = TimestampChanged
+	default:
+		v.Change = NoChange
+	}
+	return nil
+}
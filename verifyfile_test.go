@@ -0,0 +1,78 @@
+package checkser
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "corrupt me\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "c.txt"), "touch me\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "d.txt"), "remove me\n")
+	updateTree(t, dir, ScanConfig{})
+
+	// Intact file with chain.
+	v, err := VerifyFile(filepath.Join(dir, "sub", "b.txt"), nil, true)
+	switch {
+	case err != nil:
+		t.Fatalf("failed to verify intact file: %s", err)
+	case v.Change != NoChange:
+		t.Fatalf("intact file is %s", v.Change.Name())
+	case v.Chain == nil || v.Chain.Root != dir:
+		t.Fatalf("unexpected chain %+v", v.Chain)
+	}
+
+	// Changed files.
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "corrupt m3\n")
+	touched := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "sub", "c.txt"), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "sub", "d.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "sub", "e.txt"), "new\n")
+	for _, tc := range []struct {
+		name   string
+		change Change
+		err    error
+	}{
+		{"b.txt", Changed, ErrFileIntegrityViolated},
+		{"c.txt", TimestampChanged, nil},
+		{"d.txt", Removed, fs.ErrNotExist},
+		{"e.txt", Added, ErrNotRecorded},
+	} {
+		v, err := VerifyFile(filepath.Join(dir, "sub", tc.name), nil, false)
+		switch {
+		case v.Change != tc.change:
+			t.Errorf("%s is %s, expected %s", tc.name, v.Change.Name(), tc.change.Name())
+		case tc.err == nil && err != nil,
+			tc.err != nil && !errors.Is(err, tc.err):
+			t.Errorf("unexpected error for %s: %v", tc.name, err)
+		}
+	}
+
+	// A tampered parent checksum file breaks the chain of an intact file.
+	checksumFile := filepath.Join(dir, "sub", ChecksumFilename)
+	data, err := os.ReadFile(checksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(checksumFile, append(data, '\n'), 0o0644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	if _, err := VerifyFile(filepath.Join(dir, "sub", "c.txt"), nil, true); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("unexpected error for broken chain: %v", err)
+	}
+	if _, err := VerifyFile(filepath.Join(dir, "sub", "c.txt"), nil, false); err != nil {
+		t.Fatalf("failed to verify without chain: %s", err)
+	}
+}
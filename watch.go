@@ -0,0 +1,157 @@
+package checkser
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// DefaultWatchDebounce is the default time to wait for further changes before updating.
+const DefaultWatchDebounce = 2 * time.Second
+
+// Errors.
+var (
+	ErrWatchUnsupported = errors.New("watching is not supported on this platform")
+)
+
+// WatchConfig configures watching a dir.
+type WatchConfig struct {
+	// ScanConfig is used for every update.
+	// Paths is set by the watcher.
+	ScanConfig
+
+	// TreeRoot is the root of the tree the watched dir belongs to.
+	// Updates are run from the tree root, so that the checksum files of all
+	// parent dirs are updated too. Defaults to the watched dir.
+	TreeRoot string
+
+	// Debounce is the time to wait for further changes before updating.
+	Debounce time.Duration
+
+	// MaxDelay is the longest time changes wait for an update, even if
+	// further changes keep coming in, eg. from a file that is written
+	// continuously. Defaults to 10 times the debounce.
+	MaxDelay time.Duration
+
+	// OnUpdate is called after every update with the finished scan and the
+	// time the update took. The scan is nil if the update failed before scanning.
+	OnUpdate func(scan *Scan, took time.Duration, err error)
+}
+
+// watcher collects changed paths and updates them.
+// The platform specific parts feed it with changes.
+type watcher struct {
+	dir string
+	cfg WatchConfig
+
+	// pending holds the changed paths relative to the tree root.
+	pending map[string]struct{}
+	// pendingSince is when the first pending change came in.
+	pendingSince time.Time
+}
+
+func newWatcher(dir string, cfg WatchConfig) (*watcher, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TreeRoot == "" {
+		cfg.TreeRoot = dir
+	}
+	cfg.TreeRoot, err = filepath.Abs(cfg.TreeRoot)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(cfg.TreeRoot, dir); err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("%w: %s is not within tree root %s", ErrInvalidPath, dir, cfg.TreeRoot)
+	}
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = DefaultWatchDebounce
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 10 * cfg.Debounce
+	}
+
+	// Check config.
+	_, err = New(cfg.TreeRoot, cfg.ScanConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &watcher{
+		dir:     dir,
+		cfg:     cfg,
+		pending: make(map[string]struct{}),
+	}, nil
+}
+
+// changed marks the path as changed.
+func (w *watcher) changed(path string) {
+	// Ignore files written by checkser.
+	switch filepath.Base(path) {
+	case ChecksumFilename, SignatureFilename, RootMACFilename:
+		return
+	}
+
+	rel, err := filepath.Rel(w.cfg.TreeRoot, path)
+	if err != nil {
+		return
+	}
+	if len(w.pending) == 0 {
+		w.pendingSince = time.Now()
+	}
+	w.pending[rel] = struct{}{}
+}
+
+// delay returns the time to wait before updating the pending changes: the
+// debounce, but at most until the max delay since the first pending change.
+func (w *watcher) delay() time.Duration {
+	if len(w.pending) == 0 {
+		return w.cfg.Debounce
+	}
+	return max(0, min(w.cfg.Debounce, w.cfg.MaxDelay-time.Since(w.pendingSince)))
+}
+
+// rescan marks the whole watched dir as changed.
+func (w *watcher) rescan() {
+	since := w.pendingSince
+	clear(w.pending)
+	w.changed(w.dir)
+	if !since.IsZero() {
+		// Keep waiting time of earlier changes.
+		w.pendingSince = since
+	}
+}
+
+// update updates all pending paths.
+func (w *watcher) update() {
+	if len(w.pending) == 0 {
+		return
+	}
+
+	// Collect paths.
+	cfg := w.cfg.ScanConfig
+	cfg.Paths = make([]string, 0, len(w.pending))
+	for path := range w.pending {
+		cfg.Paths = append(cfg.Paths, path)
+	}
+	clear(w.pending)
+	w.pendingSince = time.Time{}
+
+	// Update.
+	start := time.Now()
+	scan, err := New(w.cfg.TreeRoot, cfg)
+	if err == nil {
+		err = scan.Scan()
+	}
+	if err == nil {
+		scan.DigestFiles()
+		scan.CalculateChangeStats()
+		scan.WriteChecksumFiles()
+	}
+
+	if w.cfg.OnUpdate != nil {
+		w.cfg.OnUpdate(scan, time.Since(start), err)
+	}
+}
@@ -0,0 +1,180 @@
+//go:build linux
+
+package checkser
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_ATTRIB | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF |
+	unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW
+
+// Watch watches dir for changes with inotify and updates the changed paths,
+// until the context is canceled. Changes are debounced, but updated at the
+// latest after the max delay. After an overflow of the event queue, the whole
+// dir is rescanned.
+func Watch(ctx context.Context, dir string, cfg WatchConfig) error {
+	w, err := newWatcher(dir, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Create inotify instance.
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to init inotify: %w", err)
+	}
+	// Use non-blocking file with the runtime poller, so that closing it stops reading.
+	inotify := os.NewFile(uintptr(fd), "inotify")
+	defer inotify.Close() //nolint:errcheck
+
+	iw := &inotifyWatcher{
+		watcher: w,
+		fd:      fd,
+		wds:     make(map[int]string),
+	}
+	err = iw.addRecursive(w.dir)
+	if err != nil {
+		return err
+	}
+
+	// Read events.
+	events := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := inotify.Read(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case events <- bytes.Clone(buf[:n]):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Handle events and update after debounce.
+	debounce := time.NewTimer(w.cfg.Debounce)
+	debounce.Stop()
+	for {
+		select {
+		case data := <-events:
+			iw.handle(data)
+			debounce.Reset(w.delay())
+
+		case <-debounce.C:
+			if iw.resync {
+				// Add watches for dirs that might have been missed.
+				iw.resync = false
+				if err := iw.addRecursive(w.dir); err != nil {
+					return err
+				}
+			}
+			w.update()
+
+		case err := <-readErr:
+			return fmt.Errorf("failed to read inotify events: %w", err)
+
+		case <-ctx.Done():
+			// Update pending changes before stopping.
+			w.update()
+			return nil
+		}
+	}
+}
+
+type inotifyWatcher struct {
+	*watcher
+
+	fd  int
+	wds map[int]string
+
+	// resync is set when watches need to be added to all dirs again.
+	resync bool
+}
+
+// addRecursive adds watches for dir and all of its sub dirs.
+func (iw *inotifyWatcher) addRecursive(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil && path == dir:
+			return err
+		case err != nil:
+			// Dir was removed in the meantime or is not readable.
+			// Changes will be detected by the update.
+			return nil
+		case !d.IsDir():
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(iw.fd, path, watchMask)
+		switch {
+		case err == nil:
+			iw.wds[wd] = path
+		case errors.Is(err, unix.ENOSPC):
+			return fmt.Errorf("failed to watch %s: inotify watch limit reached, increase fs.inotify.max_user_watches", path)
+		case path == dir:
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// handle handles raw inotify events.
+func (iw *inotifyWatcher) handle(data []byte) {
+	for len(data) >= unix.SizeofInotifyEvent {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&data[0])) //nolint:gosec // Kernel guarantees layout.
+		nameData := data[unix.SizeofInotifyEvent : unix.SizeofInotifyEvent+int(event.Len)]
+		data = data[unix.SizeofInotifyEvent+int(event.Len):]
+		name := string(bytes.TrimRight(nameData, "\x00"))
+
+		// Handle queue overflow by rescanning everything.
+		if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+			iw.rescan()
+			iw.resync = true
+			continue
+		}
+
+		dir, ok := iw.wds[int(event.Wd)]
+		if !ok {
+			continue
+		}
+		switch {
+		case event.Mask&unix.IN_IGNORED != 0:
+			// Watch was removed.
+			delete(iw.wds, int(event.Wd))
+		case event.Mask&unix.IN_DELETE_SELF != 0:
+			iw.changed(dir)
+		case name != "":
+			path := filepath.Join(dir, name)
+			iw.changed(path)
+
+			// Watches of moved dirs keep their old path until resynced.
+			if event.Mask&unix.IN_ISDIR != 0 && event.Mask&unix.IN_MOVED_FROM != 0 {
+				iw.resync = true
+			}
+
+			// Watch new dirs.
+			if event.Mask&unix.IN_ISDIR != 0 && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+				if err := iw.addRecursive(path); err != nil {
+					iw.resync = true
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package checkser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startWatch watches dir and returns a channel receiving the finished updates.
+func startWatch(t *testing.T, dir string, cfg WatchConfig) <-chan *Scan {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan *Scan, 100)
+	cfg.OnUpdate = func(scan *Scan, _ time.Duration, err error) {
+		if err != nil {
+			t.Errorf("update failed: %s", err)
+		}
+		updates <- scan
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, dir, cfg)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch failed: %s", err)
+		}
+	})
+
+	// Give the watcher time to add its watches.
+	time.Sleep(200 * time.Millisecond)
+	return updates
+}
+
+func TestWatchUpdates(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "hello\n")
+	updateTree(t, dir, ScanConfig{})
+	updates := startWatch(t, dir, WatchConfig{Debounce: 100 * time.Millisecond})
+
+	// Add a file in a new sub dir.
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "new\n")
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update after adding a file")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "sub", ChecksumFilename))
+	if err != nil {
+		t.Fatalf("checksum file of new dir missing: %s", err)
+	}
+	cs, err := LoadChecksums(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.GetFile("b.txt") == nil {
+		t.Fatal("new file not recorded")
+	}
+	assertUnchanged(t, scanTree(t, dir, ScanConfig{DigestAll: true}))
+}
+
+func TestWatchMaxDelay(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "log.txt"), "start\n")
+	updateTree(t, dir, ScanConfig{})
+	updates := startWatch(t, dir, WatchConfig{
+		Debounce: 300 * time.Millisecond,
+		MaxDelay: time.Second,
+	})
+
+	// Write continuously, more often than the debounce.
+	f, err := os.OpenFile(filepath.Join(dir, "log.txt"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-updates:
+			return
+		case <-ticker.C:
+			if _, err := f.WriteString("line\n"); err != nil {
+				t.Fatal(err)
+			}
+		case <-timeout:
+			t.Fatal("no update while the file is written continuously")
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package checkser
+
+import "context"
+
+// Watch watches dir for changes and updates the changed paths, until the
+// context is canceled. It is only supported on Linux.
+func Watch(_ context.Context, _ string, _ WatchConfig) error {
+	return ErrWatchUnsupported
+}
@@ -1,6 +1,7 @@
 package checkser
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,13 +9,37 @@ import (
 )
 
 func (scan *Scan) WriteChecksumFiles() {
+	// Never write to the manifest dir or the scanned dir.
+	if scan.manifestDir != scan.rootDir {
+		scan.writeErrs = append(scan.writeErrs, fmt.Sprintf("%s: %s", scan.rootDir, ErrManifestReadOnly))
+		scan.Stats.WriteErrors.Add(1)
+		return
+	}
+
 	scan.Stats.WriteToDo.Store(1) // Root Dir.
 	scan.prepareForWriting(scan.rootSum)
 
-	scan.writeChecksums(scan.rootDir, scan.rootSum)
+	alg, sum := scan.writeChecksums(scan.rootDir, scan.rootSum, nil)
+
+	// Protect root checksum file in keyed mode.
+	if scan.cfg.Key != nil && sum != "" {
+		err := writeRootMAC(scan.rootDir, alg, sum)
+		if err != nil {
+			scan.writeErrs = append(scan.writeErrs, fmt.Sprintf("%s: write root MAC failed: %s", scan.rootDir, err))
+			scan.Stats.WriteErrors.Add(1)
+		}
+	}
 }
 
 func (scan *Scan) prepareForWriting(cs *Checksums) (writeChecksums bool) {
+	// Never record changes that violate the policy or append-only mode.
+	refuseViolations(cs)
+	if cs.AppendOnly {
+		keepRecorded(cs)
+	}
+	if cs.appendOnlyEnabled {
+		writeChecksums = true
+	}
 
 	// Prepare sub dirs.
 	for _, dir := range cs.Directories {
@@ -28,13 +53,24 @@ func (scan *Scan) prepareForWriting(cs *Checksums) (writeChecksums bool) {
 	}
 
 	// Update metadata.
-	cs.UpdatedAt = scan.updatedAt
-	cs.UpdatedBy = scan.updatedBy
+	// In canonical mode, metadata is only updated when the content changed.
+	if !scan.cfg.Canonical {
+		scan.updateMetadata(cs)
+	}
 
 	// Check if checksums need to be (re)written.
+	// Binary checksum files with unknown integer keys keep their format, as
+	// the text formats cannot hold them.
+	if cs.format != scan.format && (scan.format.isBinary() || !cs.hasBinUnknown()) {
+		cs.format = scan.format
+		writeChecksums = true
+	}
 	for _, file := range cs.Files {
-		switch file.Change {
-		case NoChange, Failed:
+		switch {
+		case file.membersIndexed():
+			// Record members of unchanged archive.
+			writeChecksums = true
+		case file.Change == NoChange, file.Change == Failed:
 			// Checksums update not necessary.
 		default:
 			writeChecksums = true
@@ -91,10 +127,11 @@ func (scan *Scan) prepareForWriting(cs *Checksums) (writeChecksums bool) {
 
 	// Apply changed data.
 	for _, file := range cs.Files {
+		file.applyMembers()
 		switch file.Change {
 		case Added, Changed, TimestampChanged:
 			file.Size = file.Changed.Size
-			file.Modified = file.Changed.Modified
+			file.Modified = file.Changed.Modified.UTC()
 			file.Algorithm = file.Changed.Algorithm
 			file.Digest = file.Changed.Digest
 		}
@@ -103,24 +140,53 @@ func (scan *Scan) prepareForWriting(cs *Checksums) (writeChecksums bool) {
 		switch special.Change {
 		case Added, Changed, TimestampChanged:
 			special.Type = special.Changed.Type
-			special.Modified = special.Changed.Modified
+			special.Modified = special.Changed.Modified.UTC()
 		}
 	}
 
+	// Sort entries in canonical mode.
+	if scan.cfg.Canonical && !cs.isSorted() {
+		cs.Sort()
+		writeChecksums = true
+	}
+
 	return writeChecksums
 }
 
-func (scan *Scan) writeChecksums(path string, cs *Checksums) (alg, sum string) {
+func (scan *Scan) updateMetadata(cs *Checksums) {
+	cs.UpdatedAt = scan.updatedAt
+	cs.UpdatedBy = scan.updatedBy
+	cs.Generator = Generator
+}
+
+func (scan *Scan) writeChecksums(path string, cs *Checksums, pathDir *Directory) (alg, sum string) {
 	stats := scan.Stats
 	defer stats.notify()
 
 	// First write all sub dirs.
 	for _, dir := range cs.Directories {
 		if dir.writeChecksums {
-			dir.Algorithm, dir.Digest = scan.writeChecksums(dir.Path, dir.Checksums)
+			dir.Algorithm, dir.Digest = scan.writeChecksums(dir.Path, dir.Checksums, dir)
 		}
 	}
 
+	// In canonical mode, only write checksum files if their content changed.
+	if scan.cfg.Canonical {
+		if cs.data != nil {
+			packed, err := PackChecksums(cs)
+			if err == nil && bytes.Equal(packed, cs.data) {
+				scan.Stats.WriteToDo.Add(^uint64(0)) // Decrement.
+
+				// Keep existing digest.
+				if pathDir != nil && pathDir.Verified {
+					return pathDir.Algorithm, pathDir.Digest
+				}
+				return scan.digestChecksums(path, packed)
+			}
+		}
+		scan.updateMetadata(cs)
+	}
+
 	// Serialize checksums.
 	packed, err := PackChecksums(cs)
 	if err != nil {
@@ -139,7 +205,11 @@ func (scan *Scan) writeChecksums(path string, cs *Checksums) (alg, sum string) {
 	}
 
 	// Digest for parent checksums.
-	sum, err = scan.cfg.DefaultHash.Digest(packed)
+	return scan.digestChecksums(path, packed)
+}
+
+func (scan *Scan) digestChecksums(path string, packed []byte) (alg, sum string) {
+	sum, err := scan.cfg.DefaultHash.DigestWithKey(packed, scan.cfg.Key)
 	if err != nil {
 		scan.writeErrs = append(scan.writeErrs, fmt.Sprintf("%s: hashing failed (non-critical): %s", path, err))
 		scan.Stats.WriteErrors.Add(1)
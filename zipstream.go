@@ -0,0 +1,339 @@
+package checkser
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Zip archives are indexed in the same pass as they are digested, by streaming
+// through the local file headers of their members. The central directory at
+// the end of the archive is kept in memory and is authoritative: it decides
+// which members exist, their names and their types, like when extracting.
+// Archives that cannot be streamed, eg. with encrypted members or stored
+// members of unknown size, are read a second time with random access.
+
+const (
+	zipLocalHeaderSig    = 0x04034b50
+	zipDataDescriptorSig = 0x08074b50
+	zipDirectoryEndSig   = 0x06054b50
+	zipLocalHeaderLen    = 30
+	zip64ExtraID         = 0x0001
+
+	zipFlagEncrypted      = 0x1
+	zipFlagDataDescriptor = 0x8
+
+	// maxZipTail is the maximum size of the central directory kept in memory.
+	maxZipTail = 64 << 20 // 64 MiB
+)
+
+// errZipNotStreamable is returned if a zip archive must be read with random access.
+var errZipNotStreamable = errors.New("zip archive cannot be streamed")
+
+// zipStream indexes a zip archive while it is read.
+type zipStream struct {
+	h   Hash
+	key []byte
+
+	r *bufio.Reader
+	// offset is the number of bytes consumed from r.
+	offset int64
+
+	// headers holds the local file headers by their offset.
+	headers map[int64][]byte
+	// members holds the digested members by the offset of their data.
+	members map[int64]*zipStreamMember
+
+	// tail holds the rest of the archive after the last member, starting at tailOffset.
+	tail       []byte
+	tailOffset int64
+}
+
+type zipStreamMember struct {
+	size   int64
+	crc    uint32
+	digest string
+	err    error
+}
+
+// indexZipStream digests all regular files of the zip archive read from r.
+// It returns errZipNotStreamable if the archive must be read with random
+// access. The errors of all members that cannot be read are returned.
+func indexZipStream(h Hash, key []byte, r io.Reader) ([]*Member, error) {
+	zs := &zipStream{
+		h:       h,
+		key:     key,
+		r:       bufio.NewReaderSize(r, 64<<10),
+		headers: make(map[int64][]byte),
+		members: make(map[int64]*zipStreamMember),
+	}
+	if err := zs.readMembers(); err != nil {
+		return nil, err
+	}
+	if err := zs.readTail(); err != nil {
+		return nil, err
+	}
+	return zs.index()
+}
+
+// Read implements io.Reader and counts the consumed bytes.
+func (zs *zipStream) Read(p []byte) (int, error) {
+	n, err := zs.r.Read(p)
+	zs.offset += int64(n)
+	return n, err
+}
+
+// ReadByte implements io.ByteReader, so that flate does not read ahead.
+func (zs *zipStream) ReadByte() (byte, error) {
+	b, err := zs.r.ReadByte()
+	if err == nil {
+		zs.offset++
+	}
+	return b, err
+}
+
+func (zs *zipStream) readMembers() error {
+	for {
+		sig, err := zs.r.Peek(4)
+		if err != nil {
+			return errZipNotStreamable
+		}
+		switch binary.LittleEndian.Uint32(sig) {
+		case zipLocalHeaderSig:
+			if err := zs.readMember(); err != nil {
+				return err
+			}
+		case zipDirectoryEndSig:
+			// Empty archive.
+			return nil
+		default:
+			if zs.offset == 0 {
+				// Data before the first member, eg. self-extracting archives.
+				return errZipNotStreamable
+			}
+			// Central directory.
+			return nil
+		}
+	}
+}
+
+// readMember reads the local file header and the data of a member.
+func (zs *zipStream) readMember() error {
+	headerOffset := zs.offset
+	header := make([]byte, zipLocalHeaderLen)
+	if _, err := io.ReadFull(zs, header); err != nil {
+		return err
+	}
+	var (
+		flags    = binary.LittleEndian.Uint16(header[6:])
+		method   = binary.LittleEndian.Uint16(header[8:])
+		crc      = binary.LittleEndian.Uint32(header[14:])
+		compSize = uint64(binary.LittleEndian.Uint32(header[18:]))
+		size     = uint64(binary.LittleEndian.Uint32(header[22:]))
+		nameLen  = int(binary.LittleEndian.Uint16(header[26:]))
+		extraLen = int(binary.LittleEndian.Uint16(header[28:]))
+	)
+	rest := make([]byte, nameLen+extraLen)
+	if _, err := io.ReadFull(zs, rest); err != nil {
+		return err
+	}
+	zs.headers[headerOffset] = append(header, rest...)
+
+	// Get sizes from zip64 extra field.
+	zip64 := false
+	for extra := rest[nameLen:]; len(extra) >= 4; {
+		id := binary.LittleEndian.Uint16(extra)
+		fieldLen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if fieldLen > len(extra)-4 {
+			break
+		}
+		field := extra[4 : 4+fieldLen]
+		extra = extra[4+fieldLen:]
+		if id != zip64ExtraID {
+			continue
+		}
+		zip64 = true
+		if size == 0xffffffff && len(field) >= 8 {
+			size = binary.LittleEndian.Uint64(field)
+			field = field[8:]
+		}
+		if compSize == 0xffffffff && len(field) >= 8 {
+			compSize = binary.LittleEndian.Uint64(field)
+		}
+	}
+
+	knownSize := flags&zipFlagDataDescriptor == 0
+	switch {
+	case flags&zipFlagEncrypted != 0,
+		method != zip.Store && method != zip.Deflate,
+		method == zip.Store && !knownSize,
+		compSize > 1<<62:
+		return errZipNotStreamable
+	}
+
+	// Digest data.
+	dataOffset := zs.offset
+	var data io.Reader = zs
+	if knownSize {
+		data = io.LimitReader(zs, int64(compSize)) //nolint:gosec // Checked above.
+	}
+	decompressed := data
+	if method == zip.Deflate {
+		fr := flate.NewReader(data)
+		defer fr.Close() //nolint:errcheck
+		decompressed = fr
+	}
+	member := &zipStreamMember{}
+	crcHash := crc32.NewIEEE()
+	counter := &countingWriter{}
+	member.digest, member.err = zs.h.DigestReaderWithKey(io.TeeReader(decompressed, io.MultiWriter(crcHash, counter)), zs.key)
+	member.size = counter.n
+	member.crc = crcHash.Sum32()
+
+	if knownSize {
+		// Skip the rest of the data, eg. after an error.
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return err
+		}
+	} else {
+		if member.err != nil {
+			// The end of the data is unknown.
+			return member.err
+		}
+
+		// Read data descriptor.
+		sig, err := zs.r.Peek(4)
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(sig) == zipDataDescriptorSig {
+			zs.offset += int64(len(sig))
+			_, _ = zs.r.Discard(len(sig))
+		}
+		descriptor := make([]byte, 12)
+		if zip64 {
+			descriptor = make([]byte, 20)
+		}
+		if _, err := io.ReadFull(zs, descriptor); err != nil {
+			return err
+		}
+		crc = binary.LittleEndian.Uint32(descriptor)
+		if zip64 {
+			size = binary.LittleEndian.Uint64(descriptor[12:])
+		} else {
+			size = uint64(binary.LittleEndian.Uint32(descriptor[8:]))
+		}
+	}
+
+	switch {
+	case member.err != nil:
+	case uint64(member.size) != size: //nolint:gosec // Sizes are never negative.
+		member.err = zip.ErrFormat
+	case member.crc != crc:
+		member.err = zip.ErrChecksum
+	}
+	zs.members[dataOffset] = member
+	return nil
+}
+
+// readTail reads the rest of the archive, which holds the central directory.
+func (zs *zipStream) readTail() error {
+	zs.tailOffset = zs.offset
+	tail, err := io.ReadAll(io.LimitReader(zs, maxZipTail+1))
+	switch {
+	case err != nil:
+		return err
+	case len(tail) > maxZipTail:
+		return errZipNotStreamable
+	}
+	zs.tail = tail
+	return nil
+}
+
+// index returns the members listed in the central directory.
+func (zs *zipStream) index() ([]*Member, error) {
+	zr, err := zip.NewReader(zs, zs.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*Member)
+	var errs []error
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		name := memberName(zf.Name)
+		if name == "" {
+			continue
+		}
+		dataOffset, err := zf.DataOffset()
+		if err != nil {
+			return nil, errZipNotStreamable
+		}
+		member, ok := zs.members[dataOffset]
+		switch {
+		case !ok:
+			// Member does not start at a local file header that was streamed.
+			return nil, errZipNotStreamable
+		case member.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", name, member.err))
+			continue
+		case uint64(member.size) != zf.UncompressedSize64: //nolint:gosec // Sizes are never negative.
+			errs = append(errs, fmt.Errorf("%s: %w", name, zip.ErrFormat))
+			continue
+		case member.crc != zf.CRC32:
+			errs = append(errs, fmt.Errorf("%s: %w", name, zip.ErrChecksum))
+			continue
+		}
+		// Later entries replace earlier ones, as when extracting.
+		index[name] = &Member{
+			Name:   name,
+			Size:   member.size,
+			Digest: member.digest,
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return sortedMembers(index), nil
+}
+
+// ReadAt implements io.ReaderAt for reading the central directory: the tail
+// and the local file headers are kept, everything else reads as zeros.
+func (zs *zipStream) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		switch {
+		case pos >= zs.offset:
+			return n, io.EOF
+		case pos >= zs.tailOffset:
+			n += copy(p[n:], zs.tail[pos-zs.tailOffset:])
+		case zs.headers[pos] != nil:
+			n += copy(p[n:], zs.headers[pos])
+		default:
+			p[n] = 0
+			n++
+		}
+	}
+	return n, nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}